@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/config"
+)
+
+// loadCredentials returns the PDS credentials from the environment or the
+// files configured in auth, or nil if none are configured.
+func loadCredentials(cfg *config.Config, identifier string) (*atproto.Credentials, error) {
+	if token := os.Getenv(config.AccessTokenEnv); token != "" {
+		return &atproto.Credentials{AccessToken: token}, nil
+	}
+	if password := os.Getenv(config.AppPasswordEnv); password != "" {
+		return &atproto.Credentials{Identifier: identifier, AppPassword: password}, nil
+	}
+
+	if cfg.Auth.AccessTokenFile != "" {
+		token, err := readSecret(cfg.Auth.AccessTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		return &atproto.Credentials{AccessToken: token}, nil
+	}
+	if cfg.Auth.AppPasswordFile != "" {
+		password, err := readSecret(cfg.Auth.AppPasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		return &atproto.Credentials{Identifier: identifier, AppPassword: password}, nil
+	}
+
+	return nil, nil
+}
+
+// readSecret reads a single-line secret from path.
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading credentials: %w", err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("reading credentials: %s is empty", path)
+	}
+	return secret, nil
+}
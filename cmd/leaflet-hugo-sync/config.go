@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+)
+
+// runConfig implements "config print", which shows the effective config
+// after merging files, overrides and defaults.
+func runConfig(args []string) {
+	if len(args) == 0 || args[0] != "print" {
+		log.Fatalf("usage: leaflet-hugo-sync config print [-config path]...")
+	}
+
+	fs := flag.NewFlagSet("config print", flag.ExitOnError)
+	var cf configFlags
+	cf.register(fs)
+	fs.Parse(args[1:])
+
+	cfg, err := cf.load()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	out, err := cfg.Marshal()
+	if err != nil {
+		log.Fatalf("failed to render config: %v", err)
+	}
+	os.Stdout.Write(out)
+}
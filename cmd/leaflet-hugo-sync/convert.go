@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/converter"
+)
+
+// runConvert converts a saved record to Markdown without any network access.
+// The file may contain either the bare record value or a record as returned
+// by listRecords/getRecord ({"uri": ..., "cid": ..., "value": ...}).
+func runConvert(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	embedStyle := fs.String("bsky-embed-style", "link", `How to render Bluesky posts: "link" or "shortcode"`)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: leaflet-hugo-sync convert [flags] <file.json>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		log.Fatal("convert: expected exactly one file")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("convert: %v", err)
+	}
+
+	var rec atproto.Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		log.Fatalf("convert: parsing %s: %v", fs.Arg(0), err)
+	}
+	value := rec.Value
+	if len(value) == 0 {
+		value = data
+	}
+
+	var doc atproto.LeafletDocument
+	if err := json.Unmarshal(value, &doc); err != nil {
+		log.Fatalf("convert: parsing %s: %v", fs.Arg(0), err)
+	}
+	if doc.Type != "pub.leaflet.document" {
+		log.Fatalf("convert: expected a pub.leaflet.document record, got %q", doc.Type)
+	}
+
+	result, err := converter.NewConverter(*embedStyle).ConvertLeaflet(&doc)
+	if err != nil {
+		log.Fatalf("convert: %v", err)
+	}
+
+	fmt.Print(result.Markdown)
+	for _, img := range result.Images {
+		fmt.Fprintf(os.Stderr, "image %s (%s) is not downloaded in offline mode\n", img.Blob.Ref.Link, img.Blob.Mime)
+	}
+	for _, m := range result.Media {
+		fmt.Fprintf(os.Stderr, "%s %s (%s) is not downloaded in offline mode\n", m.Kind, m.Blob.Ref.Link, m.Blob.Mime)
+	}
+}
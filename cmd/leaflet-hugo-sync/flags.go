@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strings"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/config"
+)
+
+const defaultConfigPath = ".leaflet-sync.yaml"
+
+// stringList is a flag.Value collecting repeated flags.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// configFlags holds the flags shared by every command that loads a config.
+type configFlags struct {
+	paths     stringList
+	overrides stringList
+
+	handle      string
+	publication string
+	postsDir    string
+	imagesDir   string
+}
+
+func (f *configFlags) register(fs *flag.FlagSet) {
+	fs.Var(&f.paths, "config", "Path to config file; repeat to layer files, later ones override earlier ones (default "+defaultConfigPath+")")
+	fs.Var(&f.overrides, "set", "Override a config key, e.g. -set output.posts_dir=content/preview or -set 'images.widths=[480, 960]' (repeatable)")
+	fs.StringVar(&f.handle, "handle", "", "Override source.handle")
+	fs.StringVar(&f.publication, "publication", "", "Override source.publication_name")
+	fs.StringVar(&f.postsDir, "posts-dir", "", "Override output.posts_dir")
+	fs.StringVar(&f.imagesDir, "images-dir", "", "Override output.images_dir")
+}
+
+func (f *configFlags) load() (*config.Config, error) {
+	paths := []string(f.paths)
+	if len(paths) == 0 {
+		paths = []string{defaultConfigPath}
+	}
+
+	overrides := []string(f.overrides)
+	for _, o := range []struct{ key, value string }{
+		{"source.handle", f.handle},
+		{"source.publication_name", f.publication},
+		{"output.posts_dir", f.postsDir},
+		{"output.images_dir", f.imagesDir},
+	} {
+		if o.value != "" {
+			overrides = append(overrides, o.key+"="+o.value)
+		}
+	}
+
+	return config.Load(paths, config.LoadOptions{Overrides: overrides})
+}
+
+// loadOptional is like load, for commands that only need the network and
+// identity settings: without -config, a missing default file means the
+// defaults.
+func (f *configFlags) loadOptional() (*config.Config, error) {
+	if len(f.paths) == 0 {
+		if _, err := os.Stat(defaultConfigPath); errors.Is(err, os.ErrNotExist) {
+			var cfg config.Config
+			cfg.ApplyDefaults()
+			return &cfg, nil
+		}
+	}
+	return f.load()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/httpclient"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/media"
+)
+
+// runGC removes downloaded media that no generated post references anymore.
+func runGC(args []string) {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	var cf configFlags
+	cf.register(flags)
+	dryRun := flags.Bool("dry-run", false, "Only report the files that would be removed")
+	flags.Parse(args)
+
+	cfg, err := cf.load()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	httpClient, err := httpclient.New(cfg.Network.HTTPOptions())
+	if err != nil {
+		log.Fatalf("failed to set up HTTP client: %v", err)
+	}
+	storage, err := newStorage(cfg, httpClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	idx, err := media.LoadIndex(mediaIndexFile(cfg))
+	if err != nil {
+		log.Fatalf("failed to load media index: %v", err)
+	}
+	posts, err := readPosts(cfg.Output.PostsDir)
+	if err != nil {
+		log.Fatalf("failed to read posts: %v", err)
+	}
+	if len(posts) == 0 {
+		// Most likely a wrong posts_dir; don't take every image with it.
+		log.Fatalf("no posts found in %s, refusing to remove all media", cfg.Output.PostsDir)
+	}
+
+	referenced := func(name string) bool {
+		for _, post := range posts {
+			if strings.Contains(post, name) {
+				return true
+			}
+		}
+		return false
+	}
+	ctx, cancel := runContext(cfg)
+	defer cancel()
+	removed, err := idx.CollectGarbage(ctx, storage, referenced, *dryRun)
+	for _, path := range removed {
+		if *dryRun {
+			fmt.Printf("Would remove %s\n", path)
+		} else {
+			fmt.Printf("Removed %s\n", path)
+		}
+	}
+	if err != nil {
+		log.Fatalf("failed to remove unused media: %v", err)
+	}
+	fmt.Printf("%d unused files\n", len(removed))
+}
+
+// readPosts returns the content of all Markdown files below dir.
+func readPosts(dir string) ([]string, error) {
+	var posts []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".md" {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		posts = append(posts, string(data))
+		return nil
+	})
+	return posts, err
+}
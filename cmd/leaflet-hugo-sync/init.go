@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/scaffold"
+)
+
+// runInit writes a starter config and the shortcodes into a Hugo site.
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	handle := fs.String("handle", "", "Bluesky handle to put in the starter config")
+	force := fs.Bool("force", false, "Overwrite existing files")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: leaflet-hugo-sync init [flags] [hugo-site-dir]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	siteDir := "."
+	if fs.NArg() > 0 {
+		siteDir = fs.Arg(0)
+	}
+
+	written, skipped, err := scaffold.Write(siteDir, *handle, *force)
+	for _, p := range written {
+		fmt.Printf("Wrote %s\n", p)
+	}
+	for _, p := range skipped {
+		fmt.Printf("Skipped %s (already exists, use -force to overwrite)\n", p)
+	}
+	if err != nil {
+		log.Fatalf("init: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/httpclient"
+)
+
+// runInspect pretty-prints a single record and summarises its blocks.
+func runInspect(args []string) {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	var cf configFlags
+	fs.Var(&cf.paths, "config", "Path to config file for the network and identity settings; repeat to layer files (default "+defaultConfigPath+" if it exists)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: leaflet-hugo-sync inspect [-config <file>] at://<did-or-handle>/<collection>/<rkey>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		log.Fatal("inspect: expected exactly one AT-URI")
+	}
+
+	repo, collection, rkey, err := atproto.ParseATURI(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("inspect: %v", err)
+	}
+
+	cfg, err := cf.loadOptional()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	ctx, cancel := runContext(cfg)
+	defer cancel()
+	httpClient, err := httpclient.New(cfg.Network.HTTPOptions())
+	if err != nil {
+		log.Fatalf("failed to set up HTTP client: %v", err)
+	}
+	resolver := newResolver(cfg, httpClient)
+	did, pdsClient, err := connect(ctx, resolver, httpClient, repo)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pds := newPDSSource(resolver, httpClient, did, pdsClient.XRPC.Host, nil)
+	rec, err := pds.GetRecord(ctx, fmt.Sprintf("at://%s/%s/%s", did, collection, rkey))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	printRecord(rec)
+}
+
+// printRecord writes the raw record and, for Leaflet documents, one line per
+// block with its type.
+func printRecord(rec *atproto.Record) {
+	fmt.Printf("URI: %s\n", rec.Uri)
+	fmt.Printf("CID: %s\n\n", rec.Cid)
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, rec.Value, "", "  "); err != nil {
+		fmt.Printf("(invalid JSON: %v)\n", err)
+		return
+	}
+	fmt.Println(pretty.String())
+
+	var doc atproto.LeafletDocument
+	if err := json.Unmarshal(rec.Value, &doc); err != nil || doc.Type != "pub.leaflet.document" {
+		return
+	}
+
+	fmt.Println()
+	for i, page := range doc.Pages {
+		fmt.Printf("Page %d (%s): %d blocks\n", i, page.Type, len(page.Blocks))
+		for j, bw := range page.Blocks {
+			var base atproto.BaseBlock
+			if err := json.Unmarshal(bw.Block, &base); err != nil {
+				fmt.Printf("  %3d  (invalid block: %v)\n", j, err)
+				continue
+			}
+			fmt.Printf("  %3d  %-36s %s\n", j, base.Type, blockSummary(base.Type, bw.Block))
+		}
+	}
+}
+
+// blockSummary returns a short, single-line description of a block.
+func blockSummary(blockType string, raw json.RawMessage) string {
+	switch blockType {
+	case "pub.leaflet.blocks.text", "pub.leaflet.blocks.code":
+		var b atproto.TextBlock
+		if json.Unmarshal(raw, &b) == nil {
+			return quoteShort(b.Plaintext)
+		}
+	case "pub.leaflet.blocks.image":
+		var b atproto.ImageBlock
+		if json.Unmarshal(raw, &b) == nil {
+			return fmt.Sprintf("%s %s (%d bytes) alt=%s", b.Image.Ref.Link, b.Image.Mime, b.Image.Size, quoteShort(b.Alt))
+		}
+	case "pub.leaflet.blocks.video":
+		var b atproto.VideoBlock
+		if json.Unmarshal(raw, &b) == nil {
+			return fmt.Sprintf("%s %s (%d bytes) alt=%s", b.Video.Ref.Link, b.Video.Mime, b.Video.Size, quoteShort(b.Alt))
+		}
+	case "pub.leaflet.blocks.file":
+		var b atproto.FileBlock
+		if json.Unmarshal(raw, &b) == nil {
+			return fmt.Sprintf("%s %s (%d bytes) name=%s", b.File.Ref.Link, b.File.Mime, b.File.Size, quoteShort(b.Name))
+		}
+	case "pub.leaflet.blocks.unorderedList":
+		var b atproto.UnorderedListBlock
+		if json.Unmarshal(raw, &b) == nil {
+			return fmt.Sprintf("%d items", len(b.Children))
+		}
+	case "pub.leaflet.blocks.bskyPost":
+		var b atproto.BskyPostBlock
+		if json.Unmarshal(raw, &b) == nil {
+			return b.PostRef.Uri
+		}
+	}
+	return ""
+}
+
+func quoteShort(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > 60 {
+		s = string(r[:60]) + "…"
+	}
+	return fmt.Sprintf("%q", s)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBlockSummary_Media(t *testing.T) {
+	tests := []struct {
+		blockType, raw, expected string
+	}{
+		{
+			"pub.leaflet.blocks.video",
+			`{"$type":"pub.leaflet.blocks.video","video":{"ref":{"$link":"bafyvideo"},"mimeType":"video/mp4","size":2048},"alt":"A demo"}`,
+			`bafyvideo video/mp4 (2048 bytes) alt="A demo"`,
+		},
+		{
+			"pub.leaflet.blocks.file",
+			`{"$type":"pub.leaflet.blocks.file","file":{"ref":{"$link":"bafyfile"},"mimeType":"application/pdf","size":512},"name":"slides.pdf"}`,
+			`bafyfile application/pdf (512 bytes) name="slides.pdf"`,
+		},
+	}
+	for _, tt := range tests {
+		if got := blockSummary(tt.blockType, json.RawMessage(tt.raw)); got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.blockType, tt.expected, got)
+		}
+	}
+}
+
+func TestConfigFlags_LoadOptional(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	var cf configFlags
+	cfg, err := cf.loadOptional()
+	if err != nil {
+		t.Fatalf("loadOptional failed: %v", err)
+	}
+	if cfg.Identity.PLCDirectory == "" || cfg.Network.MaxAttempts == 0 {
+		t.Errorf("expected the defaults without a config file, got %+v", cfg)
+	}
+
+	cf.paths = stringList{"missing.yaml"}
+	if _, err := cf.loadOptional(); err == nil {
+		t.Error("expected an error for a missing -config file")
+	}
+}
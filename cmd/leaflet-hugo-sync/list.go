@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/httpclient"
+)
+
+// runList prints the publications and documents in the configured repo.
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	var cf configFlags
+	cf.register(fs)
+	fs.Parse(args)
+
+	cfg, err := cf.load()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	ctx, cancel := runContext(cfg)
+	defer cancel()
+	httpClient, err := httpclient.New(cfg.Network.HTTPOptions())
+	if err != nil {
+		log.Fatalf("failed to set up HTTP client: %v", err)
+	}
+	did, source, _, err := openSource(ctx, cfg, newResolver(cfg, httpClient), httpClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pubRecords, err := source.FetchEntries(ctx, did, "pub.leaflet.publication")
+	if err != nil {
+		log.Fatalf("failed to fetch publications: %v", err)
+	}
+	docRecords, err := source.FetchEntries(ctx, did, cfg.Source.Collection)
+	if err != nil {
+		log.Fatalf("failed to fetch entries: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "\nPUBLICATIONS (%d)\n", len(pubRecords))
+	fmt.Fprintln(w, "URI\tNAME\tCID")
+	for _, rec := range pubRecords {
+		var pub atproto.LeafletPublication
+		if err := json.Unmarshal(rec.Value, &pub); err != nil {
+			fmt.Fprintf(w, "%s\t(invalid: %v)\t%s\n", rec.Uri, err, rec.Cid)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", rec.Uri, pub.Name, rec.Cid)
+	}
+
+	fmt.Fprintf(w, "\nDOCUMENTS (%d)\n", len(docRecords))
+	fmt.Fprintln(w, "URI\tTITLE\tPUBLISHED\tCID")
+	for _, rec := range docRecords {
+		var doc atproto.LeafletDocument
+		if err := json.Unmarshal(rec.Value, &doc); err != nil {
+			fmt.Fprintf(w, "%s\t(invalid: %v)\t\t%s\n", rec.Uri, err, rec.Cid)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", rec.Uri, doc.Title, doc.PublishedAt, rec.Cid)
+	}
+
+	w.Flush()
+}
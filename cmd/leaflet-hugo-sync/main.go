@@ -1,18 +1,9 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
-	"flag"
 	"fmt"
-	"log"
+	"os"
 	"strings"
-
-	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
-	"mariuskimmina.com/leaflet-hugo-sync/internal/config"
-	"mariuskimmina.com/leaflet-hugo-sync/internal/converter"
-	"mariuskimmina.com/leaflet-hugo-sync/internal/generator"
-	"mariuskimmina.com/leaflet-hugo-sync/internal/media"
 )
 
 func lastPathPart(uri string) string {
@@ -29,148 +20,52 @@ func sanitizeTitle(title string) string {
 	return s
 }
 
-func main() {
-	configPath := flag.String("config", ".leaflet-sync.yaml", "Path to config file")
-	flag.Parse()
-
-	cfg, err := config.LoadConfig(*configPath)
-	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
-	}
-
-	ctx := context.Background()
-
-	// 1. Resolve Handle to DID using public resolver (bsky.social)
-	baseClient := atproto.NewClient("https://bsky.social")
-	did, err := baseClient.ResolveHandle(ctx, cfg.Source.Handle)
-	if err != nil {
-		log.Fatalf("failed to resolve handle: %v", err)
-	}
-	fmt.Printf("Resolved %s to %s\n", cfg.Source.Handle, did)
-
-	// 2. Resolve PDS Endpoint for the DID
-	pdsEndpoint, err := baseClient.ResolvePDS(ctx, did)
-	if err != nil {
-		log.Fatalf("failed to resolve PDS: %v", err)
-	}
-	fmt.Printf("PDS Endpoint: %s\n", pdsEndpoint)
-
-	// 3. Connect to User's PDS
-	pdsClient := atproto.NewClient(pdsEndpoint)
-
-	// 4. Resolve Publication (if configured)
-	var publicationURI string
-	if cfg.Source.PublicationName != "" {
-		fmt.Printf("Resolving publication '%s'...\n", cfg.Source.PublicationName)
-		pubRecords, err := pdsClient.FetchEntries(ctx, did, "pub.leaflet.publication")
-		if err != nil {
-			log.Fatalf("failed to fetch publications: %v", err)
-		}
-
-		for _, rec := range pubRecords {
-			var pub atproto.LeafletPublication
-			if err := json.Unmarshal(rec.Value, &pub); err == nil {
-				if pub.Name == cfg.Source.PublicationName {
-					publicationURI = rec.Uri
-					fmt.Printf("Found publication URI: %s\n", publicationURI)
-					break
-				}
-			}
-		}
+const usage = `Usage: leaflet-hugo-sync <command> [flags]
+
+Commands:
+  sync            Sync Leaflet documents into Hugo posts (default)
+  watch           Keep syncing as documents change
+  serve           Run syncs on HTTP requests
+  list            List publications and documents
+  inspect <uri>   Pretty-print a record and its blocks
+  convert <file>  Convert a saved record to Markdown on stdout
+  gc              Remove downloaded media no post references anymore
+  init [dir]      Write a starter config and shortcodes into a Hugo site
+  config print    Show the effective config
+
+Run 'leaflet-hugo-sync <command> -h' for the flags of a command.
+`
+
+var commands = map[string]func(args []string){
+	"sync":    runSync,
+	"watch":   runWatch,
+	"serve":   runServe,
+	"list":    runList,
+	"inspect": runInspect,
+	"convert": runConvert,
+	"gc":      runGC,
+	"init":    runInit,
+	"config":  runConfig,
+}
 
-		if publicationURI == "" {
-			log.Fatalf("Publication '%s' not found", cfg.Source.PublicationName)
+func main() {
+	args := os.Args[1:]
+
+	// Without a command (or with only flags) default to sync, so existing
+	// invocations like "leaflet-hugo-sync -config x.yaml" keep working.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+			fmt.Print(usage)
+			return
 		}
+		runSync(args)
+		return
 	}
 
-	// 5. Fetch Entries
-	// Update collection to Leaflet Document if user hasn't specified it
-	collection := cfg.Source.Collection
-	if collection == "com.whtwnd.blog.entry" {
-		fmt.Println("Warning: Defaulting to 'pub.leaflet.document' as 'com.whtwnd.blog.entry' seems deprecated/unused for Leaflet.")
-		collection = "pub.leaflet.document"
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		os.Exit(2)
 	}
-
-	records, err := pdsClient.FetchEntries(ctx, did, collection)
-	if err != nil {
-		log.Fatalf("failed to fetch entries: %v", err)
-	}
-
-	fmt.Printf("Found %d entries\n", len(records))
-
-	downloader := media.NewDownloader(cfg.Output.ImagesDir, cfg.Output.ImagePathPrefix, pdsClient.XRPC.Host)
-	gen := generator.NewGenerator(cfg)
-	conv := converter.NewConverter(cfg.Output.BskyEmbedStyle)
-
-	for _, rec := range records {
-		// Try to unmarshal as LeafletDocument
-		var doc atproto.LeafletDocument
-
-		// Check type first
-		var typeCheck struct {
-			Type string `json:"$type"`
-		}
-		if err := json.Unmarshal(rec.Value, &typeCheck); err != nil {
-			fmt.Printf("Failed to check type for record %s: %v\n", rec.Uri, err)
-			continue
-		}
-
-		if typeCheck.Type != "pub.leaflet.document" {
-			// Skip or try legacy
-			continue
-		}
-
-		if err := json.Unmarshal(rec.Value, &doc); err != nil {
-			fmt.Printf("Failed to unmarshal record %s: %v\n", rec.Uri, err)
-			continue
-		}
-
-		// Filter by Publication
-		if publicationURI != "" && doc.Publication != publicationURI {
-			continue
-		}
-
-		fmt.Printf("Processing: %s\n", doc.Title)
-
-		// Convert to Markdown
-		result, err := conv.ConvertLeaflet(&doc)
-		if err != nil {
-			fmt.Printf("  Failed to convert document: %v\n", err)
-			continue
-		}
-
-		// Download Images
-		finalContent := result.Markdown
-		for _, imgRef := range result.Images {
-			localPath, err := downloader.DownloadBlob(ctx, did, imgRef.Blob.Ref.Link)
-			if err != nil {
-				fmt.Printf("  Failed to download image: %v\n", err)
-				continue
-			}
-			finalContent = strings.ReplaceAll(finalContent, imgRef.Blob.Ref.Link, localPath)
-		}
-
-		// Generate filename from title and slug from URI
-		slug := lastPathPart(rec.Uri)
-		filename := sanitizeTitle(doc.Title)
-
-		// Construct original URL
-		originalURL := fmt.Sprintf("https://leaflet.pub/%s", slug)
-
-		postData := generator.PostData{
-			Title:       doc.Title,
-			CreatedAt:   doc.PublishedAt,
-			Slug:        slug,
-			Filename:    filename,
-			Handle:      cfg.Source.Handle,
-			OriginalURL: originalURL,
-			Content:     finalContent,
-		}
-
-		if err := gen.GeneratePost(postData); err != nil {
-			fmt.Printf("  Failed to generate post: %v\n", err)
-		}
-	}
-
-	fmt.Println("Done!")
+	cmd(args[1:])
 }
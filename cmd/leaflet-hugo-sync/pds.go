@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/identity"
+)
+
+// pdsSource is the record source of a repo on its PDS. When the PDS can't
+// be reached or no longer hosts the repo, e.g. after an account migration
+// within the identity cache's TTL, it resolves the DID again, bypassing the
+// cache, and retries once on the new PDS. Since the resolver's cache is
+// updated, blob URLs follow the new host as well.
+type pdsSource struct {
+	resolver   *identity.Resolver
+	httpClient *http.Client
+	did        string
+	creds      *atproto.Credentials
+
+	mu        sync.Mutex
+	host      string
+	client    *atproto.Client
+	refreshed bool
+}
+
+func newPDSSource(resolver *identity.Resolver, httpClient *http.Client, did, host string, creds *atproto.Credentials) *pdsSource {
+	return &pdsSource{resolver: resolver, httpClient: httpClient, did: did, host: host, creds: creds}
+}
+
+// Host returns the PDS currently used.
+func (p *pdsSource) Host() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.host
+}
+
+// connect returns the client of the current PDS, logging in first if
+// credentials are configured.
+func (p *pdsSource) connect(ctx context.Context) (*atproto.Client, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == nil {
+		client := atproto.NewClient(p.host, p.httpClient)
+		if p.creds != nil {
+			if err := client.Login(ctx, *p.creds); err != nil {
+				return nil, p.host, fmt.Errorf("failed to authenticate: %w", err)
+			}
+			fmt.Printf("Authenticated as %s\n", p.did)
+		}
+		p.client = client
+	}
+	return p.client, p.host, nil
+}
+
+// moved reports whether the repo is now hosted elsewhere than host,
+// resolving the DID again the first time it's asked.
+func (p *pdsSource) moved(ctx context.Context, host string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.host != host {
+		return true
+	}
+	if p.refreshed {
+		return false
+	}
+	p.refreshed = true
+
+	doc, err := p.resolver.RefreshDID(ctx, p.did)
+	if err != nil {
+		fmt.Printf("Warning: failed to resolve %s again: %v\n", p.did, err)
+		return false
+	}
+	endpoint, err := doc.PDSEndpoint()
+	if err != nil || endpoint == host {
+		return false
+	}
+	fmt.Printf("PDS Endpoint: %s\n", endpoint)
+	p.host, p.client = endpoint, nil
+	return true
+}
+
+// open connects to the PDS, so that login errors surface early.
+func (p *pdsSource) open(ctx context.Context) error {
+	return p.call(ctx, func(*atproto.Client) error { return nil })
+}
+
+// call runs fn with the client of the current PDS, and once more on the new
+// PDS if the repo has moved.
+func (p *pdsSource) call(ctx context.Context, fn func(*atproto.Client) error) error {
+	client, host, err := p.connect(ctx)
+	if err == nil {
+		err = fn(client)
+	}
+	if err == nil || !atproto.IsRepoUnavailable(err) || !p.moved(ctx, host) {
+		return err
+	}
+	if client, _, err = p.connect(ctx); err != nil {
+		return err
+	}
+	return fn(client)
+}
+
+func (p *pdsSource) FetchEntries(ctx context.Context, repo string, collection string) ([]atproto.Record, error) {
+	var records []atproto.Record
+	err := p.EachRecord(ctx, repo, collection, func(rec atproto.Record) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (p *pdsSource) EachRecord(ctx context.Context, repo string, collection string, fn func(atproto.Record) error) error {
+	// Records are listed in the same order on every PDS, so a retry skips
+	// those already delivered.
+	delivered := 0
+	err := p.call(ctx, func(client *atproto.Client) error {
+		skip := delivered
+		return client.EachRecord(ctx, repo, collection, func(rec atproto.Record) error {
+			if skip > 0 {
+				skip--
+				return nil
+			}
+			delivered++
+			if err := fn(rec); err != nil {
+				return callbackError{err}
+			}
+			return nil
+		})
+	})
+	if cerr, ok := err.(callbackError); ok {
+		return cerr.err
+	}
+	return err
+}
+
+// callbackError is an error returned by the caller's callback, which
+// retrying on another PDS can't fix. It deliberately doesn't unwrap.
+type callbackError struct{ err error }
+
+func (e callbackError) Error() string { return e.err.Error() }
+
+func (p *pdsSource) GetRecord(ctx context.Context, uri string) (*atproto.Record, error) {
+	var rec *atproto.Record
+	err := p.call(ctx, func(client *atproto.Client) error {
+		var err error
+		rec, err = client.GetRecord(ctx, uri)
+		return err
+	})
+	return rec, err
+}
+
+// FetchRepo downloads the repo as a CAR archive.
+func (p *pdsSource) FetchRepo(ctx context.Context) (*atproto.Archive, error) {
+	var archive *atproto.Archive
+	err := p.call(ctx, func(client *atproto.Client) error {
+		var err error
+		archive, err = client.FetchRepo(ctx, p.did)
+		return err
+	})
+	return archive, err
+}
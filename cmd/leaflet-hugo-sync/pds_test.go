@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/identity"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/media"
+)
+
+func pdsDocument(did, endpoint string) *identity.DIDDocument {
+	return &identity.DIDDocument{
+		ID: did,
+		Service: []identity.Service{{
+			ID:              "#atproto_pds",
+			Type:            "AtprotoPersonalDataServer",
+			ServiceEndpoint: endpoint,
+		}},
+	}
+}
+
+func TestPDSSource_MigratedWithinTTL(t *testing.T) {
+	oldPDS := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"RepoNotFound","message":"Could not find repo"}`))
+	}))
+	defer oldPDS.Close()
+	newPDS := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(atproto.ListRecordsResponse{Records: []atproto.Record{
+			{Uri: "at://" + testDID + "/" + testCollection + "/a", Cid: "bafya", Value: json.RawMessage(`{}`)},
+		}})
+	}))
+	defer newPDS.Close()
+	plc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(pdsDocument(testDID, newPDS.URL))
+	}))
+	defer plc.Close()
+
+	// The cache still holds the document from before the migration.
+	resolver := identity.NewResolver(plc.URL, "", nil)
+	resolver.Cache.PutDocument(testDID, pdsDocument(testDID, oldPDS.URL))
+
+	ctx := context.Background()
+	source := newPDSSource(resolver, nil, testDID, oldPDS.URL, nil)
+	records, err := source.FetchEntries(ctx, testDID, testCollection)
+	if err != nil {
+		t.Fatalf("FetchEntries failed: %v", err)
+	}
+	if len(records) != 1 {
+		t.Errorf("expected 1 record from the new PDS, got %d", len(records))
+	}
+	if host := source.Host(); host != newPDS.URL {
+		t.Errorf("expected host %s, got %s", newPDS.URL, host)
+	}
+
+	downloader := media.NewDownloader(t.TempDir(), "/images", oldPDS.URL, nil)
+	downloader.HostFor = resolver.ResolvePDS
+	blobURL, err := downloader.RemoteURL(ctx, testDID, "bafyblob")
+	if err != nil {
+		t.Fatalf("RemoteURL failed: %v", err)
+	}
+	if !strings.HasPrefix(blobURL, newPDS.URL+"/") {
+		t.Errorf("expected blob URL on the new PDS, got %s", blobURL)
+	}
+}
+
+func TestPDSSource_CallbackErrorNotRetried(t *testing.T) {
+	var requests int
+	pds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		json.NewEncoder(w).Encode(atproto.ListRecordsResponse{Records: []atproto.Record{
+			{Uri: "at://" + testDID + "/" + testCollection + "/a", Cid: "bafya", Value: json.RawMessage(`{}`)},
+		}})
+	}))
+	defer pds.Close()
+	plc := httptest.NewServer(http.NotFoundHandler())
+	defer plc.Close()
+
+	source := newPDSSource(identity.NewResolver(plc.URL, "", nil), nil, testDID, pds.URL, nil)
+	// A failed blob download looks like an unreachable PDS.
+	failed := &url.Error{Op: "Get", URL: "https://cdn.example.com/blob", Err: errors.New("connection refused")}
+	err := source.EachRecord(context.Background(), testDID, testCollection, func(atproto.Record) error {
+		return failed
+	})
+	if err != failed {
+		t.Errorf("expected the callback's error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 request, got %d", requests)
+	}
+}
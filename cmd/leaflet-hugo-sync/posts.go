@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/config"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/converter"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/generator"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/media"
+)
+
+// postSyncer turns document records into Hugo posts. Documents are
+// converted and their media downloaded in parallel, but posts are written
+// and progress is printed in record order, so the result of a sync doesn't
+// depend on scheduling.
+type postSyncer struct {
+	cfg     *config.Config
+	did     string
+	pdsHost string
+	source  atproto.RecordSource
+	// collection holds the documents; publicationURI, if set, selects the
+	// ones to sync.
+	collection     string
+	publicationURI string
+	conv           *converter.Converter
+	gen            *generator.Generator
+	// posts records the post written for each record.
+	posts      *generator.PostIndex
+	downloader *media.Downloader
+	// downloads bounds concurrent blob downloads across all documents.
+	downloads chan struct{}
+}
+
+// syncReport describes what a sync did.
+type syncReport struct {
+	Processed int           `json:"processed"`         // Records seen
+	Written   []string      `json:"written"`           // Posts written
+	Removed   []string      `json:"removed,omitempty"` // Posts removed because their record is gone, renamed or no longer synced
+	Failed    []syncFailure `json:"failed,omitempty"`  // Records that couldn't be synced
+}
+
+// err returns an error if any record failed.
+func (r syncReport) err() error {
+	switch len(r.Failed) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("failed to sync %s: %s", r.Failed[0].URI, r.Failed[0].Error)
+	default:
+		return fmt.Errorf("failed to sync %d records", len(r.Failed))
+	}
+}
+
+// syncFailure is a record that couldn't be synced.
+type syncFailure struct {
+	URI   string `json:"uri"`
+	Error string `json:"error"`
+}
+
+// syncAll syncs every document, processing them as they are listed.
+func (s *postSyncer) syncAll(ctx context.Context) (syncReport, error) {
+	report, err := s.syncRecords(ctx, func(fn func(atproto.Record) error) error {
+		return s.source.EachRecord(ctx, s.did, s.collection, fn)
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to fetch entries: %w", err)
+	}
+	return report, nil
+}
+
+// syncOne syncs the document at uri. A missing record is reported as
+// atproto.ErrRecordNotFound.
+func (s *postSyncer) syncOne(ctx context.Context, uri string) (syncReport, error) {
+	rec, err := s.source.GetRecord(ctx, uri)
+	if err != nil {
+		return syncReport{Written: []string{}}, fmt.Errorf("failed to fetch %s: %w", uri, err)
+	}
+	return s.syncRecords(ctx, func(fn func(atproto.Record) error) error {
+		return fn(*rec)
+	})
+}
+
+// removePost deletes the post written for the record at uri, e.g. after
+// the record was deleted. It returns the path of the removed post, or ""
+// if there was none.
+func (s *postSyncer) removePost(uri string) (string, error) {
+	path, ok := s.posts.Lookup(uri)
+	if !ok {
+		fmt.Printf("No post to remove for %s\n", uri)
+		return "", nil
+	}
+	fmt.Printf("Removing: %s\n", path)
+	if err := s.gen.RemovePost(path); err != nil {
+		return "", err
+	}
+	return path, s.posts.Remove(uri)
+}
+
+// recordURI returns the AT-URI of a document given either as an AT-URI in
+// the synced repo or as a record key.
+func (s *postSyncer) recordURI(ref string) (string, error) {
+	if !strings.HasPrefix(ref, "at://") {
+		if strings.Contains(ref, "/") {
+			return "", fmt.Errorf("expected an AT-URI or record key, got %q", ref)
+		}
+		return fmt.Sprintf("at://%s/%s/%s", s.did, s.collection, ref), nil
+	}
+	repo, collection, rkey, err := atproto.ParseATURI(ref)
+	if err != nil {
+		return "", err
+	}
+	if repo != s.did && !strings.EqualFold(repo, s.cfg.Source.Handle) {
+		return "", fmt.Errorf("%s is not in the repo of %s", ref, s.cfg.Source.Handle)
+	}
+	if collection != s.collection {
+		return "", fmt.Errorf("%s is not in collection %s", ref, s.collection)
+	}
+	return fmt.Sprintf("at://%s/%s/%s", s.did, collection, rkey), nil
+}
+
+// syncRecords prepares up to concurrency.posts records at a time, as
+// eachRecord delivers them, and writes their posts in order. It returns
+// what was done, along with the error that stopped eachRecord, if any.
+func (s *postSyncer) syncRecords(ctx context.Context, eachRecord func(fn func(atproto.Record) error) error) (syncReport, error) {
+	type job struct {
+		uri  string
+		log  bytes.Buffer
+		post *generator.PostData
+		err  error
+		done chan struct{}
+	}
+	jobs := make(chan *job, s.cfg.Concurrency.Posts)
+	workers := make(chan struct{}, s.cfg.Concurrency.Posts)
+	var listErr error
+	go func() {
+		defer close(jobs)
+		listErr = eachRecord(func(rec atproto.Record) error {
+			j := &job{uri: rec.Uri, done: make(chan struct{})}
+			workers <- struct{}{}
+			jobs <- j
+			go func() {
+				defer func() {
+					<-workers
+					close(j.done)
+				}()
+				j.post, j.err = s.prepare(ctx, rec, &j.log)
+			}()
+			return nil
+		})
+	}()
+
+	report := syncReport{Written: []string{}}
+	for j := range jobs {
+		<-j.done
+		report.Processed++
+		os.Stdout.Write(j.log.Bytes())
+		previous, indexed := s.posts.Lookup(j.uri)
+		switch {
+		case j.err != nil:
+		case j.post != nil:
+			if err := s.gen.GeneratePostFrom(*j.post, previous); err != nil {
+				j.err = fmt.Errorf("generating post: %w", err)
+			} else if path := s.gen.PostPath(*j.post); indexed && previous != path {
+				// A new title means a new file; the old one has to go. It
+				// stays indexed until it is gone, so a failure is retried.
+				fmt.Printf("Removing: %s\n", previous)
+				if err := s.gen.RemovePost(previous); err != nil {
+					j.err = fmt.Errorf("removing renamed post: %w", err)
+				} else {
+					report.Removed = append(report.Removed, previous)
+				}
+			}
+		case indexed:
+			// The document was synced before, but is now skipped, e.g.
+			// turned back into a draft or moved to another publication.
+			var path string
+			if path, j.err = s.removePost(j.uri); path != "" {
+				report.Removed = append(report.Removed, path)
+			}
+		}
+		if j.err != nil {
+			fmt.Printf("Failed to sync %s: %v\n", j.uri, j.err)
+			report.Failed = append(report.Failed, syncFailure{URI: j.uri, Error: j.err.Error()})
+			continue
+		}
+		if j.post == nil {
+			continue
+		}
+		path := s.gen.PostPath(*j.post)
+		report.Written = append(report.Written, path)
+		if err := s.posts.Set(j.uri, path); err != nil {
+			fmt.Printf("  Failed to record post: %v\n", err)
+		}
+	}
+	return report, listErr
+}
+
+// prepare converts a record and stores its media, reporting progress to w.
+// It returns nil for records that are skipped.
+func (s *postSyncer) prepare(ctx context.Context, rec atproto.Record, w io.Writer) (*generator.PostData, error) {
+	// Try to unmarshal as LeafletDocument
+	var doc atproto.LeafletDocument
+
+	// Check type first
+	var typeCheck struct {
+		Type string `json:"$type"`
+	}
+	if err := json.Unmarshal(rec.Value, &typeCheck); err != nil {
+		return nil, fmt.Errorf("checking type: %w", err)
+	}
+
+	if typeCheck.Type != "pub.leaflet.document" {
+		// Skip or try legacy
+		return nil, nil
+	}
+
+	if err := json.Unmarshal(rec.Value, &doc); err != nil {
+		return nil, fmt.Errorf("decoding document: %w", err)
+	}
+
+	// Filter by Publication
+	if s.publicationURI != "" && doc.Publication != s.publicationURI {
+		return nil, nil
+	}
+
+	// Documents without a publish date are drafts
+	draft := doc.PublishedAt == ""
+	if draft && !s.cfg.Source.IncludeDrafts {
+		fmt.Fprintf(w, "Skipping draft: %s\n", doc.Title)
+		return nil, nil
+	}
+
+	fmt.Fprintf(w, "Processing: %s\n", doc.Title)
+
+	// Convert to Markdown
+	result, err := s.conv.ConvertLeaflet(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("converting document: %w", err)
+	}
+
+	// Generate filename from title and slug from URI
+	slug := lastPathPart(rec.Uri)
+	filename := sanitizeTitle(doc.Title)
+
+	// Construct original URL
+	originalURL := fmt.Sprintf("https://leaflet.pub/%s", slug)
+
+	postData := generator.PostData{
+		Title:       doc.Title,
+		Description: doc.Description,
+		Tags:        doc.Tags,
+		CreatedAt:   doc.PublishedAt,
+		Slug:        slug,
+		Filename:    filename,
+		Handle:      s.cfg.Source.Handle,
+		OriginalURL: originalURL,
+		Draft:       draft,
+	}
+
+	// Page bundles keep their images next to index.md
+	downloader := s.downloader
+	if s.cfg.Output.Layout == config.LayoutBundle {
+		dir, err := s.gen.BundleDir(postData)
+		if err != nil {
+			return nil, fmt.Errorf("creating page bundle: %w", err)
+		}
+		downloader = s.downloader.ForBundle(dir)
+	}
+
+	// Download images and media
+	var blobs []blobRef
+	for _, imgRef := range result.Images {
+		blobs = append(blobs, blobRef{imgRef.Blob, "image"})
+	}
+	for _, mediaRef := range result.Media {
+		blobs = append(blobs, blobRef{mediaRef.Blob, mediaRef.Kind})
+	}
+	stored := s.storeBlobs(ctx, w, downloader, blobs)
+
+	finalContent := result.Markdown
+	for i, imgRef := range result.Images {
+		if stored[i] == nil {
+			finalContent = strings.ReplaceAll(finalContent, imgRef.Markdown+"\n\n", "")
+			continue
+		}
+		finalContent = strings.ReplaceAll(finalContent, imgRef.Markdown, renderImage(s.cfg, imgRef, stored[i]))
+	}
+	stored = stored[len(result.Images):]
+	for i, mediaRef := range result.Media {
+		if stored[i] == nil {
+			finalContent = strings.ReplaceAll(finalContent, mediaRef.Markdown+"\n\n", "")
+			continue
+		}
+		finalContent = strings.ReplaceAll(finalContent, mediaRef.Markdown, renderMedia(mediaRef, stored[i]))
+	}
+	postData.Content = finalContent
+	return &postData, nil
+}
+
+// blobRef is a blob to store, with the kind of content reported on failure.
+type blobRef struct {
+	blob atproto.Blob
+	kind string
+}
+
+// storeBlobs stores blobs in parallel, bounded by s.downloads, and returns
+// the results in the same order. Failures are reported to w in order, too.
+func (s *postSyncer) storeBlobs(ctx context.Context, w io.Writer, downloader *media.Downloader, blobs []blobRef) []*media.Stored {
+	stored := make([]*media.Stored, len(blobs))
+	logs := make([]bytes.Buffer, len(blobs))
+	var wg sync.WaitGroup
+	for i, b := range blobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.downloads <- struct{}{}
+			defer func() { <-s.downloads }()
+			stored[i] = storeBlob(ctx, &logs[i], downloader, s.did, b.blob, b.kind)
+		}()
+	}
+	wg.Wait()
+	for i := range logs {
+		w.Write(logs[i].Bytes())
+	}
+	return stored
+}
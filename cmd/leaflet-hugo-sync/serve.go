@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/config"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/httpclient"
+)
+
+// shutdownTimeout bounds how long a stopping server waits for running
+// requests.
+const shutdownTimeout = 30 * time.Second
+
+// runServe serves an HTTP API that runs a sync on every POST /sync, until
+// interrupted.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	var cf configFlags
+	cf.register(fs)
+	fs.Parse(args)
+
+	cfg, err := cf.load()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	secret, err := serveSecret(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	httpClient, err := httpclient.New(cfg.Network.HTTPOptions())
+	if err != nil {
+		log.Fatalf("failed to set up HTTP client: %v", err)
+	}
+
+	srv := &http.Server{
+		Addr:              cfg.Serve.Listen,
+		Handler:           newSyncServer(ctx, cfg, httpClient, secret).routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Listening on %s\n", cfg.Serve.Listen)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	fmt.Println("Stopped serving")
+}
+
+// serveSecret returns the shared secret requests must present, from
+// config.ServeSecretEnv or serve.secret_file.
+func serveSecret(cfg *config.Config) (string, error) {
+	if secret := os.Getenv(config.ServeSecretEnv); secret != "" {
+		return secret, nil
+	}
+	if cfg.Serve.SecretFile != "" {
+		return readSecret(cfg.Serve.SecretFile)
+	}
+	return "", fmt.Errorf("serve needs a shared secret in %s or serve.secret_file", config.ServeSecretEnv)
+}
+
+// syncServer runs syncs on request, one at a time.
+type syncServer struct {
+	// ctx bounds every sync; it is done when the server stops, not when a
+	// client goes away, so an interrupted request doesn't leave a half
+	// synced site.
+	ctx    context.Context
+	cfg    *config.Config
+	secret string
+	// newSyncer sets up each sync, so every sync sees the current repo.
+	newSyncer func(ctx context.Context) (*postSyncer, error)
+	// busy holds a token while a sync runs. Further requests wait for it.
+	busy chan struct{}
+}
+
+func newSyncServer(ctx context.Context, cfg *config.Config, httpClient *http.Client, secret string) *syncServer {
+	return &syncServer{
+		ctx:    ctx,
+		cfg:    cfg,
+		secret: secret,
+		newSyncer: func(ctx context.Context) (*postSyncer, error) {
+			return newPostSyncer(ctx, cfg, httpClient)
+		},
+		busy: make(chan struct{}, 1),
+	}
+}
+
+func (s *syncServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("POST /sync", s.handleSync)
+	return mux
+}
+
+func (s *syncServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// runReport is the response to POST /sync.
+type runReport struct {
+	URI      string    `json:"uri,omitempty"` // The synced document, if the sync was scoped to one
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+	syncReport
+	PostSync *commandReport `json:"post_sync,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// commandReport describes a run of the post-sync command.
+type commandReport struct {
+	Command []string `json:"command"`
+	Output  string   `json:"output"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// handleSync syncs every document, or the one given by the uri query
+// parameter (an AT-URI or record key), and responds with a runReport.
+// Concurrent requests are served one after the other.
+func (s *syncServer) handleSync(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or wrong secret"})
+		return
+	}
+
+	select {
+	case s.busy <- struct{}{}:
+		defer func() { <-s.busy }()
+	case <-r.Context().Done():
+		return
+	}
+
+	report, status := s.run(r.URL.Query().Get("uri"))
+	writeJSON(w, status, report)
+}
+
+// authorized reports whether r carries the shared secret as bearer token.
+func (s *syncServer) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.secret)) == 1
+}
+
+// run syncs like the sync command, scoped to the document ref if it isn't
+// empty, and runs the post-sync command if every document synced. It
+// returns the report with the HTTP status to send it with.
+func (s *syncServer) run(ref string) (report runReport, status int) {
+	report.Written = []string{}
+	report.Started = time.Now().UTC()
+	defer func() { report.Duration = time.Since(report.Started).Round(time.Millisecond).String() }()
+
+	ctx, cancel := withRunTimeout(s.ctx, s.cfg)
+	defer cancel()
+	syncer, err := s.newSyncer(ctx)
+	if err != nil {
+		report.Error = err.Error()
+		return report, http.StatusInternalServerError
+	}
+
+	if ref == "" {
+		fmt.Println("Syncing all documents")
+		report.syncReport, err = syncer.syncAll(ctx)
+	} else {
+		if report.URI, err = syncer.recordURI(ref); err != nil {
+			report.Error = fmt.Sprintf("uri: %v", err)
+			return report, http.StatusBadRequest
+		}
+		fmt.Printf("Syncing %s\n", report.URI)
+		report.syncReport, err = syncer.syncOne(ctx, report.URI)
+		if errors.Is(err, atproto.ErrRecordNotFound) {
+			// The document was deleted; so is its post.
+			var path string
+			if path, err = syncer.removePost(report.URI); path != "" {
+				report.Removed = append(report.Removed, path)
+			}
+		}
+	}
+	fmt.Printf("Processed %d entries\n", report.Processed)
+	if err == nil {
+		// Don't build a site with documents missing.
+		err = report.err()
+	}
+	if err != nil {
+		fmt.Printf("Sync failed: %v\n", err)
+		report.Error = err.Error()
+		return report, http.StatusInternalServerError
+	}
+
+	if len(s.cfg.Serve.PostSyncCommand) > 0 {
+		report.PostSync = runPostSync(ctx, s.cfg.Serve.PostSyncCommand)
+		if report.PostSync.Error != "" {
+			report.Error = "post-sync command failed"
+			return report, http.StatusInternalServerError
+		}
+	}
+	return report, http.StatusOK
+}
+
+// runPostSync runs the post-sync command and collects its output.
+func runPostSync(ctx context.Context, command []string) *commandReport {
+	fmt.Printf("Running %s\n", strings.Join(command, " "))
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	output, err := cmd.CombinedOutput()
+	os.Stdout.Write(output)
+	report := &commandReport{Command: command, Output: string(output)}
+	if err != nil {
+		fmt.Printf("Post-sync command failed: %v\n", err)
+		report.Error = err.Error()
+	}
+	return report
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestServer returns a server syncing from records, which touches marker
+// after each successful sync.
+func newTestServer(t *testing.T, records *fakeRecords, marker string) http.Handler {
+	t.Helper()
+	s := newTestSyncer(t, records)
+	s.cfg.Serve.PostSyncCommand = []string{"touch", marker}
+	srv := newSyncServer(context.Background(), s.cfg, nil, "s3cret")
+	srv.newSyncer = func(ctx context.Context) (*postSyncer, error) {
+		return s, nil
+	}
+	return srv.routes()
+}
+
+func postSync(t *testing.T, h http.Handler, target, secret string) (*httptest.ResponseRecorder, runReport) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, target, nil)
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var report runReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", rec.Body.String(), err)
+	}
+	return rec, report
+}
+
+func TestServe_Sync(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "built")
+	h := newTestServer(t, &fakeRecords{titles: map[string]string{"3aaa": "First", "3bbb": "Second"}}, marker)
+
+	if rec, _ := postSync(t, h, "/sync", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a wrong secret, got %d", rec.Code)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("expected no sync without the secret")
+	}
+
+	rec, report := postSync(t, h, "/sync", "s3cret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if report.Processed != 2 || len(report.Written) != 2 || len(report.Failed) != 0 {
+		t.Errorf("expected 2 posts written, got %+v", report.syncReport)
+	}
+	if report.PostSync == nil || report.PostSync.Error != "" {
+		t.Errorf("expected the post-sync command to succeed, got %+v", report.PostSync)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("expected the post-sync command to run: %v", err)
+	}
+}
+
+func TestServe_PartialFailure(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "built")
+	records := &fakeRecords{
+		titles: map[string]string{"3aaa": "First", "3bbb": "Second"},
+		broken: map[string]bool{"3bbb": true},
+	}
+	h := newTestServer(t, records, marker)
+
+	rec, report := postSync(t, h, "/sync", "s3cret")
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 when a document fails, got %d", rec.Code)
+	}
+	if len(report.Written) != 1 || len(report.Failed) != 1 {
+		t.Fatalf("expected one post written and one failure, got %+v", report.syncReport)
+	}
+	if expected := "at://" + testDID + "/" + testCollection + "/3bbb"; report.Failed[0].URI != expected {
+		t.Errorf("expected %s to fail, got %s", expected, report.Failed[0].URI)
+	}
+	if report.Error == "" {
+		t.Error("expected an error in the report")
+	}
+	if report.PostSync != nil {
+		t.Errorf("expected the post-sync command to be skipped, got %+v", report.PostSync)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("expected the post-sync command not to run")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/config"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/media"
+)
+
+// newStorage returns the configured media storage backend.
+func newStorage(cfg *config.Config, httpClient *http.Client) (media.Storage, error) {
+	if cfg.Storage.Backend != config.StorageS3 {
+		return &media.LocalStorage{Dir: cfg.Output.ImagesDir, URLPrefix: cfg.Output.ImagePathPrefix}, nil
+	}
+
+	accessKey, secretKey := os.Getenv(config.S3AccessKeyEnv), os.Getenv(config.S3SecretKeyEnv)
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("storage backend s3 needs %s and %s in the environment", config.S3AccessKeyEnv, config.S3SecretKeyEnv)
+	}
+	return &media.S3Storage{
+		Endpoint:        cfg.Storage.Endpoint,
+		Region:          cfg.Storage.Region,
+		Bucket:          cfg.Storage.Bucket,
+		Prefix:          cfg.Storage.Prefix,
+		PathStyle:       cfg.Storage.PathStyle,
+		PublicURL:       cfg.Storage.PublicURL,
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+		HTTPClient:      httpClient,
+	}, nil
+}
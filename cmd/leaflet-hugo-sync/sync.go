@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/config"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/converter"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/generator"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/httpclient"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/identity"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/media"
+)
+
+// runSync fetches all documents, or the one given with -only, and writes
+// them as Hugo posts.
+func runSync(args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	var cf configFlags
+	cf.register(fs)
+	only := fs.String("only", "", "Sync a single document, given by AT-URI or record key")
+	fs.Parse(args)
+
+	cfg, err := cf.load()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	ctx, cancel := runContext(cfg)
+	defer cancel()
+	httpClient, err := httpclient.New(cfg.Network.HTTPOptions())
+	if err != nil {
+		log.Fatalf("failed to set up HTTP client: %v", err)
+	}
+
+	s, err := newPostSyncer(ctx, cfg, httpClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var report syncReport
+	if *only != "" {
+		var uri string
+		if uri, err = s.recordURI(*only); err != nil {
+			log.Fatalf("-only: %v", err)
+		}
+		report, err = s.syncOne(ctx, uri)
+	} else {
+		report, err = s.syncAll(ctx)
+	}
+	fmt.Printf("Processed %d entries\n", report.Processed)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("Done!")
+}
+
+// newPostSyncer opens the configured record source and sets up everything
+// else a sync needs.
+func newPostSyncer(ctx context.Context, cfg *config.Config, httpClient *http.Client) (*postSyncer, error) {
+	// 1. Open the record source (PDS API, getRepo or a local CAR file)
+	resolver := newResolver(cfg, httpClient)
+	did, source, pdsHost, err := openSource(ctx, cfg, resolver, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Resolve Publication (if configured)
+	var publicationURI string
+	if cfg.Source.PublicationName != "" {
+		fmt.Printf("Resolving publication '%s'...\n", cfg.Source.PublicationName)
+		publicationURI, err = findPublication(ctx, source, did, cfg.Source.PublicationName)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Found publication URI: %s\n", publicationURI)
+	}
+
+	// 3. Update collection to Leaflet Document if user hasn't specified it
+	collection := cfg.Source.Collection
+	if collection == "com.whtwnd.blog.entry" {
+		fmt.Println("Warning: Defaulting to 'pub.leaflet.document' as 'com.whtwnd.blog.entry' seems deprecated/unused for Leaflet.")
+		collection = "pub.leaflet.document"
+	}
+
+	downloader := media.NewDownloader(cfg.Output.ImagesDir, cfg.Output.ImagePathPrefix, pdsHost, httpClient)
+	downloader.BlobsDir = cfg.Source.BlobsDir
+	if downloader.Storage, err = newStorage(cfg, httpClient); err != nil {
+		return nil, err
+	}
+	downloader.WorkDir = filepath.Join(cfg.Output.StateDir, "tmp")
+	downloader.QuarantineDir = cfg.Output.QuarantineDir
+	downloader.MaxSize = cfg.Media.MaxBytes()
+	downloader.Processor = &media.Processor{
+		Widths:       cfg.Images.Widths,
+		Quality:      cfg.Images.Quality,
+		KeepMetadata: cfg.Images.KeepMetadata,
+	}
+	if downloader.Index, err = media.LoadIndex(mediaIndexFile(cfg)); err != nil {
+		return nil, fmt.Errorf("failed to load media index: %w", err)
+	}
+	if pdsHost != "" {
+		downloader.HostFor = resolver.ResolvePDS
+	}
+	posts, err := generator.LoadPostIndex(postIndexFile(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load post index: %w", err)
+	}
+
+	return &postSyncer{
+		cfg:            cfg,
+		did:            did,
+		pdsHost:        pdsHost,
+		source:         source,
+		collection:     collection,
+		publicationURI: publicationURI,
+		conv:           converter.NewConverter(cfg.Output.BskyEmbedStyle),
+		gen:            generator.NewGenerator(cfg),
+		posts:          posts,
+		downloader:     downloader,
+		downloads:      make(chan struct{}, cfg.Concurrency.Downloads),
+	}, nil
+}
+
+// postIndexFile returns the path of the post index in the state dir.
+func postIndexFile(cfg *config.Config) string {
+	return filepath.Join(cfg.Output.StateDir, "posts.json")
+}
+
+// mediaIndexFile returns the path of the media index in the state dir.
+func mediaIndexFile(cfg *config.Config) string {
+	return filepath.Join(cfg.Output.StateDir, "media.json")
+}
+
+// runContext returns the context for a whole run, bounded by
+// network.timeout.
+func runContext(cfg *config.Config) (context.Context, context.CancelFunc) {
+	return withRunTimeout(context.Background(), cfg)
+}
+
+// withRunTimeout bounds a run started from parent by network.timeout.
+func withRunTimeout(parent context.Context, cfg *config.Config) (context.Context, context.CancelFunc) {
+	if timeout := cfg.Network.RunTimeout(); timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// connect resolves a handle or DID and returns the DID and a client for its
+// PDS.
+func connect(ctx context.Context, resolver *identity.Resolver, httpClient *http.Client, handle string) (string, *atproto.Client, error) {
+	id, err := resolver.Lookup(ctx, handle)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to resolve %s: %w", handle, err)
+	}
+	if !strings.HasPrefix(handle, "did:") {
+		fmt.Printf("Resolved %s to %s\n", handle, id.DID)
+		if !id.HandleVerified {
+			fmt.Printf("Warning: the DID document of %s does not claim the handle %s\n", id.DID, id.Handle)
+		}
+	}
+
+	// Resolve PDS Endpoint for the DID
+	pdsEndpoint, err := id.Doc.PDSEndpoint()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to resolve PDS: %w", err)
+	}
+	fmt.Printf("PDS Endpoint: %s\n", pdsEndpoint)
+
+	return id.DID, atproto.NewClient(pdsEndpoint, httpClient), nil
+}
+
+// newResolver returns an identity resolver for cfg, backed by the on-disk
+// resolution cache.
+func newResolver(cfg *config.Config, httpClient *http.Client) *identity.Resolver {
+	resolver := identity.NewResolver(cfg.Identity.PLCDirectory, cfg.Identity.HandleFallback, httpClient)
+	resolver.Logf = func(format string, args ...any) {
+		fmt.Printf(format+"\n", args...)
+	}
+
+	path := cfg.Identity.CacheFile
+	if path == "" {
+		var err error
+		if path, err = identity.DefaultCacheFile(); err != nil {
+			fmt.Printf("Warning: no identity cache: %v\n", err)
+			return resolver
+		}
+	}
+	cache, err := identity.NewFileCache(path, cfg.Identity.CacheDuration())
+	if err != nil {
+		fmt.Printf("Warning: ignoring identity cache: %v\n", err)
+		return resolver
+	}
+	resolver.Cache = cache
+	return resolver
+}
+
+// openSource returns the DID and record source for the configured mode,
+// along with the PDS host to download blobs from. The host is empty in
+// "car" mode, which never touches the network.
+func openSource(ctx context.Context, cfg *config.Config, resolver *identity.Resolver, httpClient *http.Client) (string, atproto.RecordSource, string, error) {
+	if cfg.Source.Mode == config.SourceModeCAR {
+		archive, err := atproto.LoadArchiveFile(ctx, cfg.Source.CARFile)
+		if err != nil {
+			return "", nil, "", fmt.Errorf("failed to load CAR file: %w", err)
+		}
+		fmt.Printf("Loaded repo %s (rev %s) from %s\n", archive.DID, archive.Rev, cfg.Source.CARFile)
+		if cfg.Source.Verify != config.VerifyOff {
+			did, err := archiveOwner(ctx, cfg, resolver, archive)
+			if err != nil {
+				return "", nil, "", err
+			}
+			if err := verifyArchive(ctx, cfg, resolver, archive, did); err != nil {
+				return "", nil, "", err
+			}
+		}
+		return archive.DID, archive, "", nil
+	}
+
+	did, pdsClient, err := connect(ctx, resolver, httpClient, cfg.Source.Handle)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	creds, err := loadCredentials(cfg, did)
+	if err != nil {
+		return "", nil, "", err
+	}
+	pds := newPDSSource(resolver, httpClient, did, pdsClient.XRPC.Host, creds)
+	if err := pds.open(ctx); err != nil {
+		return "", nil, "", err
+	}
+
+	if cfg.Source.Mode == config.SourceModeRepo {
+		archive, err := pds.FetchRepo(ctx)
+		if err != nil {
+			return "", nil, "", fmt.Errorf("failed to fetch repo: %w", err)
+		}
+		fmt.Printf("Fetched repo %s (rev %s)\n", archive.DID, archive.Rev)
+		if err := verifyArchive(ctx, cfg, resolver, archive, did); err != nil {
+			return "", nil, "", err
+		}
+		return did, archive, pds.Host(), nil
+	}
+
+	return did, pds, pds.Host(), nil
+}
+
+// lookupDID returns the DID of a handle, or the DID itself.
+func lookupDID(ctx context.Context, resolver *identity.Resolver, handle string) (string, error) {
+	if strings.HasPrefix(handle, "did:") {
+		return handle, nil
+	}
+	id, err := resolver.Lookup(ctx, handle)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", handle, err)
+	}
+	return id.DID, nil
+}
+
+// archiveOwner returns the DID whose repo a CAR file must be. A configured
+// signing_key already pins the account, so then a handle isn't resolved and
+// the file can be verified offline.
+func archiveOwner(ctx context.Context, cfg *config.Config, resolver *identity.Resolver, archive *atproto.Archive) (string, error) {
+	if cfg.Source.SigningKey != "" && !strings.HasPrefix(cfg.Source.Handle, "did:") {
+		return archive.DID, nil
+	}
+	return lookupDID(ctx, resolver, cfg.Source.Handle)
+}
+
+// verifyArchive checks that the archive is the repo of did, and its
+// signature and integrity, according to source.verify. In "warn" mode
+// failures are reported and tampered records are skipped; in "strict" mode
+// any failure is an error. An archive of another repo is always an error.
+func verifyArchive(ctx context.Context, cfg *config.Config, resolver *identity.Resolver, archive *atproto.Archive, did string) error {
+	if cfg.Source.Verify == config.VerifyOff {
+		return nil
+	}
+	strict := cfg.Source.Verify == config.VerifyStrict
+
+	key, err := signingKey(ctx, cfg, resolver, did)
+	if err == nil {
+		err = archive.Verify(did, key)
+	}
+
+	var verr *atproto.VerificationError
+	switch {
+	case err == nil:
+		fmt.Printf("Verified repo signature and record integrity for %s\n", did)
+		return nil
+	case strict, errors.Is(err, atproto.ErrWrongRepo):
+		return fmt.Errorf("repo verification failed: %w", err)
+	case errors.As(err, &verr):
+		fmt.Printf("Warning: repo verification failed, skipping tampered records: %v\n", verr)
+		archive.SkipRecords(verr.Tampered)
+		return nil
+	default:
+		fmt.Printf("Warning: repo verification failed: %v\n", err)
+		return nil
+	}
+}
+
+// signingKey returns the configured signing key, or the one from the DID
+// document of did.
+func signingKey(ctx context.Context, cfg *config.Config, resolver *identity.Resolver, did string) (atcrypto.PublicKey, error) {
+	if cfg.Source.SigningKey != "" {
+		return atproto.ParseSigningKey(cfg.Source.SigningKey)
+	}
+	doc, err := resolver.ResolveDID(ctx, did)
+	if err != nil {
+		return nil, fmt.Errorf("resolving DID document: %w", err)
+	}
+	return doc.SigningKey()
+}
+
+// findPublication returns the AT-URI of the publication with the given name.
+func findPublication(ctx context.Context, source atproto.RecordSource, did, name string) (string, error) {
+	pubRecords, err := source.FetchEntries(ctx, did, "pub.leaflet.publication")
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch publications: %w", err)
+	}
+
+	for _, rec := range pubRecords {
+		var pub atproto.LeafletPublication
+		if err := json.Unmarshal(rec.Value, &pub); err == nil {
+			if pub.Name == name {
+				return rec.Uri, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("publication '%s' not found", name)
+}
+
+// storeBlob downloads a blob and describes the stored copy. Since the bare
+// CID must never be published, a blob that can't be downloaded is linked on
+// the PDS instead; nil means it isn't available at all, e.g. offline.
+// Failures are reported to w.
+func storeBlob(ctx context.Context, w io.Writer, downloader *media.Downloader, did string, blob atproto.Blob, kind string) *media.Stored {
+	stored, err := downloader.DownloadBlob(ctx, did, blob)
+	if err == nil {
+		return stored
+	}
+	remote, remoteErr := downloader.RemoteURL(ctx, did, blob.Ref.Link)
+	if remoteErr != nil {
+		fmt.Fprintf(w, "  Failed to download %s, leaving it out: %v\n", kind, err)
+		return nil
+	}
+	fmt.Fprintf(w, "  Failed to download %s, linking it on the PDS: %v\n", kind, err)
+	return &media.Stored{Path: remote}
+}
+
+// renderMedia renders a converted video, audio or file blob with its stored
+// copy.
+func renderMedia(ref converter.MediaRef, stored *media.Stored) string {
+	m := converter.Media{
+		Kind:     ref.Kind,
+		Src:      stored.Path,
+		Name:     ref.Name,
+		MimeType: ref.Blob.Mime,
+		Size:     ref.Blob.Size,
+	}
+	if ref.AspectRatio != nil {
+		m.AspectWidth, m.AspectHeight = ref.AspectRatio.Width, ref.AspectRatio.Height
+	}
+	return converter.RenderMedia(m)
+}
+
+// renderImage renders a converted image with its stored copy and variants
+// in the configured style.
+func renderImage(cfg *config.Config, ref converter.ImageRef, stored *media.Stored) string {
+	img := converter.Image{
+		Src:    stored.Path,
+		Alt:    ref.Alt,
+		Width:  stored.Width,
+		Height: stored.Height,
+	}
+	if cfg.Output.ImageCaptions {
+		img.Caption = ref.Alt
+	}
+	if ref.AspectRatio != nil {
+		img.AspectWidth, img.AspectHeight = ref.AspectRatio.Width, ref.AspectRatio.Height
+	}
+	for _, v := range stored.Variants {
+		img.Srcset = append(img.Srcset, converter.ImageSource{Src: v.Path, Width: v.Width})
+	}
+	return converter.RenderImage(img, cfg.Output.ImageStyle)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/config"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/identity"
+)
+
+// offlineHandles fails the test if a handle is resolved.
+type offlineHandles struct{ t *testing.T }
+
+func (h offlineHandles) ResolveHandle(ctx context.Context, handle string) (string, error) {
+	h.t.Errorf("unexpected lookup of %s", handle)
+	return "", context.Canceled
+}
+
+func TestArchiveOwner_SigningKeySkipsLookup(t *testing.T) {
+	resolver := identity.NewResolver("http://127.0.0.1:0", "", nil)
+	resolver.Handle = offlineHandles{t}
+	archive := &atproto.Archive{DID: testDID}
+
+	var cfg config.Config
+	cfg.Source.Handle = "alice.example.com"
+	cfg.Source.SigningKey = "did:key:zQ3shXjHeiBuRCKmM36cuYnm7YEMzhGnCmCyW92sRJ9pribSF"
+	did, err := archiveOwner(context.Background(), &cfg, resolver, archive)
+	if err != nil || did != testDID {
+		t.Errorf("expected %s, got %q (%v)", testDID, did, err)
+	}
+
+	// A configured DID is still checked against the archive.
+	cfg.Source.Handle = "did:plc:other"
+	if did, _ := archiveOwner(context.Background(), &cfg, resolver, archive); did != "did:plc:other" {
+		t.Errorf("expected the configured DID, got %q", did)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/config"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/httpclient"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/stream"
+)
+
+const (
+	// cursorSaveInterval limits how often the cursor is saved while only
+	// unrelated events arrive.
+	cursorSaveInterval = time.Minute
+	// maxReconnectDelay bounds the wait between reconnection attempts.
+	maxReconnectDelay = 2 * time.Minute
+	// retryDelay is the wait before changes that failed are applied again.
+	retryDelay = time.Minute
+)
+
+// runWatch subscribes to changes of the documents and syncs every changed
+// document, until interrupted.
+func runWatch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	var cf configFlags
+	cf.register(fs)
+	fs.Parse(args)
+
+	cfg, err := cf.load()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	if cfg.Source.Mode != config.SourceModeAPI {
+		log.Fatalf("watch needs source.mode %q, got %q", config.SourceModeAPI, cfg.Source.Mode)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	httpClient, err := httpclient.New(cfg.Network.HTTPOptions())
+	if err != nil {
+		log.Fatalf("failed to set up HTTP client: %v", err)
+	}
+
+	setupCtx, cancel := withRunTimeout(ctx, cfg)
+	s, err := newPostSyncer(setupCtx, cfg, httpClient)
+	cancel()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w, err := newWatcher(cfg, s, httpClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := w.run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
+	fmt.Println("Stopped watching")
+}
+
+// watcher applies the changes of a stream to the site.
+type watcher struct {
+	s          *postSyncer
+	src        stream.Source
+	endpoint   string
+	cursorFile string
+	delay      time.Duration
+	retryDelay time.Duration
+}
+
+func newWatcher(cfg *config.Config, s *postSyncer, httpClient *http.Client) (*watcher, error) {
+	w := &watcher{
+		s:          s,
+		endpoint:   cfg.Watch.Endpoint,
+		cursorFile: filepath.Join(cfg.Output.StateDir, "watch-cursor.json"),
+		delay:      cfg.Watch.DelayDuration(),
+		retryDelay: retryDelay,
+	}
+	userAgent := cfg.Network.UserAgent
+	if userAgent == "" {
+		userAgent = httpclient.DefaultUserAgent
+	}
+	header := http.Header{"User-Agent": {userAgent}}
+	dialer := websocketDialer(httpClient)
+
+	switch cfg.Watch.Source {
+	case config.WatchFirehose:
+		if w.endpoint == "" {
+			var err error
+			if w.endpoint, err = stream.FirehoseURL(s.pdsHost); err != nil {
+				return nil, fmt.Errorf("invalid PDS URL: %w", err)
+			}
+		}
+		w.src = &stream.Firehose{URL: w.endpoint, DID: s.did, Collection: s.collection, Dialer: dialer, Header: header}
+	default:
+		w.src = &stream.Jetstream{URL: w.endpoint, DID: s.did, Collection: s.collection, Dialer: dialer, Header: header}
+	}
+	return w, nil
+}
+
+// websocketDialer returns a dialer with the proxy and TLS settings of
+// httpClient.
+func websocketDialer(httpClient *http.Client) *websocket.Dialer {
+	dialer := *websocket.DefaultDialer
+	if t, ok := httpClient.Transport.(*httpclient.Transport); ok {
+		if base, ok := t.Base.(*http.Transport); ok {
+			dialer.Proxy = base.Proxy
+			dialer.TLSClientConfig = base.TLSClientConfig
+		}
+	}
+	return &dialer
+}
+
+// change is a pending change to a document.
+type change struct {
+	uri    string
+	action string
+	// cursor is the position of the first event of the change that hasn't
+	// been applied yet.
+	cursor int64
+}
+
+// run resumes the stream from the saved cursor and applies changes until
+// ctx is done. Without a saved cursor it syncs everything first, so changes
+// made while nothing was watching aren't missed. Changes are collected for
+// the configured delay and then applied together. Changes that fail are
+// retried after retryDelay. The saved cursor never moves past a change that
+// hasn't been applied, so a restart replays changes that weren't.
+func (w *watcher) run(ctx context.Context) error {
+	cursor, err := stream.LoadCursor(w.cursorFile, w.endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to load cursor: %w", err)
+	}
+
+	// Without a cursor, timestamp cursors can start at the current time;
+	// others pick up live events.
+	fullSync := cursor == 0
+	if clock, ok := w.src.(interface{ Now() int64 }); ok && fullSync {
+		cursor = clock.Now()
+	}
+
+	// Subscribe before the full sync; events arriving meanwhile wait.
+	events := make(chan stream.Event)
+	go w.subscribe(ctx, cursor, events)
+
+	var (
+		pending []change
+		flush   <-chan time.Time
+		// retrying is set while flush waits to retry failed changes only.
+		retrying bool
+		last     = cursor
+		saved    = cursor
+		savedAt  = time.Now()
+	)
+	if fullSync {
+		fmt.Println("No saved cursor, syncing all documents first")
+		runCtx, cancel := withRunTimeout(ctx, w.s.cfg)
+		report, err := w.s.syncAll(runCtx)
+		cancel()
+		fmt.Printf("Processed %d entries\n", report.Processed)
+		if err != nil {
+			fmt.Printf("Full sync failed: %v\n", err)
+		} else {
+			// Everything before the cursor is synced now.
+			saved = 0
+		}
+	} else {
+		fmt.Printf("Resuming from cursor %d\n", cursor)
+	}
+	save := func() {
+		cursor := last
+		for _, c := range pending {
+			// Resume just before the change, so it is replayed.
+			cursor = min(cursor, c.cursor-1)
+		}
+		if cursor == saved {
+			return
+		}
+		if err := stream.SaveCursor(w.cursorFile, w.endpoint, cursor); err != nil {
+			fmt.Printf("Failed to save cursor: %v\n", err)
+			return
+		}
+		saved, savedAt = cursor, time.Now()
+	}
+	save()
+	fmt.Printf("Watching %s for changes\n", w.endpoint)
+
+	for {
+		select {
+		case <-ctx.Done():
+			save()
+			return ctx.Err()
+
+		case e := <-events:
+			last = e.Cursor
+			if e.Action == "" {
+				if time.Since(savedAt) >= cursorSaveInterval {
+					save()
+				}
+				continue
+			}
+			fmt.Printf("Change: %s %s\n", e.Action, e.URI())
+			pending = addChange(pending, change{uri: e.URI(), action: e.Action, cursor: e.Cursor})
+			if flush == nil || retrying {
+				// New changes don't wait for the retry.
+				flush, retrying = time.After(w.delay), false
+			}
+
+		case <-flush:
+			flush = nil
+			pending = w.apply(ctx, pending)
+			if len(pending) > 0 {
+				fmt.Printf("Retrying %d failed change(s) in %s\n", len(pending), w.retryDelay)
+				flush, retrying = time.After(w.retryDelay), true
+			}
+			save()
+		}
+	}
+}
+
+// addChange adds c to pending, merging it into an earlier change of the
+// same document so it is synced once, in its latest state.
+func addChange(pending []change, c change) []change {
+	for i := range pending {
+		if pending[i].uri == c.uri {
+			pending[i].action = c.action
+			return pending
+		}
+	}
+	return append(pending, c)
+}
+
+// apply syncs changed documents and removes the posts of deleted ones. It
+// returns the changes that failed.
+func (w *watcher) apply(ctx context.Context, changes []change) []change {
+	runCtx, cancel := withRunTimeout(ctx, w.s.cfg)
+	defer cancel()
+	var failed []change
+	for _, c := range changes {
+		var err error
+		if c.action == stream.ActionDelete {
+			_, err = w.s.removePost(c.uri)
+		} else {
+			var report syncReport
+			report, err = w.s.syncOne(runCtx, c.uri)
+			if errors.Is(err, atproto.ErrRecordNotFound) {
+				// Deleted again before we got to it.
+				_, err = w.s.removePost(c.uri)
+			} else if err == nil {
+				err = report.err()
+			}
+		}
+		if err != nil {
+			fmt.Printf("Failed to apply %s of %s: %v\n", c.action, c.uri, err)
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// subscribe sends the events of the stream to events, reconnecting with
+// backoff from the last event whenever the connection fails.
+func (w *watcher) subscribe(ctx context.Context, cursor int64, events chan<- stream.Event) {
+	delay := time.Second
+	for {
+		connected := time.Now()
+		err := w.src.Subscribe(ctx, cursor, func(e stream.Event) error {
+			select {
+			case events <- e:
+				cursor = e.Cursor
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(connected) > maxReconnectDelay {
+			// The connection was fine for a while; start over.
+			delay = time.Second
+		}
+		fmt.Printf("Lost connection to %s: %v; reconnecting in %s\n", w.endpoint, err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
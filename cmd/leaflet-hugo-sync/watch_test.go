@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/config"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/converter"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/generator"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/media"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/stream"
+)
+
+const (
+	testDID        = "did:plc:watchtest"
+	testCollection = "pub.leaflet.document"
+)
+
+// fakeRecords serves documents by record key. Fetching a key in failing
+// fails; the documents in broken can't be decoded, the ones in drafts are
+// unpublished.
+type fakeRecords struct {
+	mu      sync.Mutex
+	titles  map[string]string
+	failing map[string]bool
+	broken  map[string]bool
+	drafts  map[string]bool
+}
+
+func (f *fakeRecords) record(rkey string) atproto.Record {
+	doc := atproto.LeafletDocument{
+		Type:        "pub.leaflet.document",
+		Title:       f.titles[rkey],
+		PublishedAt: "2024-01-01T00:00:00Z",
+	}
+	if f.drafts[rkey] {
+		doc.PublishedAt = ""
+	}
+	value, _ := json.Marshal(doc)
+	if f.broken[rkey] {
+		value = json.RawMessage(`{"$type": "pub.leaflet.document", "title": 42}`)
+	}
+	return atproto.Record{Uri: fmt.Sprintf("at://%s/%s/%s", testDID, testCollection, rkey), Value: value}
+}
+
+func (f *fakeRecords) FetchEntries(ctx context.Context, repo, collection string) ([]atproto.Record, error) {
+	var records []atproto.Record
+	err := f.EachRecord(ctx, repo, collection, func(rec atproto.Record) error {
+		records = append(records, rec)
+		return nil
+	})
+	return records, err
+}
+
+func (f *fakeRecords) EachRecord(ctx context.Context, repo, collection string, fn func(atproto.Record) error) error {
+	f.mu.Lock()
+	var records []atproto.Record
+	for rkey := range f.titles {
+		records = append(records, f.record(rkey))
+	}
+	f.mu.Unlock()
+	for _, rec := range records {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeRecords) GetRecord(ctx context.Context, uri string) (*atproto.Record, error) {
+	_, _, rkey, err := atproto.ParseATURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing[rkey] {
+		return nil, errors.New("PDS unavailable")
+	}
+	if _, ok := f.titles[rkey]; !ok {
+		return nil, atproto.ErrRecordNotFound
+	}
+	rec := f.record(rkey)
+	return &rec, nil
+}
+
+// fakeStream delivers its events after the cursor, then waits.
+type fakeStream struct {
+	events []stream.Event
+}
+
+func (f *fakeStream) Subscribe(ctx context.Context, cursor int64, fn func(stream.Event) error) error {
+	for _, e := range f.events {
+		if e.Cursor <= cursor {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func newTestSyncer(t *testing.T, source atproto.RecordSource) *postSyncer {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.Source.Handle = testDID
+	cfg.Output.PostsDir = filepath.Join(dir, "posts")
+	cfg.Output.ImagesDir = filepath.Join(dir, "images")
+	cfg.Output.StateDir = filepath.Join(dir, "state")
+	cfg.Template.Frontmatter = "---\ntitle: {{ .Title }}\n---"
+	cfg.ApplyDefaults()
+
+	posts, err := generator.LoadPostIndex(postIndexFile(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &postSyncer{
+		cfg:        cfg,
+		did:        testDID,
+		source:     source,
+		collection: testCollection,
+		conv:       converter.NewConverter(cfg.Output.BskyEmbedStyle),
+		gen:        generator.NewGenerator(cfg),
+		posts:      posts,
+		downloader: media.NewDownloader(cfg.Output.ImagesDir, "/images", "", nil),
+		downloads:  make(chan struct{}, cfg.Concurrency.Downloads),
+	}
+}
+
+func changeEvent(cursor int64, action, rkey string) stream.Event {
+	return stream.Event{Cursor: cursor, DID: testDID, Collection: testCollection, RKey: rkey, Action: action}
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// watchUntil runs w until cond holds, then stops it.
+func watchUntil(t *testing.T, w *watcher, what string, cond func() bool) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.run(ctx) }()
+	waitFor(t, what, cond)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the watcher to stop with context.Canceled, got %v", err)
+	}
+}
+
+// postExists returns a condition that holds once s wrote the post titled
+// title.
+func postExists(s *postSyncer, title string) func() bool {
+	return func() bool {
+		_, err := os.Stat(filepath.Join(s.cfg.Output.PostsDir, title+".md"))
+		return err == nil
+	}
+}
+
+func TestWatcher_KeepsCursorAtFailedChange(t *testing.T) {
+	records := &fakeRecords{
+		titles:  map[string]string{"3aaa": "First", "3bbb": "Second"},
+		failing: map[string]bool{"3aaa": true},
+	}
+	s := newTestSyncer(t, records)
+	w := &watcher{
+		s: s,
+		src: &fakeStream{events: []stream.Event{
+			changeEvent(100, stream.ActionCreate, "3aaa"),
+			{Cursor: 101},
+			changeEvent(102, stream.ActionCreate, "3bbb"),
+		}},
+		endpoint:   "wss://jetstream.example.com/subscribe",
+		cursorFile: filepath.Join(s.cfg.Output.StateDir, "watch-cursor.json"),
+		retryDelay: time.Hour,
+	}
+	if err := stream.SaveCursor(w.cursorFile, w.endpoint, 50); err != nil {
+		t.Fatal(err)
+	}
+	// The first change fails, so the cursor must stay before it even
+	// though later changes were applied.
+	watchUntil(t, w, "the second change", postExists(s, "Second"))
+	cursor, err := stream.LoadCursor(w.cursorFile, w.endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor != 99 {
+		t.Errorf("expected the cursor before the failed change (99), got %d", cursor)
+	}
+
+	// After a restart the failed change is replayed.
+	records.mu.Lock()
+	records.failing = nil
+	records.mu.Unlock()
+	watchUntil(t, w, "the replayed change", postExists(s, "First"))
+	if cursor, err = stream.LoadCursor(w.cursorFile, w.endpoint); err != nil {
+		t.Fatal(err)
+	}
+	if cursor != 102 {
+		t.Errorf("expected the cursor at the last event (102), got %d", cursor)
+	}
+}
+
+func TestWatcher_RetriesFailedChange(t *testing.T) {
+	records := &fakeRecords{
+		titles:  map[string]string{"3aaa": "First"},
+		failing: map[string]bool{"3aaa": true},
+	}
+	s := newTestSyncer(t, records)
+	w := &watcher{
+		s:          s,
+		src:        &fakeStream{events: []stream.Event{changeEvent(100, stream.ActionUpdate, "3aaa")}},
+		endpoint:   "wss://jetstream.example.com/subscribe",
+		cursorFile: filepath.Join(s.cfg.Output.StateDir, "watch-cursor.json"),
+		retryDelay: 10 * time.Millisecond,
+	}
+	if err := stream.SaveCursor(w.cursorFile, w.endpoint, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.run(ctx) }()
+
+	time.Sleep(50 * time.Millisecond)
+	records.mu.Lock()
+	records.failing = nil
+	records.mu.Unlock()
+	waitFor(t, "the cursor to move past the change", func() bool {
+		cursor, err := stream.LoadCursor(w.cursorFile, w.endpoint)
+		return err == nil && cursor == 100
+	})
+	cancel()
+	<-done
+
+	if _, err := os.Stat(filepath.Join(s.cfg.Output.PostsDir, "First.md")); err != nil {
+		t.Errorf("expected the retried change to write the post: %v", err)
+	}
+}
+
+func TestWatcher_RemovesStalePosts(t *testing.T) {
+	records := &fakeRecords{titles: map[string]string{"3aaa": "First"}}
+	s := newTestSyncer(t, records)
+	src := &fakeStream{events: []stream.Event{changeEvent(100, stream.ActionCreate, "3aaa")}}
+	w := &watcher{
+		s:          s,
+		src:        src,
+		endpoint:   "wss://jetstream.example.com/subscribe",
+		cursorFile: filepath.Join(s.cfg.Output.StateDir, "watch-cursor.json"),
+		retryDelay: time.Hour,
+	}
+	watchUntil(t, w, "the post to be written", postExists(s, "First"))
+
+	// A new title moves the post to a new file.
+	records.mu.Lock()
+	records.titles["3aaa"] = "Renamed"
+	records.mu.Unlock()
+	src.events = append(src.events, changeEvent(101, stream.ActionUpdate, "3aaa"))
+	watchUntil(t, w, "the renamed post", postExists(s, "Renamed"))
+	if postExists(s, "First")() {
+		t.Error("expected the post under the old title to be removed")
+	}
+
+	// A document turned back into a draft is unpublished.
+	records.mu.Lock()
+	records.drafts = map[string]bool{"3aaa": true}
+	records.mu.Unlock()
+	src.events = append(src.events, changeEvent(102, stream.ActionUpdate, "3aaa"))
+	watchUntil(t, w, "the draft's post to be removed", func() bool { return !postExists(s, "Renamed")() })
+	if path, ok := s.posts.Lookup(fmt.Sprintf("at://%s/%s/3aaa", testDID, testCollection)); ok {
+		t.Errorf("expected the post to be unindexed, got %s", path)
+	}
+}
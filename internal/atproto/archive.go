@@ -0,0 +1,262 @@
+package atproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/atdata"
+	atrepo "github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/repo/mst"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+// RecordSource lists the records of a collection in a repo. It is
+// implemented by Client (live listRecords calls) and Archive (a CAR export).
+type RecordSource interface {
+	FetchEntries(ctx context.Context, repo string, collection string) ([]Record, error)
+	// EachRecord calls fn for every record of collection, in the order of
+	// FetchEntries, and stops at the first error.
+	EachRecord(ctx context.Context, repo string, collection string, fn func(Record) error) error
+	// GetRecord returns the record at an AT-URI, or an error wrapping
+	// ErrRecordNotFound.
+	GetRecord(ctx context.Context, uri string) (*Record, error)
+}
+
+// Archive is a repository loaded from a CAR export, as returned by
+// com.atproto.sync.getRepo. It serves records without any network access.
+type Archive struct {
+	DID    string
+	Rev    string
+	Commit *atrepo.Commit
+	repo   *atrepo.Repo
+	// corrupt holds blocks whose content does not hash to their CID
+	corrupt map[cid.Cid]bool
+	// skip holds "<collection>/<rkey>" paths left out of FetchEntries
+	skip map[string]bool
+}
+
+// LoadArchive parses a repo CAR export.
+//
+// Blocks whose content does not match their CID are kept but marked, so
+// Verify can report exactly which records were tampered with. FetchEntries
+// refuses to return such records.
+func LoadArchive(ctx context.Context, r io.Reader) (*Archive, error) {
+	br := bufio.NewReader(r)
+	header, err := car.ReadHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("reading repo CAR: %w", err)
+	}
+	if header.Version != 1 {
+		return nil, fmt.Errorf("unsupported CAR file version: %d", header.Version)
+	}
+	if len(header.Roots) < 1 {
+		return nil, atrepo.ErrNoRoot
+	}
+
+	bs := atrepo.NewTinyBlockstore()
+	corrupt := make(map[cid.Cid]bool)
+	for {
+		c, data, err := carutil.ReadNode(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading repo CAR: %w", err)
+		}
+		if sum, err := c.Prefix().Sum(data); err != nil || !sum.Equals(c) {
+			corrupt[c] = true
+		}
+		blk, err := blocks.NewBlockWithCid(data, c)
+		if err != nil {
+			return nil, fmt.Errorf("reading repo CAR: %w", err)
+		}
+		if err := bs.Put(ctx, blk); err != nil {
+			return nil, err
+		}
+	}
+
+	commitBlock, err := bs.Get(ctx, header.Roots[0])
+	if err != nil {
+		return nil, fmt.Errorf("reading commit block from CAR file: %w", err)
+	}
+	var commit atrepo.Commit
+	if err := commit.UnmarshalCBOR(bytes.NewReader(commitBlock.RawData())); err != nil {
+		return nil, fmt.Errorf("parsing commit block from CAR file: %w", err)
+	}
+	if err := commit.VerifyStructure(); err != nil {
+		return nil, fmt.Errorf("parsing commit block from CAR file: %w", err)
+	}
+
+	tree, err := mst.LoadTreeFromStore(ctx, bs, commit.Data)
+	if err != nil {
+		return nil, fmt.Errorf("reading MST from CAR file: %w", err)
+	}
+	clock := syntax.ClockFromTID(syntax.TID(commit.Rev))
+
+	return &Archive{
+		DID:    commit.DID,
+		Rev:    commit.Rev,
+		Commit: &commit,
+		repo: &atrepo.Repo{
+			DID:         syntax.DID(commit.DID),
+			Clock:       &clock,
+			MST:         *tree,
+			RecordStore: bs,
+		},
+		corrupt: corrupt,
+		skip:    make(map[string]bool),
+	}, nil
+}
+
+// LoadArchiveFile parses a repo CAR export from a local file.
+func LoadArchiveFile(ctx context.Context, path string) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	archive, err := LoadArchive(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return archive, nil
+}
+
+// FetchRepo downloads the full repository of did as a CAR export using
+// com.atproto.sync.getRepo.
+func (c *Client) FetchRepo(ctx context.Context, did string) (*Archive, error) {
+	var car []byte
+	err := c.call(ctx, func(xc *xrpc.Client) error {
+		var err error
+		car, err = atproto.SyncGetRepo(ctx, xc, did, "")
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching repo: %w", err)
+	}
+	return LoadArchive(ctx, bytes.NewReader(car))
+}
+
+// FetchEntries returns all records of collection in the archive, newest
+// record key first like listRecords.
+func (a *Archive) FetchEntries(ctx context.Context, repo string, collection string) ([]Record, error) {
+	if repo != a.DID {
+		return nil, fmt.Errorf("archive contains repo %s, not %s", a.DID, repo)
+	}
+
+	prefix := collection + "/"
+	type entry struct {
+		key string
+		cid cid.Cid
+	}
+	var entries []entry
+	err := a.repo.MST.Walk(func(key []byte, val cid.Cid) error {
+		if strings.HasPrefix(string(key), prefix) && !a.skip[string(key)] {
+			entries = append(entries, entry{key: string(key), cid: val})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking repo tree: %w", err)
+	}
+
+	records := make([]Record, 0, len(entries))
+	for _, e := range entries {
+		if a.corrupt[e.cid] {
+			return nil, fmt.Errorf("record %s does not match its CID %s", e.key, e.cid)
+		}
+		value, err := a.recordJSON(ctx, e.cid)
+		if err != nil {
+			return nil, fmt.Errorf("record %s: %w", e.key, err)
+		}
+		records = append(records, Record{
+			Uri:   fmt.Sprintf("at://%s/%s", a.DID, e.key),
+			Cid:   e.cid.String(),
+			Value: value,
+		})
+	}
+	slices.Reverse(records)
+
+	return records, nil
+}
+
+// EachRecord calls fn for every record FetchEntries returns. The archive is
+// in memory anyway, so this is for RecordSource.
+func (a *Archive) EachRecord(ctx context.Context, repo string, collection string, fn func(Record) error) error {
+	records, err := a.FetchEntries(ctx, repo, collection)
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRecord returns the record at an AT-URI in the archive. Records left
+// out by SkipRecords are not found.
+func (a *Archive) GetRecord(ctx context.Context, uri string) (*Record, error) {
+	repo, collection, rkey, err := ParseATURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if repo != a.DID {
+		return nil, fmt.Errorf("archive contains repo %s, not %s", a.DID, repo)
+	}
+
+	key := collection + "/" + rkey
+	c, err := a.repo.MST.Get([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("looking up %s: %w", key, err)
+	}
+	if c == nil || a.skip[key] {
+		return nil, fmt.Errorf("%w: %s", ErrRecordNotFound, uri)
+	}
+	if a.corrupt[*c] {
+		return nil, fmt.Errorf("record %s does not match its CID %s", key, c)
+	}
+	value, err := a.recordJSON(ctx, *c)
+	if err != nil {
+		return nil, fmt.Errorf("record %s: %w", key, err)
+	}
+	return &Record{Uri: uri, Cid: c.String(), Value: value}, nil
+}
+
+// SkipRecords leaves the records at the given "<collection>/<rkey>" paths
+// out of FetchEntries and GetRecord, e.g. the tampered records reported by
+// Verify.
+func (a *Archive) SkipRecords(paths []string) {
+	for _, p := range paths {
+		a.skip[p] = true
+	}
+}
+
+// recordJSON loads a record block and converts it from DAG-CBOR to the
+// JSON form returned by the XRPC API.
+func (a *Archive) recordJSON(ctx context.Context, c cid.Cid) (json.RawMessage, error) {
+	blk, err := a.repo.RecordStore.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	data, err := atdata.UnmarshalCBOR(blk.RawData())
+	if err != nil {
+		return nil, fmt.Errorf("decoding record: %w", err)
+	}
+	return json.Marshal(data)
+}
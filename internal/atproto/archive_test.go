@@ -0,0 +1,266 @@
+package atproto
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/bluesky-social/indigo/atproto/atdata"
+	atrepo "github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/repo/mst"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+)
+
+const testDID = "did:plc:testauthor123"
+
+func TestArchive_FetchEntries(t *testing.T) {
+	key, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	carBytes := buildTestCAR(t, testDID, key, map[string]map[string]any{
+		"pub.leaflet.document/3aaa":    {"$type": "pub.leaflet.document", "title": "First"},
+		"pub.leaflet.document/3bbb":    {"$type": "pub.leaflet.document", "title": "Second"},
+		"pub.leaflet.publication/3ccc": {"$type": "pub.leaflet.publication", "name": "Blog"},
+	})
+
+	archive, err := LoadArchive(context.Background(), bytes.NewReader(carBytes))
+	if err != nil {
+		t.Fatalf("LoadArchive failed: %v", err)
+	}
+	if archive.DID != testDID {
+		t.Errorf("expected DID %s, got %s", testDID, archive.DID)
+	}
+
+	records, err := archive.FetchEntries(context.Background(), testDID, "pub.leaflet.document")
+	if err != nil {
+		t.Fatalf("FetchEntries failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+
+	// Newest record key first, like listRecords
+	if records[0].Uri != "at://"+testDID+"/pub.leaflet.document/3bbb" {
+		t.Errorf("unexpected first record URI %s", records[0].Uri)
+	}
+
+	var doc LeafletDocument
+	if err := json.Unmarshal(records[0].Value, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Type != "pub.leaflet.document" || doc.Title != "Second" {
+		t.Errorf("unexpected record value %s", records[0].Value)
+	}
+
+	if _, err := archive.FetchEntries(context.Background(), "did:plc:someoneelse", "pub.leaflet.document"); err == nil {
+		t.Error("expected an error for a different repo")
+	}
+
+	rec, err := archive.GetRecord(context.Background(), "at://"+testDID+"/pub.leaflet.document/3aaa")
+	if err != nil {
+		t.Fatalf("GetRecord failed: %v", err)
+	}
+	if rec.Cid != records[1].Cid || !bytes.Equal(rec.Value, records[1].Value) {
+		t.Errorf("expected GetRecord to match FetchEntries, got %s", rec.Value)
+	}
+	if _, err := archive.GetRecord(context.Background(), "at://"+testDID+"/pub.leaflet.document/3zzz"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+	archive.SkipRecords([]string{"pub.leaflet.document/3aaa"})
+	if _, err := archive.GetRecord(context.Background(), "at://"+testDID+"/pub.leaflet.document/3aaa"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected skipped records not to be found, got %v", err)
+	}
+}
+
+// buildTestCAR creates a repo CAR export of did, signed by key, containing records, keyed by
+// "<collection>/<rkey>".
+func buildTestCAR(t *testing.T, did string, key atcrypto.PrivateKey, records map[string]map[string]any) []byte {
+	t.Helper()
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	builder := cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.SHA2_256}
+
+	putBlock := func(data []byte) cid.Cid {
+		c, err := builder.Sum(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blk, err := blocks.NewBlockWithCid(data, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.Put(ctx, blk); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tree := mst.NewEmptyTree()
+	for path, value := range records {
+		data, err := atdata.MarshalCBOR(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tree.Insert([]byte(path), putBlock(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := tree.WriteDiffBlocks(ctx, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit := atrepo.Commit{
+		DID:     did,
+		Version: atrepo.ATPROTO_REPO_VERSION,
+		Data:    *root,
+		Rev:     syntax.NewTIDNow(0).String(),
+	}
+	if err := commit.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	var commitBuf bytes.Buffer
+	if err := commit.MarshalCBOR(&commitBuf); err != nil {
+		t.Fatal(err)
+	}
+	commitCID := putBlock(commitBuf.Bytes())
+
+	var out bytes.Buffer
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{commitCID}, Version: 1}, &out); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range keys {
+		// The blockstore indexes by multihash only; all repo blocks are DAG-CBOR
+		c := cid.NewCidV1(cid.DagCBOR, k.Hash())
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := carutil.LdWrite(&out, c.Bytes(), blk.RawData()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out.Bytes()
+}
+
+func TestArchive_Verify(t *testing.T) {
+	key, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	carBytes := buildTestCAR(t, testDID, key, map[string]map[string]any{
+		"pub.leaflet.document/3aaa": {"$type": "pub.leaflet.document", "title": "Genuine"},
+		"pub.leaflet.document/3bbb": {"$type": "pub.leaflet.document", "title": "Original"},
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		archive, err := LoadArchive(context.Background(), bytes.NewReader(carBytes))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := archive.Verify(testDID, pub); err != nil {
+			t.Errorf("expected archive to verify, got %v", err)
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		other, err := atcrypto.GeneratePrivateKeyK256()
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherPub, err := other.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive, err := LoadArchive(context.Background(), bytes.NewReader(carBytes))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := archive.Verify(testDID, otherPub); err == nil {
+			t.Error("expected signature verification to fail")
+		}
+	})
+
+	t.Run("other repo", func(t *testing.T) {
+		// Validly signed, but by and for another account.
+		other := buildTestCAR(t, "did:plc:someoneelse", key, map[string]map[string]any{
+			"pub.leaflet.document/3aaa": {"$type": "pub.leaflet.document", "title": "Impostor"},
+		})
+		archive, err := LoadArchive(context.Background(), bytes.NewReader(other))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := archive.Verify(testDID, pub); !errors.Is(err, ErrWrongRepo) {
+			t.Errorf("expected ErrWrongRepo, got %v", err)
+		}
+	})
+
+	t.Run("tampered record", func(t *testing.T) {
+		tampered := bytes.Replace(carBytes, []byte("Original"), []byte("Modified"), 1)
+		archive, err := LoadArchive(context.Background(), bytes.NewReader(tampered))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = archive.Verify(testDID, pub)
+		var verr *VerificationError
+		if !errors.As(err, &verr) {
+			t.Fatalf("expected a VerificationError, got %v", err)
+		}
+		if len(verr.Tampered) != 1 || !verr.IsTampered("pub.leaflet.document/3bbb") {
+			t.Errorf("expected only 3bbb to be tampered, got %v", verr.Tampered)
+		}
+
+		if _, err := archive.FetchEntries(context.Background(), testDID, "pub.leaflet.document"); err == nil {
+			t.Error("expected FetchEntries to refuse the tampered record")
+		}
+		archive.SkipRecords(verr.Tampered)
+		records, err := archive.FetchEntries(context.Background(), testDID, "pub.leaflet.document")
+		if err != nil {
+			t.Fatalf("FetchEntries failed: %v", err)
+		}
+		if len(records) != 1 || records[0].Uri != "at://"+testDID+"/pub.leaflet.document/3aaa" {
+			t.Errorf("expected only the genuine record, got %v", records)
+		}
+	})
+}
+
+func TestParseSigningKey(t *testing.T) {
+	key, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{pub.DIDKey(), pub.Multibase()} {
+		parsed, err := ParseSigningKey(s)
+		if err != nil {
+			t.Fatalf("ParseSigningKey(%q) failed: %v", s, err)
+		}
+		if parsed.DIDKey() != pub.DIDKey() {
+			t.Errorf("ParseSigningKey(%q) returned a different key", s)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package atproto
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseATURI splits at://<repo>/<collection>/<rkey> into its parts. The
+// repo may be a DID or a handle.
+func ParseATURI(uri string) (repo, collection, rkey string, err error) {
+	parts := strings.Split(strings.TrimPrefix(uri, "at://"), "/")
+	if !strings.HasPrefix(uri, "at://") || len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("invalid AT-URI %q, expected at://<repo>/<collection>/<rkey>", uri)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
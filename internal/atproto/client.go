@@ -3,17 +3,25 @@ package atproto
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sync"
 
-	"github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/xrpc"
 )
 
 type Client struct {
 	XRPC *xrpc.Client
+	// authMu guards XRPC.Auth. Requests don't hold it; they go through
+	// call, which hands each one a copy of XRPC.
+	authMu sync.Mutex
 }
 
+// ErrRecordNotFound is returned by GetRecord for records that don't exist.
+var ErrRecordNotFound = errors.New("record not found")
+
 type Record struct {
 	Uri   string          `json:"uri"`
 	Cid   string          `json:"cid"`
@@ -25,68 +33,38 @@ type ListRecordsResponse struct {
 	Records []Record `json:"records"`
 }
 
-func NewClient(pdsHost string) *Client {
+// NewClient returns a client for the PDS at pdsHost. httpClient may be nil
+// to use http.DefaultClient.
+func NewClient(pdsHost string, httpClient *http.Client) *Client {
 	if pdsHost == "" {
 		pdsHost = "https://bsky.social"
 	}
 	return &Client{
 		XRPC: &xrpc.Client{
-			Host: pdsHost,
+			Client: httpClient,
+			Host:   pdsHost,
 		},
 	}
 }
 
-func (c *Client) ResolveHandle(ctx context.Context, handle string) (string, error) {
-	out, err := atproto.IdentityResolveHandle(ctx, c.XRPC, handle)
-	if err != nil {
-		return "", fmt.Errorf("resolving handle: %w", err)
-	}
-	return out.Did, nil
-}
-
-type DIDDocument struct {
-	Service []Service `json:"service"`
-}
-
-type Service struct {
-	ID              string `json:"id"`
-	Type            string `json:"type"`
-	ServiceEndpoint string `json:"serviceEndpoint"`
-}
-
-// ResolvePDS finds the PDS endpoint for a given DID using plc.directory
-func (c *Client) ResolvePDS(ctx context.Context, did string) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://plc.directory/%s", did), nil)
-	if err != nil {
-		return "", err
-	}
-
-	resp, err := http.DefaultClient.Do(req)
+// FetchEntries returns all records of collection in repo.
+func (c *Client) FetchEntries(ctx context.Context, repo string, collection string) ([]Record, error) {
+	var records []Record
+	err := c.EachRecord(ctx, repo, collection, func(rec Record) error {
+		records = append(records, rec)
+		return nil
+	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to fetch DID doc: %s", resp.Status)
-	}
-
-	var doc DIDDocument
-	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
-		return "", err
-	}
-
-	for _, svc := range doc.Service {
-		if svc.ID == "#atproto_pds" || svc.Type == "AtprotoPersonalDataServer" {
-			return svc.ServiceEndpoint, nil
-		}
-	}
-
-	return "", fmt.Errorf("no PDS service found for DID %s", did)
+	return records, nil
 }
 
-func (c *Client) FetchEntries(ctx context.Context, repo string, collection string) ([]Record, error) {
-	var records []Record
+// EachRecord calls fn for every record of collection in repo as the pages
+// of listRecords arrive, so callers can start working before the whole
+// collection is listed. It stops at the first error, including one
+// returned by fn.
+func (c *Client) EachRecord(ctx context.Context, repo string, collection string, fn func(Record) error) error {
 	cursor := ""
 
 	for {
@@ -100,17 +78,75 @@ func (c *Client) FetchEntries(ctx context.Context, repo string, collection strin
 		}
 
 		var out ListRecordsResponse
-		if err := c.XRPC.Do(ctx, xrpc.Query, "", "com.atproto.repo.listRecords", params, nil, &out); err != nil {
-			return nil, fmt.Errorf("listing records: %w", err)
+		err := c.call(ctx, func(xc *xrpc.Client) error {
+			return xc.Do(ctx, xrpc.Query, "", "com.atproto.repo.listRecords", params, nil, &out)
+		})
+		if err != nil {
+			return fmt.Errorf("listing records: %w", err)
 		}
 
-		records = append(records, out.Records...)
+		for _, rec := range out.Records {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
 
 		if out.Cursor == nil || *out.Cursor == "" {
-			break
+			return nil
 		}
 		cursor = *out.Cursor
 	}
+}
 
-	return records, nil
+// GetRecord fetches the record at an AT-URI via com.atproto.repo.getRecord.
+// A missing record is reported as ErrRecordNotFound.
+func (c *Client) GetRecord(ctx context.Context, uri string) (*Record, error) {
+	repo, collection, rkey, err := ParseATURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]interface{}{
+		"repo":       repo,
+		"collection": collection,
+		"rkey":       rkey,
+	}
+
+	var out Record
+	err = c.call(ctx, func(xc *xrpc.Client) error {
+		return xc.Do(ctx, xrpc.Query, "", "com.atproto.repo.getRecord", params, nil, &out)
+	})
+	if isRecordNotFound(err) {
+		return nil, fmt.Errorf("%w: %s", ErrRecordNotFound, uri)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting record: %w", err)
+	}
+	return &out, nil
+}
+
+func isRecordNotFound(err error) bool {
+	var xerr *xrpc.Error
+	if !errors.As(err, &xerr) {
+		return false
+	}
+	var inner *xrpc.XRPCError
+	return errors.As(xerr.Wrapped, &inner) && inner.ErrStr == "RecordNotFound"
+}
+
+// IsRepoUnavailable reports whether err means that the PDS can't be reached
+// or doesn't host the repo, as after an account migration.
+func IsRepoUnavailable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var xerr *xrpc.Error
+	if errors.As(err, &xerr) {
+		var inner *xrpc.XRPCError
+		if errors.As(xerr.Wrapped, &inner) && (inner.ErrStr == "RepoNotFound" || inner.ErrStr == "RepoDeactivated") {
+			return true
+		}
+		return xerr.StatusCode == http.StatusNotFound
+	}
+	var uerr *url.Error
+	return errors.As(err, &uerr)
 }
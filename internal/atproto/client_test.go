@@ -0,0 +1,96 @@
+package atproto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient_EachRecord(t *testing.T) {
+	var pages int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		out := ListRecordsResponse{Records: []Record{{Uri: fmt.Sprintf("at://%s/pub.leaflet.document/%d", testDID, pages)}}}
+		if pages < 3 {
+			cursor := fmt.Sprint(pages)
+			out.Cursor = &cursor
+		}
+		if r.URL.Query().Get("cursor") != fmt.Sprint(pages-1) && pages > 1 {
+			t.Errorf("expected cursor %d on page %d, got %q", pages-1, pages, r.URL.Query().Get("cursor"))
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	defer srv.Close()
+	client := NewClient(srv.URL, nil)
+
+	var uris []string
+	err := client.EachRecord(context.Background(), testDID, "pub.leaflet.document", func(rec Record) error {
+		uris = append(uris, rec.Uri)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachRecord failed: %v", err)
+	}
+	if len(uris) != 3 || uris[2] != "at://"+testDID+"/pub.leaflet.document/3" {
+		t.Errorf("expected records from 3 pages, got %v", uris)
+	}
+
+	// An error from fn stops listing before the next page is requested.
+	pages = 0
+	stop := errors.New("stop")
+	err = client.EachRecord(context.Background(), testDID, "pub.leaflet.document", func(rec Record) error {
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("expected the callback's error, got %v", err)
+	}
+	if pages != 1 {
+		t.Errorf("expected 1 page to be requested, got %d", pages)
+	}
+}
+
+func TestClient_GetRecord(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/xrpc/com.atproto.repo.getRecord" || q.Get("repo") != testDID || q.Get("collection") != "pub.leaflet.document" {
+			http.NotFound(w, r)
+			return
+		}
+		if q.Get("rkey") != "3aaa" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "RecordNotFound", "message": "Could not locate record"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"uri":   "at://" + testDID + "/pub.leaflet.document/3aaa",
+			"cid":   "bafyrecord",
+			"value": map[string]string{"$type": "pub.leaflet.document", "title": "First"},
+		})
+	}))
+	defer srv.Close()
+	client := NewClient(srv.URL, nil)
+
+	rec, err := client.GetRecord(context.Background(), "at://"+testDID+"/pub.leaflet.document/3aaa")
+	if err != nil {
+		t.Fatalf("GetRecord failed: %v", err)
+	}
+	var doc LeafletDocument
+	if err := json.Unmarshal(rec.Value, &doc); err != nil || doc.Title != "First" {
+		t.Errorf("unexpected record value %s", rec.Value)
+	}
+	if rec.Cid != "bafyrecord" {
+		t.Errorf("expected CID bafyrecord, got %s", rec.Cid)
+	}
+
+	_, err = client.GetRecord(context.Background(), "at://"+testDID+"/pub.leaflet.document/3zzz")
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+	if _, err := client.GetRecord(context.Background(), "https://leaflet.pub/3aaa"); err == nil {
+		t.Error("expected error for an invalid AT-URI, got nil")
+	}
+}
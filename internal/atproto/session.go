@@ -0,0 +1,118 @@
+package atproto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// Credentials authenticate a Client. Exactly one of AppPassword and
+// AccessToken should be set.
+type Credentials struct {
+	// Identifier is the handle or DID to log in as with AppPassword.
+	Identifier  string
+	AppPassword string
+	// AccessToken is a bearer token obtained elsewhere, e.g. via OAuth. It
+	// is used as is and cannot be refreshed.
+	AccessToken string
+}
+
+// Login authenticates the client. With an app password it creates a session
+// via com.atproto.server.createSession; expired access tokens are then
+// refreshed automatically.
+func (c *Client) Login(ctx context.Context, creds Credentials) error {
+	if creds.AccessToken != "" {
+		c.setAuth(&xrpc.AuthInfo{AccessJwt: creds.AccessToken})
+		return nil
+	}
+	if creds.AppPassword == "" {
+		return errors.New("no app password or access token given")
+	}
+
+	out, err := atproto.ServerCreateSession(ctx, c.snapshot(), &atproto.ServerCreateSession_Input{
+		Identifier: creds.Identifier,
+		Password:   creds.AppPassword,
+	})
+	if err != nil {
+		return fmt.Errorf("creating session: %w", err)
+	}
+	c.setAuth(&xrpc.AuthInfo{
+		AccessJwt:  out.AccessJwt,
+		RefreshJwt: out.RefreshJwt,
+		Handle:     out.Handle,
+		Did:        out.Did,
+	})
+	return nil
+}
+
+func (c *Client) setAuth(auth *xrpc.AuthInfo) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.XRPC.Auth = auth
+}
+
+// snapshot returns a copy of the XRPC client with the current auth, so a
+// request isn't affected by a concurrent refresh.
+func (c *Client) snapshot() *xrpc.Client {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	xc := *c.XRPC
+	return &xc
+}
+
+// call runs fn with a snapshot of the XRPC client, refreshing the session
+// and retrying once with a new snapshot if the access token has expired.
+func (c *Client) call(ctx context.Context, fn func(xc *xrpc.Client) error) error {
+	xc := c.snapshot()
+	err := fn(xc)
+	auth := xc.Auth
+	if err == nil || auth == nil || auth.RefreshJwt == "" || !isExpiredToken(err) {
+		return err
+	}
+	if err := c.refresh(ctx, auth); err != nil {
+		return err
+	}
+	return fn(c.snapshot())
+}
+
+// refresh exchanges the refresh token for new tokens, unless another call
+// already replaced the expired auth.
+func (c *Client) refresh(ctx context.Context, expired *xrpc.AuthInfo) error {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	if c.XRPC.Auth != expired {
+		return nil
+	}
+
+	// refreshSession takes the refresh token as its bearer token.
+	refreshClient := *c.XRPC
+	refreshClient.Auth = &xrpc.AuthInfo{AccessJwt: expired.RefreshJwt}
+	out, err := atproto.ServerRefreshSession(ctx, &refreshClient)
+	if err != nil {
+		return fmt.Errorf("refreshing session: %w", err)
+	}
+	c.XRPC.Auth = &xrpc.AuthInfo{
+		AccessJwt:  out.AccessJwt,
+		RefreshJwt: out.RefreshJwt,
+		Handle:     out.Handle,
+		Did:        out.Did,
+	}
+	return nil
+}
+
+func isExpiredToken(err error) bool {
+	var xerr *xrpc.Error
+	if !errors.As(err, &xerr) {
+		return false
+	}
+	var inner *xrpc.XRPCError
+	if errors.As(xerr.Wrapped, &inner) && inner.ErrStr == "ExpiredToken" {
+		return true
+	}
+	return xerr.StatusCode == http.StatusUnauthorized
+}
@@ -0,0 +1,158 @@
+package atproto
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+func TestClient_LoginAndRefresh(t *testing.T) {
+	var refreshes int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/xrpc/com.atproto.server.createSession":
+			var in struct{ Identifier, Password string }
+			json.NewDecoder(r.Body).Decode(&in)
+			if in.Identifier != testDID || in.Password != "app-password" {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(map[string]string{"error": "AuthenticationRequired"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{
+				"accessJwt": "access-1", "refreshJwt": "refresh-1", "did": testDID, "handle": "alice.test",
+			})
+		case "/xrpc/com.atproto.server.refreshSession":
+			if auth != "Bearer refresh-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "InvalidToken"})
+				return
+			}
+			refreshes++
+			json.NewEncoder(w).Encode(map[string]string{
+				"accessJwt": "access-2", "refreshJwt": "refresh-2", "did": testDID, "handle": "alice.test",
+			})
+		case "/xrpc/com.atproto.repo.listRecords":
+			if auth != "Bearer access-2" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "ExpiredToken", "message": "Token has expired"})
+				return
+			}
+			json.NewEncoder(w).Encode(ListRecordsResponse{Records: []Record{{Uri: "at://" + testDID + "/pub.leaflet.document/1"}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL, nil)
+	if err := client.Login(context.Background(), Credentials{Identifier: testDID, AppPassword: "wrong"}); err == nil {
+		t.Fatal("expected error for wrong password, got nil")
+	}
+	if err := client.Login(context.Background(), Credentials{Identifier: testDID, AppPassword: "app-password"}); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	records, err := client.FetchEntries(context.Background(), testDID, "pub.leaflet.document")
+	if err != nil {
+		t.Fatalf("FetchEntries failed: %v", err)
+	}
+	if len(records) != 1 {
+		t.Errorf("expected 1 record, got %d", len(records))
+	}
+	if refreshes != 1 {
+		t.Errorf("expected 1 refresh, got %d", refreshes)
+	}
+	if client.XRPC.Auth.RefreshJwt != "refresh-2" {
+		t.Errorf("expected refresh token to be rotated, got %q", client.XRPC.Auth.RefreshJwt)
+	}
+}
+
+// roundTripFunc serves requests in-process, so the only synchronization
+// between concurrent requests is the client's own.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestClient_ConcurrentRefresh(t *testing.T) {
+	var mu sync.Mutex
+	var refreshes int
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/xrpc/com.atproto.server.refreshSession":
+			mu.Lock()
+			refreshes++
+			mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]string{
+				"accessJwt": "access-2", "refreshJwt": "refresh-2", "did": testDID, "handle": "alice.test",
+			})
+		case "/xrpc/com.atproto.repo.getRecord":
+			if r.Header.Get("Authorization") != "Bearer access-2" {
+				// Keep the first requests in flight until all have read
+				// the expired token, without synchronizing them.
+				time.Sleep(50 * time.Millisecond)
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "ExpiredToken", "message": "Token has expired"})
+				return
+			}
+			json.NewEncoder(w).Encode(Record{Uri: "at://" + testDID + "/pub.leaflet.document/1"})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	httpClient := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Result(), nil
+	})}
+
+	client := NewClient("https://pds.example.com", httpClient)
+	client.XRPC.Auth = &xrpc.AuthInfo{AccessJwt: "access-1", RefreshJwt: "refresh-1"}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.GetRecord(context.Background(), "at://"+testDID+"/pub.leaflet.document/1")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("GetRecord failed: %v", err)
+		}
+	}
+	if refreshes != 1 {
+		t.Errorf("expected 1 refresh, got %d", refreshes)
+	}
+}
+
+func TestClient_AccessToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer oauth-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "AuthenticationRequired"})
+			return
+		}
+		json.NewEncoder(w).Encode(ListRecordsResponse{})
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL, nil)
+	if err := client.Login(context.Background(), Credentials{AccessToken: "oauth-token"}); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if _, err := client.FetchEntries(context.Background(), testDID, "pub.leaflet.document"); err != nil {
+		t.Errorf("FetchEntries failed: %v", err)
+	}
+}
@@ -74,9 +74,31 @@ type ListItem struct {
 }
 
 type ImageBlock struct {
-	Type  string `json:"$type"`
-	Image Blob   `json:"image"`
-	Alt   string `json:"alt"`
+	Type        string       `json:"$type"`
+	Image       Blob         `json:"image"`
+	Alt         string       `json:"alt"`
+	AspectRatio *AspectRatio `json:"aspectRatio,omitempty"`
+}
+
+// AspectRatio is the width to height ratio of an image as uploaded.
+type AspectRatio struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// VideoBlock embeds an uploaded video.
+type VideoBlock struct {
+	Type        string       `json:"$type"`
+	Video       Blob         `json:"video"`
+	Alt         string       `json:"alt"`
+	AspectRatio *AspectRatio `json:"aspectRatio,omitempty"`
+}
+
+// FileBlock attaches an uploaded file, e.g. a PDF or an audio recording.
+type FileBlock struct {
+	Type string `json:"$type"`
+	File Blob   `json:"file"`
+	Name string `json:"name"`
 }
 
 type BskyPostBlock struct {
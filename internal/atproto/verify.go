@@ -0,0 +1,101 @@
+package atproto
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/bluesky-social/indigo/atproto/repo/mst"
+	"github.com/ipfs/go-cid"
+)
+
+// ErrWrongRepo is returned by Verify for an archive of another repo than
+// the expected one.
+var ErrWrongRepo = errors.New("archive is of another repo")
+
+// VerificationError reports records whose content does not match the CID
+// recorded for them in the signed repo tree.
+type VerificationError struct {
+	// Tampered holds the "<collection>/<rkey>" paths of the affected records.
+	Tampered []string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("%d record(s) do not match their signed CID: %s", len(e.Tampered), strings.Join(e.Tampered, ", "))
+}
+
+// IsTampered reports whether the record at path failed verification.
+func (e *VerificationError) IsTampered(path string) bool {
+	for _, p := range e.Tampered {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks that the archive is the authentic repo of did: the commit
+// must be for did and signed by key, and the repo tree and every record must
+// match their CIDs. An archive of another repo is reported as ErrWrongRepo;
+// a bad signature or tree is returned as a plain error, since nothing in the
+// archive can be trusted then. Individually tampered records are returned as
+// a *VerificationError.
+func (a *Archive) Verify(did string, key atcrypto.PublicKey) error {
+	if a.DID != did {
+		return fmt.Errorf("%w: it is the repo of %s, expected %s", ErrWrongRepo, a.DID, did)
+	}
+	if err := a.Commit.VerifySignature(key); err != nil {
+		return fmt.Errorf("commit signature is invalid: %w", err)
+	}
+
+	if err := a.verifyNode(a.repo.MST.Root); err != nil {
+		return err
+	}
+
+	var tampered []string
+	err := a.repo.MST.Walk(func(key []byte, val cid.Cid) error {
+		if a.corrupt[val] {
+			tampered = append(tampered, string(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walking repo tree: %w", err)
+	}
+	if len(tampered) > 0 {
+		sort.Strings(tampered)
+		return &VerificationError{Tampered: tampered}
+	}
+
+	return nil
+}
+
+// verifyNode checks that the tree node and all nodes below it are intact.
+func (a *Archive) verifyNode(n *mst.Node) error {
+	if n == nil {
+		return nil
+	}
+	if n.CID != nil && a.corrupt[*n.CID] {
+		return fmt.Errorf("repo tree node %s does not match its CID", n.CID)
+	}
+	for _, e := range n.Entries {
+		if e.ChildCID != nil && e.Child == nil {
+			return fmt.Errorf("repo tree node %s is missing from the archive", e.ChildCID)
+		}
+		if err := a.verifyNode(e.Child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseSigningKey parses a public key given as a did:key or as a multibase
+// string, as found in DID documents.
+func ParseSigningKey(s string) (atcrypto.PublicKey, error) {
+	if strings.HasPrefix(s, "did:key:") {
+		return atcrypto.ParsePublicDIDKey(s)
+	}
+	return atcrypto.ParsePublicMultibase(s)
+}
@@ -1,47 +1,181 @@
 package config
 
 import (
-	"os"
+	"time"
 
-	"gopkg.in/yaml.v3"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/httpclient"
 )
 
 type Config struct {
-	Source   Source   `yaml:"source"`
-	Output   Output   `yaml:"output"`
-	Template Template `yaml:"template"`
+	Source      Source      `yaml:"source"`
+	Identity    Identity    `yaml:"identity"`
+	Auth        Auth        `yaml:"auth"`
+	Network     Network     `yaml:"network"`
+	Concurrency Concurrency `yaml:"concurrency"`
+	Output      Output      `yaml:"output"`
+	Images      Images      `yaml:"images"`
+	Media       Media       `yaml:"media"`
+	Storage     Storage     `yaml:"storage"`
+	Watch       Watch       `yaml:"watch"`
+	Serve       Serve       `yaml:"serve"`
+	Template    Template    `yaml:"template"`
 }
 
 type Source struct {
 	Handle          string `yaml:"handle"`
 	Collection      string `yaml:"collection"`
 	PublicationName string `yaml:"publication_name"`
+	Mode            string `yaml:"mode"`           // "api" (default), "repo" or "car"
+	CARFile         string `yaml:"car_file"`       // Repo CAR export, used in "car" mode
+	BlobsDir        string `yaml:"blobs_dir"`      // Optional local blobs named by CID
+	Verify          string `yaml:"verify"`         // "off" (default), "warn" or "strict"
+	SigningKey      string `yaml:"signing_key"`    // Optional did:key or multibase key to verify against
+	IncludeDrafts   bool   `yaml:"include_drafts"` // Sync unpublished documents with .Draft set
+}
+
+type Identity struct {
+	PLCDirectory   string `yaml:"plc_directory"`   // did:plc directory, e.g. a mirror
+	HandleFallback string `yaml:"handle_fallback"` // Optional server to resolve handles via, e.g. https://bsky.social
+	CacheFile      string `yaml:"cache_file"`      // On-disk resolution cache, defaults to the user cache dir
+	CacheTTL       string `yaml:"cache_ttl"`       // How long cached resolutions are trusted, e.g. "24h"
+}
+
+// Auth points at credentials for the PDS. The secrets themselves are never
+// part of the config; they come from files or the environment (see
+// AppPasswordEnv and AccessTokenEnv).
+type Auth struct {
+	AppPasswordFile string `yaml:"app_password_file"`
+	AccessTokenFile string `yaml:"access_token_file"`
+}
+
+// Network tunes retries and timeouts of all HTTP requests. Durations use Go
+// syntax, e.g. "500ms" or "2m".
+type Network struct {
+	MaxAttempts    int     `yaml:"max_attempts"`    // Tries per request, including the first
+	InitialBackoff string  `yaml:"initial_backoff"` // First retry delay, doubled per retry
+	MaxBackoff     string  `yaml:"max_backoff"`     // Upper bound for the retry delay
+	RequestTimeout string  `yaml:"request_timeout"` // Limit for a response, then for each pause in its body
+	Timeout        string  `yaml:"timeout"`         // Limit for the whole run, "0" for none
+	UserAgent      string  `yaml:"user_agent"`      // Defaults to leaflet-hugo-sync
+	Proxy          string  `yaml:"proxy"`           // Proxy URL, overrides HTTP(S)_PROXY
+	CAFile         string  `yaml:"ca_file"`         // Extra PEM CA certificates to trust
+	RateLimit      float64 `yaml:"rate_limit"`      // Requests per second to each host, 0 for no limit
+}
+
+// Concurrency bounds how much work a sync does in parallel. Output does not
+// depend on it.
+type Concurrency struct {
+	Posts     int `yaml:"posts"`     // Documents converted at the same time
+	Downloads int `yaml:"downloads"` // Blobs downloaded at the same time, across all documents
 }
 
 type Output struct {
 	PostsDir        string `yaml:"posts_dir"`
+	Layout          string `yaml:"layout"` // "flat" (default) or "bundle" for <slug>/index.md with co-located images
 	ImagesDir       string `yaml:"images_dir"`
 	ImagePathPrefix string `yaml:"image_path_prefix"`
 	BskyEmbedStyle  string `yaml:"bsky_embed_style"` // "link" (default) or "shortcode"
+	ImageStyle      string `yaml:"image_style"`      // "markdown" (default), "shortcode" or "html"
+	ImageCaptions   bool   `yaml:"image_captions"`   // Use alt text as caption in figures
+	StateDir        string `yaml:"state_dir"`        // Bookkeeping such as the media index, outside the published site
+	QuarantineDir   string `yaml:"quarantine_dir"`   // Blobs that failed verification end up here
+}
+
+// Images controls how downloaded images are processed.
+type Images struct {
+	Widths       []int `yaml:"widths"`        // Resized variants to render for srcset, in pixels
+	Quality      int   `yaml:"quality"`       // JPEG quality of re-encoded images, 1-100
+	KeepMetadata bool  `yaml:"keep_metadata"` // Keep EXIF/GPS and text metadata
+}
+
+// Media limits downloaded blobs of any type.
+type Media struct {
+	MaxSize string `yaml:"max_size"` // Larger blobs are linked on the PDS instead, e.g. "100MB", "0" for no limit
+}
+
+// Storage selects where downloaded media is published. S3 credentials come
+// from the environment (see S3AccessKeyEnv and S3SecretKeyEnv).
+type Storage struct {
+	Backend   string `yaml:"backend"`    // "local" (default) or "s3"
+	Endpoint  string `yaml:"endpoint"`   // S3 API URL, e.g. https://s3.eu-central-1.amazonaws.com
+	Region    string `yaml:"region"`     // Defaults to us-east-1
+	Bucket    string `yaml:"bucket"`     // Bucket to upload to
+	Prefix    string `yaml:"prefix"`     // Key prefix, e.g. "leaflet/"
+	PathStyle bool   `yaml:"path_style"` // <endpoint>/<bucket> URLs, needed by MinIO and most self-hosted stores
+	PublicURL string `yaml:"public_url"` // Base URL the bucket is served from, e.g. a CDN
+}
+
+// Watch configures the watch command, which syncs documents as they change.
+type Watch struct {
+	Source   string `yaml:"source"`   // "jetstream" (default) or "firehose" (com.atproto.sync.subscribeRepos)
+	Endpoint string `yaml:"endpoint"` // WebSocket URL, defaults to a public Jetstream instance or the PDS's firehose
+	Delay    string `yaml:"delay"`    // Wait after a change before syncing, so a burst of edits syncs once
+}
+
+// Serve configures the serve command, which runs syncs on HTTP requests.
+// The shared secret is never part of the config; it comes from a file or
+// the environment (see ServeSecretEnv).
+type Serve struct {
+	Listen          string   `yaml:"listen"`            // Address to listen on, e.g. ":8080"
+	SecretFile      string   `yaml:"secret_file"`       // File holding the shared secret
+	PostSyncCommand []string `yaml:"post_sync_command"` // Run after each successful sync, e.g. ["hugo", "--minify"]
 }
 
 type Template struct {
-	Frontmatter string `yaml:"frontmatter"`
-	Content     string `yaml:"content"`
+	Frontmatter     string `yaml:"frontmatter"`
+	Content         string `yaml:"content"`
+	FrontmatterFile string `yaml:"frontmatter_file,omitempty"` // Relative to the config file
+	ContentFile     string `yaml:"content_file,omitempty"`     // Relative to the config file
 }
 
+// LoadConfig reads, validates and applies defaults to the config at path.
+// Unknown keys are rejected so typos don't go unnoticed.
 func LoadConfig(path string) (*Config, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
+	return Load([]string{path}, LoadOptions{})
+}
 
-	var cfg Config
-	decoder := yaml.NewDecoder(f)
-	if err := decoder.Decode(&cfg); err != nil {
-		return nil, err
+// CacheDuration returns the parsed cache_ttl. The config must have been
+// validated.
+func (i Identity) CacheDuration() time.Duration {
+	d, _ := time.ParseDuration(i.CacheTTL)
+	return d
+}
+
+// HTTPOptions returns the retry and timeout options for HTTP requests. The
+// config must have been validated.
+func (n Network) HTTPOptions() httpclient.Options {
+	initial, _ := time.ParseDuration(n.InitialBackoff)
+	maxBackoff, _ := time.ParseDuration(n.MaxBackoff)
+	request, _ := time.ParseDuration(n.RequestTimeout)
+	return httpclient.Options{
+		MaxAttempts:    n.MaxAttempts,
+		InitialBackoff: initial,
+		MaxBackoff:     maxBackoff,
+		RequestTimeout: request,
+		UserAgent:      n.UserAgent,
+		ProxyURL:       n.Proxy,
+		CAFile:         n.CAFile,
+		RateLimit:      n.RateLimit,
 	}
+}
+
+// RunTimeout returns the limit for a whole run, or 0 for none. The config
+// must have been validated.
+func (n Network) RunTimeout() time.Duration {
+	d, _ := time.ParseDuration(n.Timeout)
+	return d
+}
+
+// DelayDuration returns the parsed delay. The config must have been
+// validated.
+func (w Watch) DelayDuration() time.Duration {
+	d, _ := time.ParseDuration(w.Delay)
+	return d
+}
 
-	return &cfg, nil
+// MaxBytes returns the parsed max_size, or 0 for no limit. The config must
+// have been validated.
+func (m Media) MaxBytes() int64 {
+	n, _ := parseSize(m.MaxSize)
+	return n
 }
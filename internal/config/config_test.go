@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -40,3 +42,320 @@ template:
 		t.Errorf("expected test.bsky.social, got %s", cfg.Source.Handle)
 	}
 }
+
+func TestLoadConfig_TemplateFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "frontmatter.tmpl"), []byte("---\ntitle: {{ .Title }}\n---"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	content := `
+source:
+  handle: "test.bsky.social"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+template:
+  frontmatter_file: "frontmatter.tmpl"
+`
+	configPath := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	expected := "---\ntitle: {{ .Title }}\n---"
+	if cfg.Template.Frontmatter != expected {
+		t.Errorf("expected frontmatter %q, got %q", expected, cfg.Template.Frontmatter)
+	}
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	content := `
+source:
+  handle: "test.bsky.social"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+template:
+  frontmatter: "---\ntitle: {{ .Title }}\n---"
+`
+	cfg, err := LoadConfig(writeConfig(t, content))
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if cfg.Source.Collection != DefaultCollection {
+		t.Errorf("expected collection %q, got %q", DefaultCollection, cfg.Source.Collection)
+	}
+	if cfg.Output.BskyEmbedStyle != DefaultBskyEmbedStyle {
+		t.Errorf("expected bsky_embed_style %q, got %q", DefaultBskyEmbedStyle, cfg.Output.BskyEmbedStyle)
+	}
+	if cfg.Output.ImageStyle != DefaultImageStyle {
+		t.Errorf("expected image_style %q, got %q", DefaultImageStyle, cfg.Output.ImageStyle)
+	}
+	if cfg.Template.Content != DefaultContentTemplate {
+		t.Errorf("expected content template %q, got %q", DefaultContentTemplate, cfg.Template.Content)
+	}
+	if cfg.Concurrency.Posts != DefaultPostWorkers || cfg.Concurrency.Downloads != DefaultDownloadWorkers {
+		t.Errorf("expected concurrency %d/%d, got %d/%d", DefaultPostWorkers, DefaultDownloadWorkers, cfg.Concurrency.Posts, cfg.Concurrency.Downloads)
+	}
+	if cfg.Serve.Listen != DefaultServeListen {
+		t.Errorf("expected listen address %q, got %q", DefaultServeListen, cfg.Serve.Listen)
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name: "unknown key",
+			content: `source:
+  handle: "test.bsky.social"
+output:
+  post_dir: "content/posts"
+`,
+			expected: "line 4: field post_dir not found",
+		},
+		{
+			name: "missing images_dir",
+			content: `source:
+  handle: "test.bsky.social"
+output:
+  posts_dir: "content/posts"
+template:
+  frontmatter: "---"
+`,
+			expected: "output.images_dir: is required",
+		},
+		{
+			name: "invalid embed style",
+			content: `source:
+  handle: "test.bsky.social"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+  bsky_embed_style: "iframe"
+template:
+  frontmatter: "---"
+`,
+			expected: "line 6: output.bsky_embed_style: must be one of",
+		},
+		{
+			name: "car mode without car_file",
+			content: `source:
+  mode: "car"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+template:
+  frontmatter: "---"
+`,
+			expected: "source.car_file: is required",
+		},
+		{
+			name: "verify car without handle",
+			content: `source:
+  mode: "car"
+  car_file: "repo.car"
+  verify: "warn"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+template:
+  frontmatter: "---"
+`,
+			expected: "source.handle: is required to verify whose repo the CAR file is",
+		},
+		{
+			name: "verify in api mode",
+			content: `source:
+  handle: "test.bsky.social"
+  verify: "strict"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+template:
+  frontmatter: "---"
+`,
+			expected: `line 3: source.verify: requires mode "repo" or "car"`,
+		},
+		{
+			name: "secret in config",
+			content: `source:
+  handle: "test.bsky.social"
+auth:
+  app_password: "hunter2"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+template:
+  frontmatter: "---"
+`,
+			expected: "field app_password not found",
+		},
+		{
+			name: "image quality",
+			content: `source:
+  handle: "test.bsky.social"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+images:
+  quality: 101
+template:
+  frontmatter: "---"
+`,
+			expected: "line 7: images.quality: must be between 1 and 100",
+		},
+		{
+			name: "concurrency",
+			content: `source:
+  handle: "test.bsky.social"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+concurrency:
+  downloads: -1
+template:
+  frontmatter: "---"
+`,
+			expected: "line 7: concurrency.downloads: must be at least 1",
+		},
+		{
+			name: "media size",
+			content: `source:
+  handle: "test.bsky.social"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+media:
+  max_size: "lots"
+template:
+  frontmatter: "---"
+`,
+			expected: "line 7: media.max_size: must be a size",
+		},
+		{
+			name: "s3 without bucket",
+			content: `source:
+  handle: "test.bsky.social"
+output:
+  posts_dir: "content/posts"
+storage:
+  backend: "s3"
+  endpoint: "http://localhost:9000"
+template:
+  frontmatter: "---"
+`,
+			expected: `storage.bucket: is required for backend "s3"`,
+		},
+		{
+			name: "watch endpoint",
+			content: `source:
+  handle: "test.bsky.social"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+watch:
+  endpoint: "https://jetstream.example.com"
+template:
+  frontmatter: "---"
+`,
+			expected: "line 7: watch.endpoint: must be a WebSocket URL",
+		},
+		{
+			name: "serve listen",
+			content: `source:
+  handle: "test.bsky.social"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+serve:
+  listen: "8080"
+template:
+  frontmatter: "---"
+`,
+			expected: "line 7: serve.listen: must be an address",
+		},
+		{
+			name: "template syntax",
+			content: `source:
+  handle: "test.bsky.social"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+template:
+  frontmatter: "title: {{ .Title "
+`,
+			expected: "line 7: template.frontmatter: invalid template",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, tt.content))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("expected error containing %q, got %q", tt.expected, err.Error())
+			}
+		})
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig_Bundle(t *testing.T) {
+	content := `
+source:
+  handle: "test.bsky.social"
+output:
+  posts_dir: "content/posts"
+  layout: "bundle"
+template:
+  frontmatter: "---"
+`
+	cfg, err := LoadConfig(writeConfig(t, content))
+	if err != nil {
+		t.Fatalf("expected images_dir to be optional for bundles, got %v", err)
+	}
+	if cfg.Output.Layout != LayoutBundle {
+		t.Errorf("expected layout %q, got %q", LayoutBundle, cfg.Output.Layout)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"0":      0,
+		"1024":   1024,
+		"512KB":  512000,
+		"100 MB": 100000000,
+		"1.5GiB": 3 << 29,
+		"10B":    10,
+	}
+	for in, expected := range tests {
+		got, err := parseSize(in)
+		if err != nil || got != expected {
+			t.Errorf("parseSize(%q): expected %d, got %d (%v)", in, expected, got, err)
+		}
+	}
+	if _, err := parseSize("-1MB"); err == nil {
+		t.Error("expected error for a negative size, got nil")
+	}
+}
@@ -0,0 +1,515 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// overrideOrigin is recorded as the source of values set via LoadOptions.
+const overrideOrigin = "command line"
+
+// LoadOptions controls how config files are combined.
+type LoadOptions struct {
+	// Overrides are "section.key=value" assignments applied on top of the
+	// merged files, e.g. "output.posts_dir=content/preview".
+	Overrides []string
+	// LookupEnv resolves ${VAR} references. Defaults to os.LookupEnv.
+	LookupEnv func(string) (string, bool)
+}
+
+// document is a merged YAML config together with the file each value
+// node came from, so errors can point at the right file and line.
+type document struct {
+	root   *yaml.Node
+	origin map[*yaml.Node]string
+}
+
+// Load reads the config files at paths, deep-merging later files over
+// earlier ones, then applies overrides, defaults and validation.
+//
+// Each file may name base files with an "extends:" key (a path or a list
+// of paths, relative to the file), which are merged underneath it. Scalar
+// values other than inline templates may reference environment variables
+// as ${VAR} or ${VAR:-default}; write $${ for a literal "${".
+func Load(paths []string, opts LoadOptions) (*Config, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no config file given")
+	}
+	if opts.LookupEnv == nil {
+		opts.LookupEnv = os.LookupEnv
+	}
+
+	doc := &document{origin: make(map[*yaml.Node]string)}
+	for _, path := range paths {
+		layer, err := doc.loadLayer(path, opts.LookupEnv, nil)
+		if err != nil {
+			return nil, err
+		}
+		doc.root = mergeNodes(doc.root, layer)
+	}
+
+	for _, o := range opts.Overrides {
+		if err := doc.applyOverride(o); err != nil {
+			return nil, err
+		}
+	}
+
+	var cfg Config
+	if err := doc.root.Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	cfg.ApplyDefaults()
+	if err := cfg.validate(doc); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// loadLayer parses a single file and everything it extends into a mapping
+// node. stack holds the files currently being loaded, to detect cycles.
+func (d *document) loadLayer(path string, lookupEnv func(string) (string, bool), stack []string) (*yaml.Node, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range stack {
+		if p == abs {
+			return nil, fmt.Errorf("%s: extends cycle: %s", path, strings.Join(append(stack, abs), " -> "))
+		}
+	}
+	stack = append(stack, abs)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file yaml.Node
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(file.Content) == 0 {
+		return nil, fmt.Errorf("%s: config file is empty", path)
+	}
+	root := file.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: line %d: config must be a mapping", path, root.Line)
+	}
+
+	// Reject unknown keys per file, so the error names the right file. Only
+	// the keys are checked here: values may still hold ${VAR} references
+	// that don't fit their field's type until they are expanded.
+	if errs := unknownFields(root, reflect.TypeOf(Config{}), "extends"); len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %w", path, &yaml.TypeError{Errors: errs})
+	}
+
+	// Inline templates are used verbatim: they may contain "${" of their
+	// own, e.g. in JavaScript template literals or shell snippets.
+	if err := expandNode(root, lookupEnv, templateNodes(root)); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var typed struct {
+		Config  `yaml:",inline"`
+		Extends yaml.Node `yaml:"extends"`
+	}
+	if err := root.Decode(&typed); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for _, name := range []string{"frontmatter", "content"} {
+		if err := inlineTemplateFile(root, dir, name); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	var base *yaml.Node
+	if extends := takeKey(root, "extends"); extends != nil {
+		var bases []string
+		switch extends.Kind {
+		case yaml.ScalarNode:
+			bases = []string{extends.Value}
+		case yaml.SequenceNode:
+			if err := extends.Decode(&bases); err != nil {
+				return nil, fmt.Errorf("%s: line %d: extends: %w", path, extends.Line, err)
+			}
+		default:
+			return nil, fmt.Errorf("%s: line %d: extends: must be a path or a list of paths", path, extends.Line)
+		}
+		for _, b := range bases {
+			if !filepath.IsAbs(b) {
+				b = filepath.Join(dir, b)
+			}
+			layer, err := d.loadLayer(b, lookupEnv, stack)
+			if err != nil {
+				return nil, err
+			}
+			base = mergeNodes(base, layer)
+		}
+	}
+
+	d.recordOrigin(root, path)
+	return mergeNodes(base, root), nil
+}
+
+func (d *document) recordOrigin(n *yaml.Node, path string) {
+	d.origin[n] = path
+	for _, c := range n.Content {
+		d.recordOrigin(c, path)
+	}
+}
+
+// applyOverride sets a dotted key such as "source.handle=foo".
+func (d *document) applyOverride(o string) error {
+	key, value, ok := strings.Cut(o, "=")
+	if !ok {
+		return fmt.Errorf("override %q: expected key=value", o)
+	}
+	path := strings.Split(strings.TrimSpace(key), ".")
+	t, ok := fieldType(reflect.TypeOf(Config{}), path)
+	if !ok {
+		return fmt.Errorf("override %q: unknown key %s", o, key)
+	}
+	valueNode := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	if t.Kind() == reflect.Slice {
+		var err error
+		if valueNode, err = sequenceNode(value); err != nil {
+			return fmt.Errorf("override %q: %w", o, err)
+		}
+	}
+	d.recordOrigin(valueNode, overrideOrigin)
+
+	if d.root == nil {
+		d.root = &yaml.Node{Kind: yaml.MappingNode}
+	}
+	node := d.root
+	for i, k := range path {
+		child := lookupKey(node, k)
+		if i == len(path)-1 {
+			if child == nil {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: k}, valueNode)
+			} else {
+				*child = *valueNode
+				d.origin[child] = overrideOrigin
+			}
+			break
+		}
+		if child == nil || child.Kind != yaml.MappingNode {
+			mapping := &yaml.Node{Kind: yaml.MappingNode}
+			if child == nil {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: k}, mapping)
+			} else {
+				*child = *mapping
+				mapping = child
+			}
+			child = mapping
+		}
+		node = child
+	}
+	return nil
+}
+
+// fieldType returns the type of the leaf field of t that path names via
+// the yaml tags, and whether there is one.
+func fieldType(t reflect.Type, path []string) (reflect.Type, bool) {
+	if len(path) == 0 {
+		return t, t.Kind() != reflect.Struct
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == path[0] {
+			return fieldType(f.Type, path[1:])
+		}
+	}
+	return nil, false
+}
+
+// sequenceNode parses the value of an override for a list field, written
+// in YAML flow style such as [480, 960] or as a single item. An empty
+// value is an empty list.
+func sequenceNode(value string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}, nil
+	}
+	switch n := doc.Content[0]; n.Kind {
+	case yaml.SequenceNode:
+		return n, nil
+	case yaml.ScalarNode:
+		return &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{n}}, nil
+	default:
+		return nil, fmt.Errorf("expected a list such as [a, b]")
+	}
+}
+
+// unknownFields returns an error, worded like the YAML decoder's, for each
+// key under n that t has no field for. Keys in extra are accepted at the top
+// level.
+func unknownFields(n *yaml.Node, t reflect.Type, extra ...string) []string {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var errs []string
+	switch {
+	case n.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := make(map[string]reflect.Type)
+		collectFields(t, fields)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			ft, ok := fields[key.Value]
+			switch {
+			case ok:
+				errs = append(errs, unknownFields(value, ft)...)
+			case !slices.Contains(extra, key.Value):
+				errs = append(errs, fmt.Sprintf("line %d: field %s not found in type %s", key.Line, key.Value, t))
+			}
+		}
+	case n.Kind == yaml.MappingNode && t.Kind() == reflect.Map:
+		for i := 1; i < len(n.Content); i += 2 {
+			errs = append(errs, unknownFields(n.Content[i], t.Elem())...)
+		}
+	case n.Kind == yaml.SequenceNode && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+		for _, c := range n.Content {
+			errs = append(errs, unknownFields(c, t.Elem())...)
+		}
+	}
+	return errs
+}
+
+// collectFields maps the yaml names of t's fields, including those of
+// inlined structs, to their types.
+func collectFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		switch {
+		case name == "-" || !f.IsExported():
+		case strings.Contains(opts, "inline"):
+			collectFields(f.Type, fields)
+		case name == "":
+			fields[strings.ToLower(f.Name)] = f.Type
+		default:
+			fields[name] = f.Type
+		}
+	}
+}
+
+// inlineTemplateFile replaces template.<name>_file with the file's contents
+// under template.<name>, resolving the path relative to dir.
+func inlineTemplateFile(root *yaml.Node, dir, name string) error {
+	tmpl := lookupKey(root, "template")
+	if tmpl == nil || tmpl.Kind != yaml.MappingNode {
+		return nil
+	}
+	fileKey := name + "_file"
+	file := lookupKey(tmpl, fileKey)
+	if file == nil || file.Value == "" {
+		return nil
+	}
+	if lookupKey(tmpl, name) != nil {
+		return &FieldError{Field: "template." + fileKey, Line: file.Line, Msg: fmt.Sprintf("cannot be combined with an inline %s template", name)}
+	}
+
+	path := file.Value
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return &FieldError{Field: "template." + fileKey, Line: file.Line, Msg: fmt.Sprintf("reading template: %v", err)}
+	}
+
+	for i := 0; i+1 < len(tmpl.Content); i += 2 {
+		if tmpl.Content[i].Value == fileKey {
+			tmpl.Content[i].Value = name
+			tmpl.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(data), Line: file.Line, Column: file.Column}
+		}
+	}
+	return nil
+}
+
+// mergeNodes deep-merges src over dst. Mappings are merged key by key;
+// any other value in src replaces the one in dst.
+func mergeNodes(dst, src *yaml.Node) *yaml.Node {
+	if dst == nil {
+		return src
+	}
+	if src == nil {
+		return dst
+	}
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		return src
+	}
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		replaced := false
+		for j := 0; j+1 < len(dst.Content); j += 2 {
+			if dst.Content[j].Value == key.Value {
+				dst.Content[j+1] = mergeNodes(dst.Content[j+1], value)
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			dst.Content = append(dst.Content, key, value)
+		}
+	}
+	return dst
+}
+
+// lookupKey returns the value node for key in a mapping node.
+func lookupKey(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// takeKey removes key from a mapping node and returns its value.
+func takeKey(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			value := mapping.Content[i+1]
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return value
+		}
+	}
+	return nil
+}
+
+// templateNodes returns the inline templates of a config file.
+func templateNodes(root *yaml.Node) map[*yaml.Node]bool {
+	nodes := make(map[*yaml.Node]bool)
+	tmpl := lookupKey(root, "template")
+	for _, name := range []string{"frontmatter", "content"} {
+		if n := lookupKey(tmpl, name); n != nil {
+			nodes[n] = true
+		}
+	}
+	return nodes
+}
+
+// expandNode expands environment variables in all scalar values except
+// the nodes in skip.
+func expandNode(n *yaml.Node, lookupEnv func(string) (string, bool), skip map[*yaml.Node]bool) error {
+	if skip[n] {
+		return nil
+	}
+	switch n.Kind {
+	case yaml.ScalarNode:
+		expanded, err := expandEnv(n.Value, lookupEnv)
+		if err != nil {
+			return &FieldError{Line: n.Line, Msg: err.Error()}
+		}
+		if expanded != n.Value && n.Style == 0 {
+			// Resolve the type of unquoted values from the expanded
+			// text, so ${VAR} works in numeric and boolean fields.
+			n.Tag = ""
+		}
+		n.Value = expanded
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			if err := expandNode(n.Content[i], lookupEnv, skip); err != nil {
+				if fe, ok := err.(*FieldError); ok && fe.Field == "" {
+					fe.Field = n.Content[i-1].Value
+				}
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, c := range n.Content {
+			if err := expandNode(c, lookupEnv, skip); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expandEnv replaces ${VAR} and ${VAR:-default} in s. Unlike os.Expand it
+// leaves bare $VAR alone, since templates use $ for their own variables.
+func expandEnv(s string, lookupEnv func(string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var sb strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i == -1 {
+			sb.WriteString(s)
+			break
+		}
+		if i > 0 && s[i-1] == '$' {
+			// $${ escapes a literal ${
+			sb.WriteString(s[:i])
+			sb.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		sb.WriteString(s[:i])
+		end := strings.IndexByte(s[i:], '}')
+		if end == -1 {
+			return "", fmt.Errorf("unterminated ${ in %q", s)
+		}
+		expr := s[i+2 : i+end]
+		s = s[i+end+1:]
+
+		name, def, hasDefault := strings.Cut(expr, ":-")
+		if name == "" {
+			return "", fmt.Errorf("empty variable name in ${%s}", expr)
+		}
+		value, ok := lookupEnv(name)
+		switch {
+		case ok && value != "":
+			sb.WriteString(value)
+		case hasDefault:
+			sb.WriteString(def)
+		case ok:
+			// Set but empty, no default
+		default:
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+	}
+	return sb.String(), nil
+}
+
+// Marshal renders the effective config as YAML.
+func (c *Config) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
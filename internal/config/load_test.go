@@ -0,0 +1,201 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoad_ExtendsAndOverrides(t *testing.T) {
+	dir := t.TempDir()
+	base := `
+source:
+  handle: "base.bsky.social"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+  image_path_prefix: "/images"
+template:
+  frontmatter: "---"
+`
+	staging := `
+extends: base.yaml
+output:
+  posts_dir: "content/staging"
+`
+	local := `
+output:
+  image_path_prefix: "/preview/images"
+`
+	for name, content := range map[string]string{"base.yaml": base, "staging.yaml": staging, "local.yaml": local} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := Load(
+		[]string{filepath.Join(dir, "staging.yaml"), filepath.Join(dir, "local.yaml")},
+		LoadOptions{Overrides: []string{"source.handle=cli.bsky.social"}},
+	)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.Source.Handle != "cli.bsky.social" {
+		t.Errorf("expected handle from override, got %q", cfg.Source.Handle)
+	}
+	if cfg.Output.PostsDir != "content/staging" {
+		t.Errorf("expected posts_dir from staging.yaml, got %q", cfg.Output.PostsDir)
+	}
+	if cfg.Output.ImagesDir != "static/images" {
+		t.Errorf("expected images_dir from base.yaml, got %q", cfg.Output.ImagesDir)
+	}
+	if cfg.Output.ImagePathPrefix != "/preview/images" {
+		t.Errorf("expected image_path_prefix from local.yaml, got %q", cfg.Output.ImagePathPrefix)
+	}
+}
+
+func TestLoad_EnvInTypedFields(t *testing.T) {
+	path := writeConfig(t, `
+source:
+  handle: "${HANDLE}"
+  include_drafts: ${DRAFTS}
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+concurrency:
+  posts: ${POSTS}
+  downloads: ${DOWNLOADS:-3}
+template:
+  frontmatter: "---\ndraft: {{ .Draft }}\n---"
+`)
+	env := map[string]string{"HANDLE": "me.bsky.social", "DRAFTS": "true", "POSTS": "2"}
+	cfg, err := Load([]string{path}, LoadOptions{LookupEnv: func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if !cfg.Source.IncludeDrafts {
+		t.Error("expected include_drafts from the environment")
+	}
+	if cfg.Concurrency.Posts != 2 || cfg.Concurrency.Downloads != 3 {
+		t.Errorf("expected concurrency 2/3, got %d/%d", cfg.Concurrency.Posts, cfg.Concurrency.Downloads)
+	}
+
+	env["POSTS"] = "many"
+	_, err = Load([]string{path}, LoadOptions{LookupEnv: func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}})
+	if err == nil || !strings.Contains(err.Error(), "line 9: cannot unmarshal") {
+		t.Errorf("expected a type error for the expanded value, got %v", err)
+	}
+}
+
+func TestLoad_UnknownOverride(t *testing.T) {
+	path := writeConfig(t, "source:\n  handle: x\n")
+	_, err := Load([]string{path}, LoadOptions{Overrides: []string{"output.post_dir=x"}})
+	if err == nil || !strings.Contains(err.Error(), "unknown key output.post_dir") {
+		t.Errorf("expected unknown key error, got %v", err)
+	}
+}
+
+func TestLoad_TemplatesNotExpanded(t *testing.T) {
+	path := writeConfig(t, `
+source:
+  handle: "${HANDLE}"
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+template:
+  frontmatter: "---\ntitle: {{ .Title }}\n---"
+  content: "{{ .Content }}\n<script>const url = '${location.href}';</script>"
+`)
+	cfg, err := Load([]string{path}, LoadOptions{LookupEnv: func(k string) (string, bool) {
+		return "me.bsky.social", k == "HANDLE"
+	}})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Source.Handle != "me.bsky.social" {
+		t.Errorf("expected handle from the environment, got %q", cfg.Source.Handle)
+	}
+	if !strings.Contains(cfg.Template.Content, "'${location.href}'") {
+		t.Errorf("expected the content template verbatim, got %q", cfg.Template.Content)
+	}
+}
+
+func TestLoad_ListOverride(t *testing.T) {
+	path := writeConfig(t, `
+source:
+  handle: x
+output:
+  posts_dir: "content/posts"
+  images_dir: "static/images"
+images:
+  widths: [320]
+template:
+  frontmatter: "---"
+`)
+	tests := []struct {
+		override string
+		expected []int
+	}{
+		{"images.widths=[480, 960]", []int{480, 960}},
+		{"images.widths=640", []int{640}},
+		{"images.widths=", nil},
+	}
+	for _, tt := range tests {
+		cfg, err := Load([]string{path}, LoadOptions{Overrides: []string{tt.override}})
+		if err != nil {
+			t.Errorf("%s: Load failed: %v", tt.override, err)
+			continue
+		}
+		if !slices.Equal(cfg.Images.Widths, tt.expected) {
+			t.Errorf("%s: expected widths %v, got %v", tt.override, tt.expected, cfg.Images.Widths)
+		}
+	}
+
+	_, err := Load([]string{path}, LoadOptions{Overrides: []string{"images.widths={a: 1}"}})
+	if err == nil || !strings.Contains(err.Error(), "expected a list") {
+		t.Errorf("expected a list error, got %v", err)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"HANDLE": "me.bsky.social", "EMPTY": ""}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+
+	tests := []struct {
+		in       string
+		expected string
+		wantErr  bool
+	}{
+		{"${HANDLE}", "me.bsky.social", false},
+		{"at://${HANDLE}/x", "at://me.bsky.social/x", false},
+		{"${MISSING:-fallback}", "fallback", false},
+		{"${EMPTY:-fallback}", "fallback", false},
+		{"{{ $x := .Title }}", "{{ $x := .Title }}", false},
+		{"$${HANDLE}", "${HANDLE}", false},
+		{"${MISSING}", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := expandEnv(tt.in, lookup)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expandEnv(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("expandEnv(%q) = %q, expected %q", tt.in, got, tt.expected)
+		}
+	}
+}
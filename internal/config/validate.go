@@ -0,0 +1,422 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/httpclient"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/identity"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/stream"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/templatefuncs"
+)
+
+// Defaults for optional settings.
+const (
+	DefaultCollection      = "pub.leaflet.document"
+	DefaultSourceMode      = SourceModeAPI
+	DefaultVerify          = VerifyOff
+	DefaultPLCDirectory    = identity.DefaultPLCDirectory
+	DefaultCacheTTL        = "24h"
+	DefaultMaxAttempts     = httpclient.DefaultMaxAttempts
+	DefaultInitialBackoff  = "500ms"
+	DefaultMaxBackoff      = "30s"
+	DefaultRequestTimeout  = "60s"
+	DefaultTimeout         = "30m"
+	DefaultStateDir        = ".leaflet-sync"
+	DefaultPostWorkers     = 4
+	DefaultDownloadWorkers = 8
+	DefaultLayout          = LayoutFlat
+	DefaultImageQuality    = 85
+	DefaultMaxMediaSize    = "100MB"
+	DefaultStorageBackend  = StorageLocal
+	DefaultS3Region        = "us-east-1"
+	DefaultWatchSource     = WatchJetstream
+	DefaultJetstreamURL    = stream.DefaultJetstreamURL
+	DefaultWatchDelay      = "10s"
+	DefaultServeListen     = ":8080"
+	DefaultBskyEmbedStyle  = "link"
+	DefaultImageStyle      = "markdown"
+	DefaultContentTemplate = "{{ .Content }}"
+)
+
+// Environment variables holding PDS credentials. They take precedence over
+// the files configured in auth.
+const (
+	AppPasswordEnv = "LEAFLET_SYNC_APP_PASSWORD"
+	AccessTokenEnv = "LEAFLET_SYNC_ACCESS_TOKEN"
+)
+
+// ServeSecretEnv holds the shared secret of the serve command. It takes
+// precedence over serve.secret_file.
+const ServeSecretEnv = "LEAFLET_SYNC_SERVE_SECRET"
+
+// Environment variables holding the credentials of the S3 storage backend.
+// They are the ones the AWS tools use.
+const (
+	S3AccessKeyEnv = "AWS_ACCESS_KEY_ID"
+	S3SecretKeyEnv = "AWS_SECRET_ACCESS_KEY"
+)
+
+// Source modes select where records are read from.
+const (
+	// SourceModeAPI lists records through the PDS XRPC API.
+	SourceModeAPI = "api"
+	// SourceModeRepo downloads the whole repo as a CAR export via getRepo.
+	SourceModeRepo = "repo"
+	// SourceModeCAR reads a local CAR export without any network access.
+	SourceModeCAR = "car"
+)
+
+// SourceModes lists the accepted values for source.mode.
+var SourceModes = []string{SourceModeAPI, SourceModeRepo, SourceModeCAR}
+
+// Verification levels for repo exports.
+const (
+	// VerifyOff skips verification.
+	VerifyOff = "off"
+	// VerifyWarn reports verification failures and skips tampered records.
+	VerifyWarn = "warn"
+	// VerifyStrict aborts the sync on any verification failure.
+	VerifyStrict = "strict"
+)
+
+// VerifyLevels lists the accepted values for source.verify.
+var VerifyLevels = []string{VerifyOff, VerifyWarn, VerifyStrict}
+
+// Post layouts select how posts and their images are laid out.
+const (
+	// LayoutFlat writes posts as <posts_dir>/<name>.md and images to
+	// images_dir.
+	LayoutFlat = "flat"
+	// LayoutBundle writes posts as Hugo page bundles,
+	// <posts_dir>/<name>/index.md, with their images next to them.
+	LayoutBundle = "bundle"
+)
+
+// Layouts lists the accepted values for output.layout.
+var Layouts = []string{LayoutFlat, LayoutBundle}
+
+// Storage backends select where media is published.
+const (
+	// StorageLocal stores media in images_dir, or in page bundles.
+	StorageLocal = "local"
+	// StorageS3 uploads media to an S3-compatible bucket.
+	StorageS3 = "s3"
+)
+
+// StorageBackends lists the accepted values for storage.backend.
+var StorageBackends = []string{StorageLocal, StorageS3}
+
+// Watch sources select where the watch command gets changes from.
+const (
+	// WatchJetstream subscribes to a Jetstream instance.
+	WatchJetstream = "jetstream"
+	// WatchFirehose subscribes to com.atproto.sync.subscribeRepos of the
+	// PDS or a relay.
+	WatchFirehose = "firehose"
+)
+
+// WatchSources lists the accepted values for watch.source.
+var WatchSources = []string{WatchJetstream, WatchFirehose}
+
+// BskyEmbedStyles lists the accepted values for output.bsky_embed_style.
+var BskyEmbedStyles = []string{"link", "shortcode"}
+
+// ImageStyles lists the accepted values for output.image_style.
+var ImageStyles = []string{"markdown", "shortcode", "html"}
+
+// FieldError describes an invalid config value. File and Line locate the
+// value in the YAML files; they are empty if the key is missing.
+type FieldError struct {
+	Field string
+	File  string
+	Line  int
+	Msg   string
+}
+
+func (e *FieldError) Error() string {
+	var sb strings.Builder
+	if e.File != "" {
+		sb.WriteString(e.File + ": ")
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&sb, "line %d: ", e.Line)
+	}
+	if e.Field != "" {
+		sb.WriteString(e.Field + ": ")
+	}
+	sb.WriteString(e.Msg)
+	return sb.String()
+}
+
+// ApplyDefaults fills in optional settings that were left empty.
+func (c *Config) ApplyDefaults() {
+	if c.Source.Collection == "" {
+		c.Source.Collection = DefaultCollection
+	}
+	if c.Source.Mode == "" {
+		c.Source.Mode = DefaultSourceMode
+	}
+	if c.Source.Verify == "" {
+		c.Source.Verify = DefaultVerify
+	}
+	if c.Identity.PLCDirectory == "" {
+		c.Identity.PLCDirectory = DefaultPLCDirectory
+	}
+	if c.Identity.CacheTTL == "" {
+		c.Identity.CacheTTL = DefaultCacheTTL
+	}
+	if c.Network.MaxAttempts == 0 {
+		c.Network.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.Network.InitialBackoff == "" {
+		c.Network.InitialBackoff = DefaultInitialBackoff
+	}
+	if c.Network.MaxBackoff == "" {
+		c.Network.MaxBackoff = DefaultMaxBackoff
+	}
+	if c.Network.RequestTimeout == "" {
+		c.Network.RequestTimeout = DefaultRequestTimeout
+	}
+	if c.Network.Timeout == "" {
+		c.Network.Timeout = DefaultTimeout
+	}
+	if c.Output.Layout == "" {
+		c.Output.Layout = DefaultLayout
+	}
+	if c.Concurrency.Posts == 0 {
+		c.Concurrency.Posts = DefaultPostWorkers
+	}
+	if c.Concurrency.Downloads == 0 {
+		c.Concurrency.Downloads = DefaultDownloadWorkers
+	}
+	if c.Output.StateDir == "" {
+		c.Output.StateDir = DefaultStateDir
+	}
+	if c.Output.QuarantineDir == "" {
+		c.Output.QuarantineDir = filepath.Join(c.Output.StateDir, "quarantine")
+	}
+	if c.Images.Quality == 0 {
+		c.Images.Quality = DefaultImageQuality
+	}
+	if c.Media.MaxSize == "" {
+		c.Media.MaxSize = DefaultMaxMediaSize
+	}
+	if c.Storage.Backend == "" {
+		c.Storage.Backend = DefaultStorageBackend
+	}
+	if c.Storage.Backend == StorageS3 && c.Storage.Region == "" {
+		c.Storage.Region = DefaultS3Region
+	}
+	if c.Watch.Source == "" {
+		c.Watch.Source = DefaultWatchSource
+	}
+	if c.Watch.Source == WatchJetstream && c.Watch.Endpoint == "" {
+		c.Watch.Endpoint = DefaultJetstreamURL
+	}
+	if c.Watch.Delay == "" {
+		c.Watch.Delay = DefaultWatchDelay
+	}
+	if c.Serve.Listen == "" {
+		c.Serve.Listen = DefaultServeListen
+	}
+	if c.Output.BskyEmbedStyle == "" {
+		c.Output.BskyEmbedStyle = DefaultBskyEmbedStyle
+	}
+	if c.Output.ImageStyle == "" {
+		c.Output.ImageStyle = DefaultImageStyle
+	}
+	if c.Template.Content == "" {
+		c.Template.Content = DefaultContentTemplate
+	}
+}
+
+// validate checks required fields, enum values and template syntax. doc is
+// the merged YAML document, used to point errors at the offending line.
+func (c *Config) validate(doc *document) error {
+	var errs []error
+	fail := func(msg string, path ...string) {
+		errs = append(errs, doc.fieldError(msg, path...))
+	}
+
+	if c.Source.Handle == "" && c.Source.Mode != SourceModeCAR {
+		fail("is required", "source", "handle")
+	}
+	if !slices.Contains(SourceModes, c.Source.Mode) {
+		fail(fmt.Sprintf("must be one of %q, got %q", SourceModes, c.Source.Mode), "source", "mode")
+	}
+	if c.Source.Mode == SourceModeCAR && c.Source.CARFile == "" {
+		fail(`is required when mode is "car"`, "source", "car_file")
+	}
+	if c.Source.Mode == SourceModeCAR && c.Source.Verify != VerifyOff && c.Source.Handle == "" && c.Source.SigningKey == "" {
+		fail("is required to verify whose repo the CAR file is, unless signing_key is set", "source", "handle")
+	}
+	if !slices.Contains(VerifyLevels, c.Source.Verify) {
+		fail(fmt.Sprintf("must be one of %q, got %q", VerifyLevels, c.Source.Verify), "source", "verify")
+	} else if c.Source.Verify != VerifyOff && c.Source.Mode == SourceModeAPI {
+		fail(`requires mode "repo" or "car", listRecords responses carry no proofs`, "source", "verify")
+	}
+	if c.Auth.AppPasswordFile != "" && c.Auth.AccessTokenFile != "" {
+		fail("cannot be combined with app_password_file", "auth", "access_token_file")
+	}
+	if u, err := url.Parse(c.Identity.PLCDirectory); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		fail(fmt.Sprintf("must be an http(s) URL, got %q", c.Identity.PLCDirectory), "identity", "plc_directory")
+	}
+	if ttl, err := time.ParseDuration(c.Identity.CacheTTL); err != nil || ttl < 0 {
+		fail(fmt.Sprintf("must be a non-negative duration like \"24h\", got %q", c.Identity.CacheTTL), "identity", "cache_ttl")
+	}
+	if c.Identity.HandleFallback != "" {
+		if u, err := url.Parse(c.Identity.HandleFallback); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			fail(fmt.Sprintf("must be an http(s) URL, got %q", c.Identity.HandleFallback), "identity", "handle_fallback")
+		}
+	}
+	if c.Network.MaxAttempts < 1 {
+		fail(fmt.Sprintf("must be at least 1, got %d", c.Network.MaxAttempts), "network", "max_attempts")
+	}
+	for _, d := range []struct{ key, value string }{
+		{"initial_backoff", c.Network.InitialBackoff},
+		{"max_backoff", c.Network.MaxBackoff},
+		{"request_timeout", c.Network.RequestTimeout},
+		{"timeout", c.Network.Timeout},
+	} {
+		if v, err := time.ParseDuration(d.value); err != nil || v < 0 {
+			fail(fmt.Sprintf("must be a non-negative duration like \"30s\", got %q", d.value), "network", d.key)
+		}
+	}
+	if c.Network.Proxy != "" {
+		if u, err := url.Parse(c.Network.Proxy); err != nil || u.Scheme == "" || u.Host == "" {
+			fail(fmt.Sprintf("must be a URL like \"http://proxy:3128\", got %q", c.Network.Proxy), "network", "proxy")
+		}
+	}
+	if c.Network.RateLimit < 0 {
+		fail(fmt.Sprintf("must not be negative, got %g", c.Network.RateLimit), "network", "rate_limit")
+	}
+	if c.Concurrency.Posts < 1 {
+		fail(fmt.Sprintf("must be at least 1, got %d", c.Concurrency.Posts), "concurrency", "posts")
+	}
+	if c.Concurrency.Downloads < 1 {
+		fail(fmt.Sprintf("must be at least 1, got %d", c.Concurrency.Downloads), "concurrency", "downloads")
+	}
+	if c.Output.PostsDir == "" {
+		fail("is required", "output", "posts_dir")
+	}
+	if !slices.Contains(Layouts, c.Output.Layout) {
+		fail(fmt.Sprintf("must be one of %q, got %q", Layouts, c.Output.Layout), "output", "layout")
+	}
+	if c.Output.ImagesDir == "" && c.Output.Layout != LayoutBundle && c.Storage.Backend == StorageLocal {
+		fail("is required, images would otherwise be written to the current directory", "output", "images_dir")
+	}
+	if !slices.Contains(BskyEmbedStyles, c.Output.BskyEmbedStyle) {
+		fail(fmt.Sprintf("must be one of %q, got %q", BskyEmbedStyles, c.Output.BskyEmbedStyle), "output", "bsky_embed_style")
+	}
+	if !slices.Contains(ImageStyles, c.Output.ImageStyle) {
+		fail(fmt.Sprintf("must be one of %q, got %q", ImageStyles, c.Output.ImageStyle), "output", "image_style")
+	}
+	if c.Images.Quality < 1 || c.Images.Quality > 100 {
+		fail(fmt.Sprintf("must be between 1 and 100, got %d", c.Images.Quality), "images", "quality")
+	}
+	for _, w := range c.Images.Widths {
+		if w < 1 {
+			fail(fmt.Sprintf("must be positive pixel widths, got %d", w), "images", "widths")
+			break
+		}
+	}
+	if _, err := parseSize(c.Media.MaxSize); err != nil {
+		fail(fmt.Sprintf("must be a size like \"100MB\", got %q", c.Media.MaxSize), "media", "max_size")
+	}
+	if !slices.Contains(StorageBackends, c.Storage.Backend) {
+		fail(fmt.Sprintf("must be one of %q, got %q", StorageBackends, c.Storage.Backend), "storage", "backend")
+	} else if c.Storage.Backend == StorageS3 {
+		if c.Output.Layout == LayoutBundle {
+			fail("cannot be combined with layout \"bundle\", bundles keep media next to the post", "storage", "backend")
+		}
+		if u, err := url.Parse(c.Storage.Endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			fail(fmt.Sprintf("must be an http(s) URL, got %q", c.Storage.Endpoint), "storage", "endpoint")
+		}
+		if c.Storage.Bucket == "" {
+			fail(`is required for backend "s3"`, "storage", "bucket")
+		}
+		if c.Storage.PublicURL != "" {
+			if u, err := url.Parse(c.Storage.PublicURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				fail(fmt.Sprintf("must be an http(s) URL, got %q", c.Storage.PublicURL), "storage", "public_url")
+			}
+		}
+	}
+	if !slices.Contains(WatchSources, c.Watch.Source) {
+		fail(fmt.Sprintf("must be one of %q, got %q", WatchSources, c.Watch.Source), "watch", "source")
+	}
+	if c.Watch.Endpoint != "" {
+		if u, err := url.Parse(c.Watch.Endpoint); err != nil || (u.Scheme != "wss" && u.Scheme != "ws") || u.Host == "" {
+			fail(fmt.Sprintf("must be a WebSocket URL like \"wss://jetstream.example.com/subscribe\", got %q", c.Watch.Endpoint), "watch", "endpoint")
+		}
+	}
+	if v, err := time.ParseDuration(c.Watch.Delay); err != nil || v < 0 {
+		fail(fmt.Sprintf("must be a non-negative duration like \"30s\", got %q", c.Watch.Delay), "watch", "delay")
+	}
+	if _, _, err := net.SplitHostPort(c.Serve.Listen); err != nil {
+		fail(fmt.Sprintf("must be an address like \":8080\" or \"127.0.0.1:8080\", got %q", c.Serve.Listen), "serve", "listen")
+	}
+	if len(c.Serve.PostSyncCommand) > 0 && c.Serve.PostSyncCommand[0] == "" {
+		fail("must start with the program to run", "serve", "post_sync_command")
+	}
+
+	if c.Template.Frontmatter == "" {
+		fail("is required (or set frontmatter_file)", "template", "frontmatter")
+	}
+	if _, err := template.New("frontmatter").Funcs(templatefuncs.FuncMap()).Parse(c.Template.Frontmatter); err != nil {
+		fail(fmt.Sprintf("invalid template: %v", err), "template", "frontmatter")
+	}
+	if _, err := template.New("content").Funcs(templatefuncs.FuncMap()).Parse(c.Template.Content); err != nil {
+		fail(fmt.Sprintf("invalid template: %v", err), "template", "content")
+	}
+
+	return errors.Join(errs...)
+}
+
+// sizeUnits are the accepted suffixes of sizes, longest first.
+var sizeUnits = []struct {
+	suffix string
+	factor float64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"B", 1},
+}
+
+// parseSize parses a byte size like "512KB", "1.5GiB" or "1024".
+func parseSize(s string) (int64, error) {
+	num, factor := strings.TrimSpace(s), 1.0
+	for _, u := range sizeUnits {
+		if n, ok := strings.CutSuffix(num, u.suffix); ok {
+			num, factor = strings.TrimSpace(n), u.factor
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * factor), nil
+}
+
+// fieldError builds a FieldError located at the value for path.
+func (d *document) fieldError(msg string, path ...string) *FieldError {
+	fe := &FieldError{Field: strings.Join(path, "."), Msg: msg}
+	node := d.root
+	for _, key := range path {
+		node = lookupKey(node, key)
+	}
+	if node != nil {
+		fe.Line = node.Line
+		fe.File = d.origin[node]
+	}
+	return fe
+}
@@ -0,0 +1,109 @@
+package converter
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Image styles select how images are rendered.
+const (
+	// ImageStyleMarkdown renders ![alt](src), or a bare <img> if there are
+	// resized copies.
+	ImageStyleMarkdown = "markdown"
+	// ImageStyleShortcode renders Hugo's {{< figure >}} shortcode.
+	ImageStyleShortcode = "shortcode"
+	// ImageStyleHTML renders a <figure> with an <img> and <figcaption>.
+	ImageStyleHTML = "html"
+)
+
+// Image is a stored image to render into a post.
+type Image struct {
+	Src     string
+	Alt     string
+	Caption string
+	// Width and Height are the pixel dimensions, if known.
+	Width, Height int
+	// AspectWidth and AspectHeight give the aspect ratio, used for layout
+	// when the dimensions are unknown.
+	AspectWidth, AspectHeight int
+	// Srcset lists resized copies for responsive images.
+	Srcset []ImageSource
+}
+
+// ImageSource is one candidate of a srcset.
+type ImageSource struct {
+	Src   string
+	Width int
+}
+
+// RenderImage renders img in the given style. Unknown styles fall back to
+// Markdown.
+func RenderImage(img Image, style string) string {
+	switch style {
+	case ImageStyleShortcode:
+		return renderFigureShortcode(img)
+	case ImageStyleHTML:
+		var sb strings.Builder
+		sb.WriteString("<figure>\n")
+		sb.WriteString(renderImg(img) + "\n")
+		if img.Caption != "" {
+			fmt.Fprintf(&sb, "<figcaption>%s</figcaption>\n", html.EscapeString(img.Caption))
+		}
+		sb.WriteString("</figure>")
+		return sb.String()
+	}
+
+	// Markdown can't express srcset or dimensions.
+	if len(img.Srcset) == 0 {
+		return fmt.Sprintf("![%s](%s)", img.Alt, img.Src)
+	}
+	return renderImg(img)
+}
+
+// renderImg renders an <img> tag with srcset, dimensions and lazy loading.
+func renderImg(img Image) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<img src="%s"`, html.EscapeString(img.Src))
+	if len(img.Srcset) > 0 {
+		candidates := make([]string, 0, len(img.Srcset)+1)
+		for _, s := range img.Srcset {
+			candidates = append(candidates, fmt.Sprintf("%s %dw", s.Src, s.Width))
+		}
+		if img.Width > 0 {
+			candidates = append(candidates, fmt.Sprintf("%s %dw", img.Src, img.Width))
+		}
+		fmt.Fprintf(&sb, ` srcset="%s" sizes="100vw"`, html.EscapeString(strings.Join(candidates, ", ")))
+	}
+	if img.Width > 0 && img.Height > 0 {
+		fmt.Fprintf(&sb, ` width="%d" height="%d"`, img.Width, img.Height)
+	} else if img.AspectWidth > 0 && img.AspectHeight > 0 {
+		fmt.Fprintf(&sb, ` style="aspect-ratio: %d / %d"`, img.AspectWidth, img.AspectHeight)
+	}
+	fmt.Fprintf(&sb, ` alt="%s" loading="lazy">`, html.EscapeString(img.Alt))
+	return sb.String()
+}
+
+// renderFigureShortcode renders Hugo's built-in figure shortcode. It has no
+// srcset or aspect ratio parameters, so those are left out.
+func renderFigureShortcode(img Image) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `{{< figure src=%s`, shortcodeQuote(img.Src))
+	if img.Alt != "" {
+		fmt.Fprintf(&sb, ` alt=%s`, shortcodeQuote(img.Alt))
+	}
+	if img.Caption != "" {
+		fmt.Fprintf(&sb, ` caption=%s`, shortcodeQuote(img.Caption))
+	}
+	if img.Width > 0 && img.Height > 0 {
+		fmt.Fprintf(&sb, ` width="%d" height="%d"`, img.Width, img.Height)
+	}
+	sb.WriteString(` loading="lazy" >}}`)
+	return sb.String()
+}
+
+// shortcodeQuote quotes a shortcode parameter, escaping quotes and
+// backslashes. Shortcode parameters can't span lines.
+func shortcodeQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ").Replace(s) + `"`
+}
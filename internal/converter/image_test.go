@@ -0,0 +1,46 @@
+package converter
+
+import "testing"
+
+func TestRenderImage(t *testing.T) {
+	srcset := []ImageSource{{Src: "/images/a-200w.png", Width: 200}}
+	tests := []struct {
+		name     string
+		img      Image
+		style    string
+		expected string
+	}{
+		{
+			name:     "markdown",
+			img:      Image{Src: "/images/a.png", Alt: "A cat", Caption: "A cat", Width: 400, Height: 300},
+			style:    ImageStyleMarkdown,
+			expected: "![A cat](/images/a.png)",
+		},
+		{
+			name:     "markdown with srcset",
+			img:      Image{Src: "/images/a.png", Alt: `"Tom" & Jerry`, Width: 400, Height: 300, Srcset: srcset},
+			style:    ImageStyleMarkdown,
+			expected: `<img src="/images/a.png" srcset="/images/a-200w.png 200w, /images/a.png 400w" sizes="100vw" width="400" height="300" alt="&#34;Tom&#34; &amp; Jerry" loading="lazy">`,
+		},
+		{
+			name:     "shortcode",
+			img:      Image{Src: "/images/a.png", Alt: `Say "hi"`, Caption: `Say "hi"`, Width: 400, Height: 300, Srcset: srcset},
+			style:    ImageStyleShortcode,
+			expected: `{{< figure src="/images/a.png" alt="Say \"hi\"" caption="Say \"hi\"" width="400" height="300" loading="lazy" >}}`,
+		},
+		{
+			name:     "html with aspect ratio",
+			img:      Image{Src: "https://pds.example/blob", Alt: "A cat", Caption: "A <cat>", AspectWidth: 4, AspectHeight: 3},
+			style:    ImageStyleHTML,
+			expected: "<figure>\n<img src=\"https://pds.example/blob\" style=\"aspect-ratio: 4 / 3\" alt=\"A cat\" loading=\"lazy\">\n<figcaption>A &lt;cat&gt;</figcaption>\n</figure>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderImage(tt.img, tt.style); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -15,11 +15,36 @@ type Converter struct {
 type ConversionResult struct {
 	Markdown string
 	Images   []ImageRef
+	Media    []MediaRef
 }
 
 type ImageRef struct {
+	Blob        atproto.Blob
+	Alt         string
+	AspectRatio *atproto.AspectRatio
+	// Markdown is the snippet rendered for the image, with the blob CID as
+	// placeholder URL.
+	Markdown string
+}
+
+// Kinds of non-image media.
+const (
+	MediaVideo = "video"
+	MediaAudio = "audio"
+	MediaFile  = "file"
+)
+
+// MediaRef is a video, audio or file blob embedded in a document.
+type MediaRef struct {
 	Blob atproto.Blob
-	Alt  string
+	// Kind is MediaVideo, MediaAudio or MediaFile.
+	Kind string
+	// Name is the file name of attachments or the alt text of videos.
+	Name        string
+	AspectRatio *atproto.AspectRatio
+	// Markdown is the placeholder rendered for the blob, like
+	// ImageRef.Markdown.
+	Markdown string
 }
 
 func NewConverter(bskyEmbedStyle string) *Converter {
@@ -35,6 +60,7 @@ func NewConverter(bskyEmbedStyle string) *Converter {
 func (c *Converter) ConvertLeaflet(doc *atproto.LeafletDocument) (*ConversionResult, error) {
 	var sb strings.Builder
 	var images []ImageRef
+	var media []MediaRef
 
 	for _, page := range doc.Pages {
 		for _, blockWrapper := range page.Blocks {
@@ -78,8 +104,36 @@ func (c *Converter) ConvertLeaflet(doc *atproto.LeafletDocument) (*ConversionRes
 					continue
 				}
 				// Use blob CID as placeholder URL; main.go replaces it with the local path
-				sb.WriteString(fmt.Sprintf("![%s](%s)\n\n", imgBlock.Alt, imgBlock.Image.Ref.Link))
-				images = append(images, ImageRef{Blob: imgBlock.Image, Alt: imgBlock.Alt})
+				md := fmt.Sprintf("![%s](%s)", imgBlock.Alt, imgBlock.Image.Ref.Link)
+				sb.WriteString(md + "\n\n")
+				images = append(images, ImageRef{Blob: imgBlock.Image, Alt: imgBlock.Alt, AspectRatio: imgBlock.AspectRatio, Markdown: md})
+
+			case "pub.leaflet.blocks.video":
+				var videoBlock atproto.VideoBlock
+				if err := json.Unmarshal(blockWrapper.Block, &videoBlock); err != nil {
+					continue
+				}
+				ref := newMediaRef(videoBlock.Video, MediaVideo, videoBlock.Alt)
+				ref.AspectRatio = videoBlock.AspectRatio
+				sb.WriteString(ref.Markdown + "\n\n")
+				media = append(media, ref)
+
+			case "pub.leaflet.blocks.file":
+				var fileBlock atproto.FileBlock
+				if err := json.Unmarshal(blockWrapper.Block, &fileBlock); err != nil {
+					continue
+				}
+				// Play audio and video attachments inline
+				kind := MediaFile
+				switch {
+				case strings.HasPrefix(fileBlock.File.Mime, "audio/"):
+					kind = MediaAudio
+				case strings.HasPrefix(fileBlock.File.Mime, "video/"):
+					kind = MediaVideo
+				}
+				ref := newMediaRef(fileBlock.File, kind, fileBlock.Name)
+				sb.WriteString(ref.Markdown + "\n\n")
+				media = append(media, ref)
 
 			case "pub.leaflet.blocks.bskyPost":
 				var postBlock atproto.BskyPostBlock
@@ -105,9 +159,24 @@ func (c *Converter) ConvertLeaflet(doc *atproto.LeafletDocument) (*ConversionRes
 	return &ConversionResult{
 		Markdown: sb.String(),
 		Images:   images,
+		Media:    media,
 	}, nil
 }
 
+// newMediaRef returns a MediaRef whose placeholder links the blob CID.
+func newMediaRef(blob atproto.Blob, kind, name string) MediaRef {
+	label := name
+	if label == "" {
+		label = kind
+	}
+	return MediaRef{
+		Blob:     blob,
+		Kind:     kind,
+		Name:     name,
+		Markdown: fmt.Sprintf("[%s](%s)", escapeLinkText(label), blob.Ref.Link),
+	}
+}
+
 func (c *Converter) renderText(block *atproto.TextBlock) string {
 	// Apply facets to convert rich text to markdown
 	// Note: ATProto facets use byte offsets, not rune offsets
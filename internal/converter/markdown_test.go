@@ -82,6 +82,7 @@ func TestConvertLeaflet_ImageBlock(t *testing.T) {
 									Link: "bafytest123",
 								},
 							},
+							AspectRatio: &atproto.AspectRatio{Width: 4, Height: 3},
 						}),
 					},
 				},
@@ -104,6 +105,9 @@ func TestConvertLeaflet_ImageBlock(t *testing.T) {
 	if result.Images[0].Alt != "Test Image" {
 		t.Errorf("expected alt 'Test Image', got %q", result.Images[0].Alt)
 	}
+	if ar := result.Images[0].AspectRatio; ar == nil || ar.Width != 4 || ar.Height != 3 {
+		t.Errorf("expected aspect ratio 4:3, got %v", ar)
+	}
 }
 
 func TestRenderText_WithLinkFacet(t *testing.T) {
@@ -0,0 +1,81 @@
+package converter
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Media is a stored video, audio or file blob to render into a post.
+type Media struct {
+	// Kind is MediaVideo, MediaAudio or MediaFile.
+	Kind     string
+	Src      string
+	Name     string
+	MimeType string
+	Size     int
+	// AspectWidth and AspectHeight give the aspect ratio of videos.
+	AspectWidth, AspectHeight int
+}
+
+// RenderMedia renders videos and audio as HTML players, and other files as
+// a download link with their type and size.
+func RenderMedia(m Media) string {
+	switch m.Kind {
+	case MediaVideo:
+		var sb strings.Builder
+		fmt.Fprintf(&sb, `<video controls preload="metadata" src="%s"`, html.EscapeString(m.Src))
+		if m.Name != "" {
+			fmt.Fprintf(&sb, ` title="%s"`, html.EscapeString(m.Name))
+		}
+		if m.AspectWidth > 0 && m.AspectHeight > 0 {
+			fmt.Fprintf(&sb, ` style="aspect-ratio: %d / %d"`, m.AspectWidth, m.AspectHeight)
+		}
+		sb.WriteString("></video>")
+		return sb.String()
+	case MediaAudio:
+		var sb strings.Builder
+		fmt.Fprintf(&sb, `<audio controls preload="metadata" src="%s"`, html.EscapeString(m.Src))
+		if m.Name != "" {
+			fmt.Fprintf(&sb, ` title="%s"`, html.EscapeString(m.Name))
+		}
+		sb.WriteString("></audio>")
+		return sb.String()
+	}
+
+	name := m.Name
+	if name == "" {
+		name = "Download"
+	}
+	var details []string
+	if m.MimeType != "" {
+		details = append(details, m.MimeType)
+	}
+	if m.Size > 0 {
+		details = append(details, formatSize(m.Size))
+	}
+	link := fmt.Sprintf("[%s](%s)", escapeLinkText(name), m.Src)
+	if len(details) == 0 {
+		return link
+	}
+	return fmt.Sprintf("%s (%s)", link, strings.Join(details, ", "))
+}
+
+// formatSize formats a byte count with decimal units, e.g. "1.2 MB".
+func formatSize(n int) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := unit, 0
+	for v := n / unit; v >= unit && exp < 3; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGT"[exp])
+}
+
+// escapeLinkText escapes brackets so text can't end a Markdown link early.
+func escapeLinkText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
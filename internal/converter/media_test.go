@@ -0,0 +1,91 @@
+package converter
+
+import (
+	"testing"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+)
+
+func TestConvertLeaflet_MediaBlocks(t *testing.T) {
+	doc := &atproto.LeafletDocument{
+		Pages: []atproto.Page{{
+			Blocks: []atproto.BlockWrapper{
+				{Block: mustMarshal(atproto.VideoBlock{
+					Type:        "pub.leaflet.blocks.video",
+					Video:       atproto.Blob{Ref: atproto.BlobRef{Link: "bafyvideo"}, Mime: "video/mp4"},
+					Alt:         "A cat",
+					AspectRatio: &atproto.AspectRatio{Width: 16, Height: 9},
+				})},
+				{Block: mustMarshal(atproto.FileBlock{
+					Type: "pub.leaflet.blocks.file",
+					File: atproto.Blob{Ref: atproto.BlobRef{Link: "bafyaudio"}, Mime: "audio/mpeg"},
+					Name: "interview.mp3",
+				})},
+				{Block: mustMarshal(atproto.FileBlock{
+					Type: "pub.leaflet.blocks.file",
+					File: atproto.Blob{Ref: atproto.BlobRef{Link: "bafypdf"}, Mime: "application/pdf"},
+					Name: "slides [final].pdf",
+				})},
+			},
+		}},
+	}
+
+	result, err := NewConverter("").ConvertLeaflet(doc)
+	if err != nil {
+		t.Fatalf("ConvertLeaflet failed: %v", err)
+	}
+
+	expected := "[A cat](bafyvideo)\n\n[interview.mp3](bafyaudio)\n\n[slides \\[final\\].pdf](bafypdf)\n\n"
+	if result.Markdown != expected {
+		t.Errorf("expected %q, got %q", expected, result.Markdown)
+	}
+	kinds := []string{MediaVideo, MediaAudio, MediaFile}
+	if len(result.Media) != len(kinds) {
+		t.Fatalf("expected %d media references, got %d", len(kinds), len(result.Media))
+	}
+	for i, kind := range kinds {
+		if result.Media[i].Kind != kind {
+			t.Errorf("expected kind %q, got %q", kind, result.Media[i].Kind)
+		}
+	}
+	if ar := result.Media[0].AspectRatio; ar == nil || ar.Width != 16 {
+		t.Errorf("expected the video's aspect ratio, got %v", ar)
+	}
+}
+
+func TestRenderMedia(t *testing.T) {
+	tests := []struct {
+		name     string
+		media    Media
+		expected string
+	}{
+		{
+			name:     "video",
+			media:    Media{Kind: MediaVideo, Src: "/images/a.mp4", Name: "A cat", AspectWidth: 16, AspectHeight: 9},
+			expected: `<video controls preload="metadata" src="/images/a.mp4" title="A cat" style="aspect-ratio: 16 / 9"></video>`,
+		},
+		{
+			name:     "audio",
+			media:    Media{Kind: MediaAudio, Src: "/images/a.mp3"},
+			expected: `<audio controls preload="metadata" src="/images/a.mp3"></audio>`,
+		},
+		{
+			name:     "file",
+			media:    Media{Kind: MediaFile, Src: "/images/a.pdf", Name: "slides.pdf", MimeType: "application/pdf", Size: 1234567},
+			expected: "[slides.pdf](/images/a.pdf) (application/pdf, 1.2 MB)",
+		},
+		{
+			name:     "unnamed file",
+			media:    Media{Kind: MediaFile, Src: "/images/a.pdf", Size: 999},
+			expected: "[Download](/images/a.pdf) (999 B)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderMedia(tt.media); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// reDraftKey matches a top-level draft key in YAML or TOML frontmatter.
+var reDraftKey = regexp.MustCompile(`^["']?draft["']?\s*[:=]`)
+
+// markDraft adds draft: true to the rendered frontmatter fm unless the
+// template already sets a draft key, so drafts are never published by a
+// template that doesn't use .Draft. Frontmatter that isn't delimited YAML
+// (---) or TOML (+++) is an error.
+func markDraft(fm string) (string, error) {
+	lines := strings.Split(fm, "\n")
+	start := 0
+	for start < len(lines) && strings.TrimSpace(lines[start]) == "" {
+		start++
+	}
+	if start == len(lines) {
+		return "", fmt.Errorf("cannot mark draft: frontmatter is empty")
+	}
+
+	var delim, field string
+	switch first := strings.TrimSpace(lines[start]); first {
+	case "---":
+		delim, field = "---", "draft: true"
+	case "+++":
+		delim, field = "+++", "draft = true"
+	default:
+		return "", fmt.Errorf("cannot mark draft: frontmatter must start with --- or +++, got %q", first)
+	}
+
+	for i := start + 1; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == delim {
+			return strings.Join(slices.Insert(lines, i, field), "\n"), nil
+		}
+		if line == strings.TrimLeft(line, " \t") && reDraftKey.MatchString(line) {
+			return fm, nil
+		}
+	}
+	return "", fmt.Errorf("cannot mark draft: frontmatter has no closing %s", delim)
+}
@@ -2,11 +2,14 @@ package generator
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"text/template"
 
 	"mariuskimmina.com/leaflet-hugo-sync/internal/config"
+	"mariuskimmina.com/leaflet-hugo-sync/internal/templatefuncs"
 )
 
 type Generator struct {
@@ -15,12 +18,15 @@ type Generator struct {
 
 type PostData struct {
 	Title       string
+	Description string
+	Tags        []string
 	CreatedAt   string
 	Slug        string
 	Filename    string
 	Handle      string
 	OriginalURL string
 	Content     string
+	Draft       bool
 	Data        map[string]interface{}
 }
 
@@ -28,9 +34,64 @@ func NewGenerator(cfg *config.Config) *Generator {
 	return &Generator{Cfg: cfg}
 }
 
+// PostPath returns the file a post is written to: <filename>.md, or
+// <filename>/index.md in a page bundle.
+func (g *Generator) PostPath(data PostData) string {
+	// Use Filename if provided, otherwise fall back to Slug
+	filename := data.Filename
+	if filename == "" {
+		filename = data.Slug
+	}
+	if g.Cfg.Output.Layout == config.LayoutBundle {
+		return filepath.Join(g.Cfg.Output.PostsDir, filename, "index.md")
+	}
+	return filepath.Join(g.Cfg.Output.PostsDir, filename+".md")
+}
+
+// BundleDir creates and returns the page bundle directory of a post, where
+// its images are stored. It must only be used with the bundle layout.
+func (g *Generator) BundleDir(data PostData) (string, error) {
+	if g.Cfg.Output.Layout != config.LayoutBundle {
+		return "", fmt.Errorf("posts are not written as page bundles")
+	}
+	dir := filepath.Dir(g.PostPath(data))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// RemovePost deletes a post file returned by PostPath. In the bundle layout
+// the whole bundle goes, including its images. A missing post is not an
+// error.
+func (g *Generator) RemovePost(path string) error {
+	rel, err := filepath.Rel(g.Cfg.Output.PostsDir, path)
+	if err != nil || rel == "." || !filepath.IsLocal(rel) {
+		return fmt.Errorf("%s is not in %s", path, g.Cfg.Output.PostsDir)
+	}
+	if base := g.keepBasePath(path); base != "" {
+		if err := removeIfExists(base); err != nil {
+			return err
+		}
+	}
+	if g.Cfg.Output.Layout == config.LayoutBundle && filepath.Base(path) == "index.md" && filepath.Dir(rel) != "." {
+		return os.RemoveAll(filepath.Dir(path))
+	}
+	return removeIfExists(path)
+}
+
+// GeneratePost writes the post for data, keeping the protected regions of
+// the file it replaces.
 func (g *Generator) GeneratePost(data PostData) error {
+	return g.GeneratePostFrom(data, "")
+}
+
+// GeneratePostFrom is like GeneratePost for a post that was written to
+// previous before, e.g. under its old title. The protected regions of
+// previous are kept; removing it is up to the caller.
+func (g *Generator) GeneratePostFrom(data PostData, previous string) error {
 	// 1. Generate Frontmatter
-	tmplFM, err := template.New("frontmatter").Parse(g.Cfg.Template.Frontmatter)
+	tmplFM, err := template.New("frontmatter").Funcs(templatefuncs.FuncMap()).Parse(g.Cfg.Template.Frontmatter)
 	if err != nil {
 		return err
 	}
@@ -39,6 +100,12 @@ func (g *Generator) GeneratePost(data PostData) error {
 	if err := tmplFM.Execute(&bufFM, data); err != nil {
 		return err
 	}
+	frontmatter := bufFM.String()
+	if data.Draft {
+		if frontmatter, err = markDraft(frontmatter); err != nil {
+			return err
+		}
+	}
 
 	// 2. Generate Content
 	contentTmplStr := g.Cfg.Template.Content
@@ -46,7 +113,7 @@ func (g *Generator) GeneratePost(data PostData) error {
 		contentTmplStr = "{{ .Content }}" // Default
 	}
 
-	tmplContent, err := template.New("content").Parse(contentTmplStr)
+	tmplContent, err := template.New("content").Funcs(templatefuncs.FuncMap()).Parse(contentTmplStr)
 	if err != nil {
 		return err
 	}
@@ -56,19 +123,101 @@ func (g *Generator) GeneratePost(data PostData) error {
 		return err
 	}
 
-	if err := os.MkdirAll(g.Cfg.Output.PostsDir, 0755); err != nil {
+	filePath := g.PostPath(data)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 		return err
 	}
 
-	// Use Filename if provided, otherwise fall back to Slug
-	filename := data.Filename
-	if filename == "" {
-		filename = data.Slug
+	fullContent := frontmatter + "\n" + bufContent.String()
+	slots, err := extractKeepRegions(fullContent)
+	if err != nil {
+		return fmt.Errorf("generated content: %w", err)
+	}
+
+	// Preserve protected regions from a previously generated file
+	if previous == "" {
+		previous = filePath
+	}
+	if existing, err := os.ReadFile(previous); err == nil {
+		kept, err := extractKeepRegions(string(existing))
+		if err != nil {
+			return fmt.Errorf("%s: %w", previous, err)
+		}
+		base, err := g.loadKeepBase(previous)
+		if err != nil {
+			return err
+		}
+		fullContent, err = mergeKeepRegions(fullContent, slots, kept, base)
+		if err != nil {
+			return fmt.Errorf("%s: %w", previous, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
 	}
-	fileName := filename + ".md"
-	filePath := filepath.Join(g.Cfg.Output.PostsDir, fileName)
 
-	fullContent := bufFM.String() + "\n" + bufContent.String()
+	if err := os.WriteFile(filePath, []byte(fullContent), 0644); err != nil {
+		return err
+	}
+	return g.saveKeepBase(filePath, slots)
+}
+
+// keepBasePath returns the file in the state dir that records the regions
+// last generated for the post at path, or "" without a state dir.
+func (g *Generator) keepBasePath(path string) string {
+	rel, err := filepath.Rel(g.Cfg.Output.PostsDir, path)
+	if g.Cfg.Output.StateDir == "" || err != nil || !filepath.IsLocal(rel) {
+		return ""
+	}
+	return filepath.Join(g.Cfg.Output.StateDir, "keep", rel+".json")
+}
 
-	return os.WriteFile(filePath, []byte(fullContent), 0644)
+// loadKeepBase returns the regions last generated for the post at path, by
+// keepKeys. It is nil if none were recorded.
+func (g *Generator) loadKeepBase(path string) (map[string]string, error) {
+	file := g.keepBasePath(path)
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var base map[string]string
+	if err := json.Unmarshal(data, &base); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return base, nil
+}
+
+// saveKeepBase records the generated regions of the post at path.
+func (g *Generator) saveKeepBase(path string, slots []keepRegion) error {
+	file := g.keepBasePath(path)
+	if file == "" {
+		return nil
+	}
+	if len(slots) == 0 {
+		return removeIfExists(file)
+	}
+	base := make(map[string]string, len(slots))
+	for i, key := range keepKeys(slots) {
+		base[key] = slots[i].Body
+	}
+	data, err := json.MarshalIndent(base, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
@@ -1,8 +1,10 @@
 package generator
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"mariuskimmina.com/leaflet-hugo-sync/internal/config"
@@ -50,3 +52,348 @@ func TestGeneratePost(t *testing.T) {
 		t.Errorf("expected content %q, got %q", expectedContent, string(content))
 	}
 }
+
+func TestGeneratePost_Draft(t *testing.T) {
+	tests := []struct {
+		name        string
+		frontmatter string
+		expected    string
+	}{
+		{"yaml", "---\ntitle: {{ .Title }}\n---", "---\ntitle: Draft\ndraft: true\n---\n"},
+		{"toml", "+++\ntitle = '{{ .Title }}'\n+++", "+++\ntitle = 'Draft'\ndraft = true\n+++\n"},
+		{"template sets draft", "---\ntitle: {{ .Title }}\n{{- if .Draft }}\ndraft: true\n{{- end }}\n---", "---\ntitle: Draft\ndraft: true\n---\n"},
+		{"nested draft key", "---\nparams:\n  draft: false\n---", "---\nparams:\n  draft: false\ndraft: true\n---\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := &config.Config{
+				Output:   config.Output{PostsDir: dir},
+				Template: config.Template{Frontmatter: tt.frontmatter},
+			}
+			if err := NewGenerator(cfg).GeneratePost(PostData{Title: "Draft", Slug: "draft", Draft: true}); err != nil {
+				t.Fatalf("GeneratePost failed: %v", err)
+			}
+			content, err := os.ReadFile(filepath.Join(dir, "draft.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, string(content))
+			}
+		})
+	}
+
+	cfg := &config.Config{
+		Output:   config.Output{PostsDir: t.TempDir()},
+		Template: config.Template{Frontmatter: "title: {{ .Title }}"},
+	}
+	if err := NewGenerator(cfg).GeneratePost(PostData{Title: "Draft", Slug: "draft", Draft: true}); err == nil {
+		t.Error("expected an error for a draft without delimited frontmatter, got nil")
+	}
+}
+
+func TestGeneratePost_PreservesKeepRegions(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Output: config.Output{
+			PostsDir: tmpDir,
+		},
+		Template: config.Template{
+			Frontmatter: "---\ntitle: \"{{ .Title }}\"\n---",
+			Content:     "{{ .Content }}\n<!-- leaflet-sync:keep footer --><!-- leaflet-sync:end -->\n",
+		},
+	}
+	gen := NewGenerator(cfg)
+	data := PostData{Title: "Hello", Slug: "hello", Content: "Original body."}
+
+	if err := gen.GeneratePost(data); err != nil {
+		t.Fatalf("GeneratePost failed: %v", err)
+	}
+
+	path := filepath.Join(tmpDir, "hello.md")
+	local := "---\ntitle: \"Hello\"\n---\nOriginal body.\n" +
+		"<!-- leaflet-sync:keep footer -->\n{{< newsletter >}}\n<!-- leaflet-sync:end -->\n\n" +
+		"<!-- leaflet-sync:keep -->\nUpdate: fixed a typo.\n<!-- leaflet-sync:end -->\n"
+	if err := os.WriteFile(path, []byte(local), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data.Content = "Edited body."
+	if err := gen.GeneratePost(data); err != nil {
+		t.Fatalf("GeneratePost failed: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "---\ntitle: \"Hello\"\n---\nEdited body.\n" +
+		"<!-- leaflet-sync:keep footer -->\n{{< newsletter >}}\n<!-- leaflet-sync:end -->\n\n" +
+		"<!-- leaflet-sync:keep -->\nUpdate: fixed a typo.\n<!-- leaflet-sync:end -->\n"
+	if string(content) != expected {
+		t.Errorf("expected content %q, got %q", expected, string(content))
+	}
+}
+
+func TestGeneratePost_KeepRegionsStable(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Output: config.Output{
+			PostsDir: tmpDir,
+		},
+		Template: config.Template{
+			Frontmatter: "---\ntitle: \"{{ .Title }}\"\n---",
+			Content: "{{ .Content }}\n<!-- leaflet-sync:keep --><!-- leaflet-sync:end -->\n" +
+				"<!-- leaflet-sync:keep footer -->Default footer<!-- leaflet-sync:end -->\n",
+		},
+	}
+	gen := NewGenerator(cfg)
+	data := PostData{Title: "Hello", Slug: "hello", Content: "Body."}
+
+	if err := gen.GeneratePost(data); err != nil {
+		t.Fatalf("GeneratePost failed: %v", err)
+	}
+	path := filepath.Join(tmpDir, "hello.md")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "---\ntitle: \"Hello\"\n---\nBody.\n<!-- leaflet-sync:keep --><!-- leaflet-sync:end -->\n" +
+		"<!-- leaflet-sync:keep footer -->Default footer<!-- leaflet-sync:end -->\n"
+	if string(content) != expected {
+		t.Fatalf("expected content %q, got %q", expected, string(content))
+	}
+
+	// Edit both regions and add one of our own.
+	local := "---\ntitle: \"Hello\"\n---\nBody.\n<!-- leaflet-sync:keep -->Local note<!-- leaflet-sync:end -->\n" +
+		"<!-- leaflet-sync:keep footer -->Edited footer<!-- leaflet-sync:end -->\n\n" +
+		"<!-- leaflet-sync:keep -->Appended<!-- leaflet-sync:end -->\n"
+	if err := os.WriteFile(path, []byte(local), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 4 {
+		if err := gen.GeneratePost(data); err != nil {
+			t.Fatalf("sync %d: GeneratePost failed: %v", i+1, err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != local {
+			t.Fatalf("sync %d: expected content %q, got %q", i+1, local, string(content))
+		}
+	}
+}
+
+func TestGeneratePost_KeepRegionConflict(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Output: config.Output{
+			PostsDir: tmpDir,
+		},
+		Template: config.Template{
+			Frontmatter: "---\ntitle: \"{{ .Title }}\"\n---",
+		},
+	}
+	gen := NewGenerator(cfg)
+
+	path := filepath.Join(tmpDir, "hello.md")
+	local := "Body\n<!-- leaflet-sync:keep -->\nUnterminated local edit\n"
+	if err := os.WriteFile(path, []byte(local), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := gen.GeneratePost(PostData{Title: "Hello", Slug: "hello", Content: "New body."})
+	if !errors.Is(err, ErrKeepConflict) {
+		t.Fatalf("expected ErrKeepConflict, got %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != local {
+		t.Errorf("expected local file to be left untouched, got %q", string(content))
+	}
+}
+
+func TestGeneratePost_KeepRegionEditedOnBothSides(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Output: config.Output{
+			PostsDir: filepath.Join(dir, "posts"),
+			StateDir: filepath.Join(dir, "state"),
+		},
+		Template: config.Template{
+			Frontmatter: "---\ntitle: \"{{ .Title }}\"\n---",
+			Content:     "{{ .Content }}\n<!-- leaflet-sync:keep footer -->Default<!-- leaflet-sync:end -->\n",
+		},
+	}
+	gen := NewGenerator(cfg)
+	data := PostData{Title: "Hello", Slug: "hello", Content: "Body."}
+	if err := gen.GeneratePost(data); err != nil {
+		t.Fatalf("GeneratePost failed: %v", err)
+	}
+	path := filepath.Join(cfg.Output.PostsDir, "hello.md")
+	read := func() string {
+		t.Helper()
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	// A region that wasn't edited locally follows the template.
+	cfg.Template.Content = "{{ .Content }}\n<!-- leaflet-sync:keep footer -->New default<!-- leaflet-sync:end -->\n"
+	if err := gen.GeneratePost(data); err != nil {
+		t.Fatalf("GeneratePost failed: %v", err)
+	}
+	if content := read(); !strings.Contains(content, "-->New default<!--") {
+		t.Errorf("expected the template's new default, got %q", content)
+	}
+
+	// Edited locally and in the template: neither side may be dropped.
+	local := strings.Replace(read(), "New default", "Edited", 1)
+	if err := os.WriteFile(path, []byte(local), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Template.Content = "{{ .Content }}\n<!-- leaflet-sync:keep footer -->Newer default<!-- leaflet-sync:end -->\n"
+	if err := gen.GeneratePost(data); !errors.Is(err, ErrKeepConflict) {
+		t.Fatalf("expected ErrKeepConflict, got %v", err)
+	}
+	if content := read(); content != local {
+		t.Errorf("expected local file to be left untouched, got %q", content)
+	}
+}
+
+func TestGeneratePost_KeepMarkersInCode(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Output:   config.Output{PostsDir: tmpDir},
+		Template: config.Template{Frontmatter: "---\ntitle: \"{{ .Title }}\"\n---"},
+	}
+	gen := NewGenerator(cfg)
+	data := PostData{
+		Title: "Markers",
+		Slug:  "markers",
+		Content: "Wrap it in `<!-- leaflet-sync:keep -->` and `<!-- leaflet-sync:end -->`:\n\n" +
+			"```html\n<!-- leaflet-sync:keep -->\n```\n",
+	}
+	if err := gen.GeneratePost(data); err != nil {
+		t.Fatalf("GeneratePost failed: %v", err)
+	}
+	path := filepath.Join(tmpDir, "markers.md")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := string(content) + "\n<!-- leaflet-sync:keep -->Note<!-- leaflet-sync:end -->\n"
+	if err := os.WriteFile(path, []byte(local), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gen.GeneratePost(data); err != nil {
+		t.Fatalf("GeneratePost failed: %v", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != local {
+		t.Errorf("expected %q, got %q", local, string(content))
+	}
+}
+
+func TestGeneratePostFrom_Renamed(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Output:   config.Output{PostsDir: tmpDir},
+		Template: config.Template{Frontmatter: "---\ntitle: \"{{ .Title }}\"\n---"},
+	}
+	gen := NewGenerator(cfg)
+	previous := filepath.Join(tmpDir, "old-title.md")
+	local := "---\ntitle: \"Old title\"\n---\nBody.\n\n<!-- leaflet-sync:keep -->Update<!-- leaflet-sync:end -->\n"
+	if err := os.WriteFile(previous, []byte(local), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gen.GeneratePostFrom(PostData{Title: "New title", Filename: "new-title", Content: "Body."}, previous); err != nil {
+		t.Fatalf("GeneratePostFrom failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tmpDir, "new-title.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "---\ntitle: \"New title\"\n---\nBody.\n\n<!-- leaflet-sync:keep -->Update<!-- leaflet-sync:end -->\n"
+	if string(content) != expected {
+		t.Errorf("expected %q, got %q", expected, string(content))
+	}
+}
+
+func TestGeneratePost_Bundle(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Output: config.Output{
+			PostsDir: tmpDir,
+			Layout:   config.LayoutBundle,
+		},
+		Template: config.Template{
+			Frontmatter: "---\ntitle: \"{{ .Title }}\"\n---",
+		},
+	}
+
+	gen := NewGenerator(cfg)
+	data := PostData{Title: "Hello World", Filename: "hello-world", Content: "![cat](bafycat.png)"}
+
+	dir, err := gen.BundleDir(data)
+	if err != nil {
+		t.Fatalf("BundleDir failed: %v", err)
+	}
+	if expected := filepath.Join(tmpDir, "hello-world"); dir != expected {
+		t.Errorf("expected bundle dir %s, got %s", expected, dir)
+	}
+	if err := gen.GeneratePost(data); err != nil {
+		t.Fatalf("GeneratePost failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.md")); err != nil {
+		t.Errorf("expected index.md in the bundle: %v", err)
+	}
+
+	if err := gen.RemovePost(gen.PostPath(data)); err != nil {
+		t.Fatalf("RemovePost failed: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected the bundle to be removed, got %v", err)
+	}
+	if err := gen.RemovePost(filepath.Join(tmpDir, "..", "other", "index.md")); err == nil {
+		t.Error("expected error for a path outside posts_dir, got nil")
+	}
+}
+
+func TestRemovePost(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Output:   config.Output{PostsDir: tmpDir},
+		Template: config.Template{Frontmatter: "---\n---"},
+	}
+	gen := NewGenerator(cfg)
+	data := PostData{Title: "Hello World", Filename: "hello-world"}
+	if err := gen.GeneratePost(data); err != nil {
+		t.Fatalf("GeneratePost failed: %v", err)
+	}
+	path := gen.PostPath(data)
+
+	for i := 0; i < 2; i++ {
+		if err := gen.RemovePost(path); err != nil {
+			t.Fatalf("RemovePost failed: %v", err)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", path, err)
+	}
+	if _, err := os.Stat(tmpDir); err != nil {
+		t.Errorf("expected posts_dir to be kept: %v", err)
+	}
+}
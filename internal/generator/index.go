@@ -0,0 +1,93 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// PostIndex maps record URIs to the post files generated for them, so the
+// post of a deleted record can be found again.
+type PostIndex struct {
+	path  string
+	mu    sync.Mutex
+	posts map[string]string
+}
+
+// LoadPostIndex loads the index at path. A missing file is an empty index.
+// Changes are written back to path.
+func LoadPostIndex(path string) (*PostIndex, error) {
+	idx := &PostIndex{path: path, posts: make(map[string]string)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &idx.posts); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if idx.posts == nil {
+		idx.posts = make(map[string]string)
+	}
+	return idx, nil
+}
+
+// Lookup returns the post file of the record at uri.
+func (i *PostIndex) Lookup(uri string) (string, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	file, ok := i.posts[uri]
+	return file, ok
+}
+
+// Set records file as the post of the record at uri.
+func (i *PostIndex) Set(uri, file string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.posts[uri] == file {
+		return nil
+	}
+	i.posts[uri] = file
+	return i.save()
+}
+
+// Remove forgets the post of the record at uri.
+func (i *PostIndex) Remove(uri string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.posts[uri]; !ok {
+		return nil
+	}
+	delete(i.posts, uri)
+	return i.save()
+}
+
+// save writes the index atomically. The caller must hold mu.
+func (i *PostIndex) save() error {
+	data, err := json.MarshalIndent(i.posts, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(i.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(i.path), ".posts-*.json")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), i.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
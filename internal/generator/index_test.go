@@ -0,0 +1,43 @@
+package generator
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPostIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "posts.json")
+	idx, err := LoadPostIndex(path)
+	if err != nil {
+		t.Fatalf("LoadPostIndex failed: %v", err)
+	}
+	uri := "at://did:plc:abc123/pub.leaflet.document/3aaa"
+	if err := idx.Set(uri, "content/posts/hello.md"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := idx.Set("at://did:plc:abc123/pub.leaflet.document/3bbb", "content/posts/other.md"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	idx, err = LoadPostIndex(path)
+	if err != nil {
+		t.Fatalf("LoadPostIndex failed: %v", err)
+	}
+	if file, ok := idx.Lookup(uri); !ok || file != "content/posts/hello.md" {
+		t.Errorf("expected content/posts/hello.md, got %q", file)
+	}
+	if err := idx.Remove(uri); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	idx, err = LoadPostIndex(path)
+	if err != nil {
+		t.Fatalf("LoadPostIndex failed: %v", err)
+	}
+	if _, ok := idx.Lookup(uri); ok {
+		t.Error("expected the removed post to be gone")
+	}
+	if _, ok := idx.Lookup("at://did:plc:abc123/pub.leaflet.document/3bbb"); !ok {
+		t.Error("expected the other post to be kept")
+	}
+}
@@ -0,0 +1,277 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Protected regions let users keep local additions (an "Update:" section,
+// a Hugo shortcode, ...) in a synced post. Everything between a keep marker
+// and the matching end marker survives regeneration:
+//
+//	<!-- leaflet-sync:keep -->
+//	...local content...
+//	<!-- leaflet-sync:end -->
+//
+// A region may be named (<!-- leaflet-sync:keep updates -->). If the
+// generated post contains a region with the same name, the kept content
+// replaces it, so local edits win over a template's default content.
+// Unnamed regions are matched by position: the first unnamed region of the
+// file replaces the first unnamed region of the generated post, and so on.
+// Kept regions without a counterpart are appended to the end.
+//
+// The content the template last generated for each region is recorded, so
+// a region that was edited locally and changed in the template since is
+// reported as a conflict instead of either side being dropped. A region
+// that wasn't edited locally takes the template's new content. Markers in
+// code blocks and code spans are text, not regions.
+const (
+	keepStartPrefix = "<!-- leaflet-sync:keep"
+	keepEnd         = "<!-- leaflet-sync:end -->"
+	markerSuffix    = "-->"
+)
+
+// ErrKeepConflict is returned when protected regions cannot be merged
+// safely. The existing file is left untouched in that case.
+var ErrKeepConflict = errors.New("protected region conflict")
+
+type keepRegion struct {
+	Name string
+	Body string
+	// start and end are the byte offsets of the whole region, markers
+	// included, in the content it was extracted from.
+	start, end int
+}
+
+// extractKeepRegions returns all protected regions found in content, in order.
+func extractKeepRegions(content string) ([]keepRegion, error) {
+	code := codeSpans(content)
+	var regions []keepRegion
+	seen := make(map[string]bool)
+	open := -1 // start of the region being read
+	var name string
+	var bodyStart int
+
+	for pos := 0; ; {
+		i, isStart := nextMarker(content, pos, code)
+		switch {
+		case i == -1:
+			if open != -1 {
+				return nil, fmt.Errorf("%w: keep region %q has no end marker", ErrKeepConflict, name)
+			}
+			return regions, nil
+		case isStart && open != -1:
+			return nil, fmt.Errorf("%w: nested keep region in %q", ErrKeepConflict, name)
+		case isStart:
+			nameStart := i + len(keepStartPrefix)
+			closeIdx := strings.Index(content[nameStart:], markerSuffix)
+			if closeIdx == -1 {
+				return nil, fmt.Errorf("%w: unterminated keep marker", ErrKeepConflict)
+			}
+			name = strings.TrimSpace(content[nameStart : nameStart+closeIdx])
+			if name != "" {
+				if seen[name] {
+					return nil, fmt.Errorf("%w: duplicate keep region %q", ErrKeepConflict, name)
+				}
+				seen[name] = true
+			}
+			open, bodyStart = i, nameStart+closeIdx+len(markerSuffix)
+			pos = bodyStart
+		case open == -1:
+			return nil, fmt.Errorf("%w: end marker without keep marker", ErrKeepConflict)
+		default:
+			pos = i + len(keepEnd)
+			regions = append(regions, keepRegion{Name: name, Body: content[bodyStart:i], start: open, end: pos})
+			open = -1
+		}
+	}
+}
+
+// nextMarker returns the offset of the first keep or end marker at or after
+// pos that isn't inside code, and whether it is a keep marker. It returns -1
+// if there is none.
+func nextMarker(content string, pos int, code [][2]int) (int, bool) {
+	for {
+		i := strings.Index(content[pos:], keepStartPrefix)
+		isStart := true
+		if e := strings.Index(content[pos:], keepEnd); e != -1 && (i == -1 || e < i) {
+			i, isStart = e, false
+		}
+		if i == -1 {
+			return -1, false
+		}
+		i += pos
+		if !inSpans(code, i) {
+			return i, isStart
+		}
+		pos = i + 1
+	}
+}
+
+// codeSpans returns the byte ranges of fenced code blocks and inline code
+// spans in Markdown content.
+func codeSpans(content string) [][2]int {
+	var spans [][2]int
+	var fence string // the open fence, e.g. "```"
+	var fenceStart int
+	for pos := 0; pos < len(content); {
+		lineEnd := len(content)
+		if i := strings.IndexByte(content[pos:], '\n'); i != -1 {
+			lineEnd = pos + i + 1
+		}
+		line := content[pos:lineEnd]
+		trimmed := strings.TrimLeft(line, " ")
+		indented := len(line)-len(trimmed) > 3
+
+		switch {
+		case fence != "":
+			if !indented && strings.HasPrefix(trimmed, fence) && strings.TrimSpace(strings.TrimLeft(trimmed, fence[:1])) == "" {
+				spans = append(spans, [2]int{fenceStart, lineEnd})
+				fence = ""
+			}
+		case !indented && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")):
+			fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+			fenceStart = pos
+		default:
+			spans = append(spans, inlineCodeSpans(line, pos)...)
+		}
+		pos = lineEnd
+	}
+	if fence != "" {
+		spans = append(spans, [2]int{fenceStart, len(content)})
+	}
+	return spans
+}
+
+// inlineCodeSpans returns the code spans in line, which starts at offset.
+// A span opens with a run of backticks and closes with a run of the same
+// length.
+func inlineCodeSpans(line string, offset int) [][2]int {
+	run := func(i int) int {
+		n := 0
+		for i+n < len(line) && line[i+n] == '`' {
+			n++
+		}
+		return n
+	}
+	var spans [][2]int
+	for i := 0; i < len(line); {
+		if line[i] != '`' {
+			i++
+			continue
+		}
+		n := run(i)
+		closing := -1
+		for j := i + n; j < len(line); {
+			if line[j] != '`' {
+				j++
+				continue
+			}
+			m := run(j)
+			if m == n {
+				closing = j
+				break
+			}
+			j += m
+		}
+		if closing == -1 {
+			i += n
+			continue
+		}
+		spans = append(spans, [2]int{offset + i, offset + closing + n})
+		i = closing + n
+	}
+	return spans
+}
+
+func inSpans(spans [][2]int, pos int) bool {
+	for _, s := range spans {
+		if pos >= s[0] && pos < s[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// keepKeys identifies regions across versions of a post: by name, or as
+// "#<n>" for the nth unnamed region.
+func keepKeys(regions []keepRegion) []string {
+	keys := make([]string, len(regions))
+	unnamed := 0
+	for i, r := range regions {
+		if r.Name != "" {
+			keys[i] = r.Name
+			continue
+		}
+		keys[i] = fmt.Sprintf("#%d", unnamed)
+		unnamed++
+	}
+	return keys
+}
+
+// mergeKeepRegions re-inserts kept regions into freshly generated content,
+// whose own regions are slots. base holds what the template last generated
+// for each region, by keepKeys; without it, local content always wins.
+// Merging the regions of its own output again gives the same result, so
+// repeated syncs don't change a post.
+func mergeKeepRegions(generated string, slots, kept []keepRegion, base map[string]string) (string, error) {
+	if len(kept) == 0 {
+		return generated, nil
+	}
+
+	slotByKey := make(map[string]keepRegion)
+	for i, key := range keepKeys(slots) {
+		slotByKey[key] = slots[i]
+	}
+
+	fills := make(map[int]keepRegion) // slot start offset -> kept region
+	var appended []string
+	for i, key := range keepKeys(kept) {
+		region := kept[i]
+		slot, hasSlot := slotByKey[key]
+		if !hasSlot {
+			appended = append(appended, renderKeepRegion(region))
+			continue
+		}
+		previous, hasBase := base[key]
+		switch {
+		case !hasBase || slot.Body == previous || slot.Body == region.Body:
+			fills[slot.start] = region
+		case region.Body == previous:
+			// Not edited locally; the template's new content applies.
+		default:
+			return "", fmt.Errorf("%w: region %q was edited locally and changed in the template", ErrKeepConflict, key)
+		}
+	}
+
+	if len(fills) > 0 {
+		var sb strings.Builder
+		last := 0
+		for _, slot := range slots {
+			region, ok := fills[slot.start]
+			if !ok {
+				continue
+			}
+			sb.WriteString(generated[last:slot.start])
+			sb.WriteString(renderKeepRegion(region))
+			last = slot.end
+		}
+		sb.WriteString(generated[last:])
+		generated = sb.String()
+	}
+
+	if len(appended) > 0 {
+		generated = strings.TrimRight(generated, "\n") + "\n\n" + strings.Join(appended, "\n\n") + "\n"
+	}
+
+	return generated, nil
+}
+
+func renderKeepRegion(r keepRegion) string {
+	start := keepStartPrefix + " " + markerSuffix
+	if r.Name != "" {
+		start = keepStartPrefix + " " + r.Name + " " + markerSuffix
+	}
+	return start + r.Body + keepEnd
+}
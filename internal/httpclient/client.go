@@ -0,0 +1,46 @@
+// Package httpclient provides the HTTP client shared by all network access:
+// a configurable User-Agent, proxy and CA certificates, retries with backoff
+// for idempotent requests, rate-limit awareness and per-request timeouts.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// DefaultUserAgent identifies the tool to the servers it talks to.
+const DefaultUserAgent = "leaflet-hugo-sync (+https://github.com/mariuskimmina/leaflet-hugo-sync)"
+
+// New returns an HTTP client configured by opts.
+func New(opts Options) (*http.Client, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts.ProxyURL != "" {
+		proxy, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		base.Proxy = http.ProxyURL(proxy)
+	}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+		base.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{Transport: NewTransport(base, opts)}, nil
+}
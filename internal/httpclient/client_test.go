@@ -0,0 +1,70 @@
+package httpclient
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNew_UserAgentAndCAFile(t *testing.T) {
+	var userAgent string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+	}))
+	defer srv.Close()
+
+	// Without the test CA the server's certificate is rejected.
+	client, err := New(Options{MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatal("expected certificate error, got nil")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	client, err = New(Options{CAFile: caFile, UserAgent: "test-agent/1.0"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if userAgent != "test-agent/1.0" {
+		t.Errorf("expected User-Agent test-agent/1.0, got %q", userAgent)
+	}
+}
+
+func TestNew_Proxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	client, err := New(Options{ProxyURL: proxy.URL})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	resp, err := client.Get("http://pds.example.com/xrpc/_health")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if proxied != "http://pds.example.com/xrpc/_health" {
+		t.Errorf("expected request through the proxy, got %q", proxied)
+	}
+
+	if _, err := New(Options{CAFile: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("expected error for missing CA file, got nil")
+	}
+}
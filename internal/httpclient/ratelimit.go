@@ -0,0 +1,32 @@
+package httpclient
+
+import (
+	"sync"
+	"time"
+)
+
+// hostLimiter spaces out requests to each host by a fixed interval, so
+// concurrent callers share one budget per server.
+type hostLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func newHostLimiter(interval time.Duration) *hostLimiter {
+	return &hostLimiter{interval: interval, next: make(map[string]time.Time)}
+}
+
+// reserve claims the next free slot for host and returns how long to wait
+// until it.
+func (l *hostLimiter) reserve(host string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	at := l.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[host] = at.Add(l.interval)
+	return at.Sub(now)
+}
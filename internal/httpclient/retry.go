@@ -0,0 +1,239 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for Options fields left zero.
+const (
+	DefaultMaxAttempts    = 4
+	DefaultInitialBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff     = 30 * time.Second
+	DefaultRequestTimeout = 60 * time.Second
+)
+
+// Options configure the client.
+type Options struct {
+	// MaxAttempts is the number of tries per request, including the first.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// RequestTimeout bounds how long a single attempt waits for the
+	// response headers, and then for each chunk of the body. A slow
+	// download that keeps making progress is not cut off.
+	RequestTimeout time.Duration
+	// RateLimit caps requests per second to each host, retries included;
+	// 0 means no limit.
+	RateLimit float64
+
+	// UserAgent is sent with every request; DefaultUserAgent if empty.
+	UserAgent string
+	// ProxyURL overrides the HTTP(S)_PROXY environment variables.
+	ProxyURL string
+	// CAFile is a PEM bundle trusted in addition to the system roots.
+	CAFile string
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultMaxAttempts
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = DefaultInitialBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = DefaultMaxBackoff
+	}
+	if o.RequestTimeout <= 0 {
+		o.RequestTimeout = DefaultRequestTimeout
+	}
+	if o.UserAgent == "" {
+		o.UserAgent = DefaultUserAgent
+	}
+	return o
+}
+
+// Transport is an http.RoundTripper that retries GET and HEAD requests on
+// network errors, 429 and 5xx responses. Waits honour Retry-After and
+// RateLimit-Reset headers and otherwise back off exponentially with jitter.
+type Transport struct {
+	Base http.RoundTripper
+	Opts Options
+
+	// sleep waits for d or until ctx is done; replaced in tests.
+	sleep   func(ctx context.Context, d time.Duration) error
+	now     func() time.Time
+	limiter *hostLimiter
+}
+
+func NewTransport(base http.RoundTripper, opts Options) *Transport {
+	t := &Transport{Base: base, Opts: opts.withDefaults(), sleep: sleep, now: time.Now}
+	if t.Opts.RateLimit > 0 {
+		t.limiter = newHostLimiter(time.Duration(float64(time.Second) / t.Opts.RateLimit))
+	}
+	return t
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.Opts.UserAgent)
+
+	idempotent := (req.Method == http.MethodGet || req.Method == http.MethodHead) && req.Body == nil
+	attempts := t.Opts.MaxAttempts
+	if !idempotent {
+		attempts = 1
+	}
+
+	backoff := t.Opts.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		if t.limiter != nil {
+			if wait := t.limiter.reserve(req.URL.Host, t.now()); wait > 0 {
+				if err := t.sleep(req.Context(), wait); err != nil {
+					return nil, err
+				}
+			}
+		}
+		resp, err := t.attempt(req)
+		if attempt == attempts || !retryable(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		wait := t.retryAfter(resp)
+		if wait == 0 {
+			wait = jitter(backoff)
+			backoff = min(backoff*2, t.Opts.MaxBackoff)
+		}
+		if deadline, ok := req.Context().Deadline(); ok && t.now().Add(wait).After(deadline) {
+			// Waiting would outlive the caller; report this attempt instead.
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+		}
+		if err := t.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// attempt performs a single try, canceled when no response or no body data
+// arrives for RequestTimeout. The timeout stays in effect until the
+// response body is closed.
+func (t *Transport) attempt(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	stall := &stallTimer{timeout: t.Opts.RequestTimeout}
+	stall.timer = time.AfterFunc(stall.timeout, func() {
+		stall.expired.Store(true)
+		cancel()
+	})
+	resp, err := t.Base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		stall.timer.Stop()
+		cancel()
+		return nil, stall.wrap(err)
+	}
+	stall.timer.Reset(stall.timeout)
+	resp.Body = &progressBody{ReadCloser: resp.Body, stall: stall, cancel: cancel}
+	return resp, nil
+}
+
+// stallTimer cancels an attempt that makes no progress for timeout.
+type stallTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+// wrap explains errors caused by the timer.
+func (s *stallTimer) wrap(err error) error {
+	if err == nil || err == io.EOF || !s.expired.Load() {
+		return err
+	}
+	return fmt.Errorf("no progress for %s: %w", s.timeout, context.DeadlineExceeded)
+}
+
+// retryAfter returns how long the server asked us to wait, or 0.
+func (t *Transport) retryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second
+		}
+		if at, err := http.ParseTime(v); err == nil {
+			return max(at.Sub(t.now()), 0)
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		// atproto servers send the reset time as a Unix timestamp.
+		if v := resp.Header.Get("RateLimit-Reset"); v != "" {
+			if reset, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return max(time.Unix(reset, 0).Sub(t.now()), 0)
+			}
+		}
+	}
+	return 0
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// jitter returns a random duration in [d/2, d).
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + rand.N(half)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// progressBody restarts the stall timer whenever data arrives.
+type progressBody struct {
+	io.ReadCloser
+	stall  *stallTimer
+	cancel context.CancelFunc
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.stall.timer.Reset(b.stall.timeout)
+	}
+	return n, b.stall.wrap(err)
+}
+
+func (b *progressBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.stall.timer.Stop()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,246 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestTransport returns a transport that records waits instead of
+// sleeping.
+func newTestTransport(opts Options, waits *[]time.Duration) *Transport {
+	t := NewTransport(http.DefaultTransport, opts)
+	now := time.Unix(1700000000, 0)
+	t.now = func() time.Time { return now }
+	t.sleep = func(ctx context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return nil
+	}
+	return t
+}
+
+func TestTransport_RetriesTransientErrors(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestTransport(Options{InitialBackoff: time.Second}, &waits)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+	if len(waits) != 2 {
+		t.Fatalf("expected 2 retries, got %d", len(waits))
+	}
+	if waits[0] < 500*time.Millisecond || waits[0] >= time.Second {
+		t.Errorf("expected first wait in [500ms, 1s), got %v", waits[0])
+	}
+	if waits[1] < time.Second || waits[1] >= 2*time.Second {
+		t.Errorf("expected second wait in [1s, 2s), got %v", waits[1])
+	}
+}
+
+func TestTransport_RateLimitHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		expected time.Duration
+	}{
+		{"retry-after seconds", "Retry-After", "7", 7 * time.Second},
+		{"retry-after date", "Retry-After", time.Unix(1700000005, 0).UTC().Format(http.TimeFormat), 5 * time.Second},
+		{"ratelimit-reset", "RateLimit-Reset", strconv.Itoa(1700000012), 12 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) == 1 {
+					w.Header().Set(tt.header, tt.value)
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.Write([]byte("ok"))
+			}))
+			defer srv.Close()
+
+			var waits []time.Duration
+			client := &http.Client{Transport: newTestTransport(Options{}, &waits)}
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if len(waits) != 1 || waits[0] != tt.expected {
+				t.Errorf("expected a single wait of %v, got %v", tt.expected, waits)
+			}
+		})
+	}
+}
+
+func TestTransport_GivesUp(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestTransport(Options{MaxAttempts: 3}, &waits)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || requests.Load() != 3 {
+		t.Errorf("expected 3 attempts ending in 503, got %d attempts and %d", requests.Load(), resp.StatusCode)
+	}
+
+	// Non-idempotent requests are never retried.
+	requests.Store(0)
+	resp, err = client.Post(srv.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	resp.Body.Close()
+	if requests.Load() != 1 {
+		t.Errorf("expected 1 attempt for POST, got %d", requests.Load())
+	}
+}
+
+func TestTransport_RequestTimeout(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestTransport(Options{RequestTimeout: 50 * time.Millisecond}, &waits)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if requests.Load() != 2 {
+		t.Errorf("expected the hung attempt to time out and be retried, got %d attempts", requests.Load())
+	}
+}
+
+func TestTransport_SlowBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Takes four times the request timeout, but never stalls for long.
+		for range 10 {
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestTransport(Options{RequestTimeout: 50 * time.Millisecond}, &waits)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading the body failed: %v", err)
+	}
+	if expected := strings.Repeat("chunk", 10); string(body) != expected {
+		t.Errorf("expected %q, got %q", expected, string(body))
+	}
+}
+
+func TestTransport_StalledBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("chunk"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestTransport(Options{RequestTimeout: 50 * time.Millisecond}, &waits)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the stalled body to time out, got %v", err)
+	}
+}
+
+func TestTransport_RateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestTransport(Options{RateLimit: 4}, &waits)}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	// The clock is frozen, so each request queues behind the previous one.
+	expected := []time.Duration{250 * time.Millisecond, 500 * time.Millisecond}
+	if len(waits) != len(expected) {
+		t.Fatalf("expected waits %v, got %v", expected, waits)
+	}
+	for i := range expected {
+		if waits[i] != expected[i] {
+			t.Errorf("expected wait %v, got %v", expected[i], waits[i])
+		}
+	}
+}
+
+func TestHostLimiter_PerHost(t *testing.T) {
+	l := newHostLimiter(time.Second)
+	now := time.Unix(1700000000, 0)
+	if wait := l.reserve("a.example", now); wait != 0 {
+		t.Errorf("expected no wait, got %v", wait)
+	}
+	if wait := l.reserve("b.example", now); wait != 0 {
+		t.Errorf("expected other hosts not to wait, got %v", wait)
+	}
+	if wait := l.reserve("a.example", now.Add(300*time.Millisecond)); wait != 700*time.Millisecond {
+		t.Errorf("expected 700ms, got %v", wait)
+	}
+	if wait := l.reserve("a.example", now.Add(5*time.Second)); wait != 0 {
+		t.Errorf("expected an idle host not to wait, got %v", wait)
+	}
+}
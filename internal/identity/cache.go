@@ -0,0 +1,169 @@
+package identity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cache stores resolved handles and DID documents. Expired entries are still
+// returned, flagged as stale, so resolution can fall back to them when the
+// network is slow or down.
+type Cache interface {
+	GetHandle(handle string) (did string, stale bool, ok bool)
+	PutHandle(handle, did string)
+	GetDocument(did string) (doc *DIDDocument, stale bool, ok bool)
+	PutDocument(did string, doc *DIDDocument)
+}
+
+// MemoryCache is an in-process Cache whose entries expire after a TTL.
+type MemoryCache struct {
+	ttl  time.Duration
+	mu   sync.Mutex
+	data cacheData
+	now  func() time.Time
+}
+
+// cacheData is the content of a cache, as stored on disk by FileCache.
+type cacheData struct {
+	Handles   map[string]handleEntry   `json:"handles"`
+	Documents map[string]documentEntry `json:"documents"`
+}
+
+type handleEntry struct {
+	DID     string    `json:"did"`
+	Fetched time.Time `json:"fetched"`
+}
+
+type documentEntry struct {
+	Doc     *DIDDocument `json:"doc"`
+	Fetched time.Time    `json:"fetched"`
+}
+
+func NewMemoryCache(ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		ttl: ttl,
+		data: cacheData{
+			Handles:   make(map[string]handleEntry),
+			Documents: make(map[string]documentEntry),
+		},
+		now: time.Now,
+	}
+}
+
+func (c *MemoryCache) GetHandle(handle string) (string, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.data.Handles[handle]
+	return e.DID, c.expired(e.Fetched), ok
+}
+
+func (c *MemoryCache) PutHandle(handle, did string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.data.Handles[handle] = handleEntry{DID: did, Fetched: c.now()}
+}
+
+func (c *MemoryCache) GetDocument(did string) (*DIDDocument, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.data.Documents[did]
+	return e.Doc, c.expired(e.Fetched), ok
+}
+
+func (c *MemoryCache) PutDocument(did string, doc *DIDDocument) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.data.Documents[did] = documentEntry{Doc: doc, Fetched: c.now()}
+}
+
+func (c *MemoryCache) expired(fetched time.Time) bool {
+	return c.now().After(fetched.Add(c.ttl))
+}
+
+// FileCache is a MemoryCache persisted to a JSON file, so resolutions
+// survive between runs.
+type FileCache struct {
+	*MemoryCache
+	path string
+}
+
+// DefaultCacheFile returns the default location of the identity cache in the
+// user's cache directory.
+func DefaultCacheFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "leaflet-hugo-sync", "identity.json"), nil
+}
+
+// NewFileCache loads the cache at path. A missing file is an empty cache.
+func NewFileCache(path string, ttl time.Duration) (*FileCache, error) {
+	c := &FileCache{MemoryCache: NewMemoryCache(ttl), path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if c.data.Handles == nil {
+		c.data.Handles = make(map[string]handleEntry)
+	}
+	if c.data.Documents == nil {
+		c.data.Documents = make(map[string]documentEntry)
+	}
+	return c, nil
+}
+
+func (c *FileCache) PutHandle(handle, did string) {
+	c.MemoryCache.PutHandle(handle, did)
+	c.save()
+}
+
+func (c *FileCache) PutDocument(did string, doc *DIDDocument) {
+	c.MemoryCache.PutDocument(did, doc)
+	c.save()
+}
+
+// save writes the cache to disk. The cache is only an optimisation, so
+// failures are ignored; the next run simply resolves again.
+func (c *FileCache) save() {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c.data, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".identity-*.json")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,90 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileCache_Persists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "identity.json")
+
+	cache, err := NewFileCache(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileCache failed: %v", err)
+	}
+	doc := testDocument("did:plc:abc123")
+	cache.PutHandle("alice.example.com", "did:plc:abc123")
+	cache.PutDocument("did:plc:abc123", &doc)
+
+	reloaded, err := NewFileCache(path, time.Hour)
+	if err != nil {
+		t.Fatalf("reloading cache failed: %v", err)
+	}
+	if did, stale, ok := reloaded.GetHandle("alice.example.com"); !ok || stale || did != "did:plc:abc123" {
+		t.Errorf("expected fresh did:plc:abc123, got %q (stale %v, ok %v)", did, stale, ok)
+	}
+	got, stale, ok := reloaded.GetDocument("did:plc:abc123")
+	if !ok || stale || got.ID != "did:plc:abc123" {
+		t.Errorf("expected fresh document, got %+v (stale %v, ok %v)", got, stale, ok)
+	}
+
+	reloaded.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, stale, ok := reloaded.GetDocument("did:plc:abc123"); !ok || !stale {
+		t.Errorf("expected stale document after TTL, got stale %v, ok %v", stale, ok)
+	}
+}
+
+func TestResolver_StaleFallbackAndMigration(t *testing.T) {
+	const did = "did:plc:abc123"
+	pds := "https://old-pds.example.com"
+	up := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		doc := testDocument(did)
+		doc.Service[0].ServiceEndpoint = pds
+		json.NewEncoder(w).Encode(doc)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	cache := NewMemoryCache(time.Hour)
+	cache.now = func() time.Time { return now }
+	var logs []string
+	resolver := NewResolver(srv.URL, "", nil)
+	resolver.Cache = cache
+	resolver.Logf = func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+
+	if host, err := resolver.ResolvePDS(context.Background(), did); err != nil || host != pds {
+		t.Fatalf("expected %s, got %q (%v)", pds, host, err)
+	}
+
+	// The directory is down once the entry expired: use the stale copy.
+	now = now.Add(2 * time.Hour)
+	up = false
+	if host, err := resolver.ResolvePDS(context.Background(), did); err != nil || host != pds {
+		t.Errorf("expected stale %s, got %q (%v)", pds, host, err)
+	}
+
+	// The account moved: the new host is used and the move is logged.
+	up = true
+	pds = "https://new-pds.example.com"
+	if host, err := resolver.ResolvePDS(context.Background(), did); err != nil || host != pds {
+		t.Errorf("expected %s after migration, got %q (%v)", pds, host, err)
+	}
+
+	if len(logs) != 2 || !strings.Contains(logs[1], "Account migration") {
+		t.Errorf("expected a stale warning and a migration message, got %q", logs)
+	}
+}
@@ -0,0 +1,64 @@
+// Package identity resolves atproto identities: DIDs to DID documents.
+package identity
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+)
+
+// DIDDocument is the subset of a DID document used by atproto.
+type DIDDocument struct {
+	ID                 string               `json:"id"`
+	AlsoKnownAs        []string             `json:"alsoKnownAs"`
+	VerificationMethod []VerificationMethod `json:"verificationMethod"`
+	Service            []Service            `json:"service"`
+}
+
+type VerificationMethod struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Controller         string `json:"controller"`
+	PublicKeyMultibase string `json:"publicKeyMultibase"`
+}
+
+type Service struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	ServiceEndpoint string `json:"serviceEndpoint"`
+}
+
+// PDSEndpoint returns the URL of the account's personal data server.
+func (d *DIDDocument) PDSEndpoint() (string, error) {
+	for _, svc := range d.Service {
+		if svc.ID == "#atproto_pds" || svc.ID == d.ID+"#atproto_pds" || svc.Type == "AtprotoPersonalDataServer" {
+			return svc.ServiceEndpoint, nil
+		}
+	}
+	return "", fmt.Errorf("no PDS service found for DID %s", d.ID)
+}
+
+// SigningKey returns the key the account signs its repo commits with.
+func (d *DIDDocument) SigningKey() (atcrypto.PublicKey, error) {
+	for _, vm := range d.VerificationMethod {
+		if vm.ID == "#atproto" || vm.ID == d.ID+"#atproto" {
+			if vm.Type != "Multikey" {
+				return nil, fmt.Errorf("unsupported verification method type %q", vm.Type)
+			}
+			return atcrypto.ParsePublicMultibase(vm.PublicKeyMultibase)
+		}
+	}
+	return nil, fmt.Errorf("no atproto signing key found for DID %s", d.ID)
+}
+
+// Handles returns the handles claimed in alsoKnownAs, without the at:// prefix.
+func (d *DIDDocument) Handles() []string {
+	var handles []string
+	for _, aka := range d.AlsoKnownAs {
+		if h, ok := strings.CutPrefix(aka, "at://"); ok {
+			handles = append(handles, h)
+		}
+	}
+	return handles
+}
@@ -0,0 +1,157 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxWellKnownSize bounds the size of an /.well-known/atproto-did response.
+const maxWellKnownSize = 1 << 10
+
+// HandleResolver resolves a handle to a DID.
+type HandleResolver interface {
+	ResolveHandle(ctx context.Context, handle string) (string, error)
+}
+
+// DNSHandleResolver resolves handles from the _atproto.<handle> TXT record.
+type DNSHandleResolver struct {
+	// LookupTXT defaults to net.DefaultResolver.LookupTXT.
+	LookupTXT func(ctx context.Context, name string) ([]string, error)
+}
+
+func (r *DNSHandleResolver) ResolveHandle(ctx context.Context, handle string) (string, error) {
+	lookup := r.LookupTXT
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupTXT
+	}
+
+	records, err := lookup(ctx, "_atproto."+handle)
+	if err != nil {
+		return "", fmt.Errorf("DNS lookup for %s: %w", handle, err)
+	}
+
+	var found string
+	for _, rec := range records {
+		did, ok := strings.CutPrefix(strings.TrimSpace(rec), "did=")
+		if !ok {
+			continue
+		}
+		if found != "" && found != did {
+			return "", fmt.Errorf("DNS lookup for %s: multiple DIDs in TXT records", handle)
+		}
+		found = did
+	}
+	if found == "" {
+		return "", fmt.Errorf("DNS lookup for %s: no did= TXT record", handle)
+	}
+	return found, nil
+}
+
+// WellKnownHandleResolver resolves handles from
+// https://<handle>/.well-known/atproto-did.
+type WellKnownHandleResolver struct {
+	HTTPClient *http.Client
+}
+
+func (r *WellKnownHandleResolver) ResolveHandle(ctx context.Context, handle string) (string, error) {
+	client := r.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://"+handle+"/.well-known/atproto-did", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("HTTPS lookup for %s: %w", handle, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTPS lookup for %s: %s", handle, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxWellKnownSize))
+	if err != nil {
+		return "", fmt.Errorf("HTTPS lookup for %s: %w", handle, err)
+	}
+	did := strings.TrimSpace(string(body))
+	if !strings.HasPrefix(did, "did:") {
+		return "", fmt.Errorf("HTTPS lookup for %s: response is not a DID", handle)
+	}
+	return did, nil
+}
+
+// XRPCHandleResolver resolves handles through a server's
+// com.atproto.identity.resolveHandle endpoint, e.g. https://bsky.social.
+type XRPCHandleResolver struct {
+	Host       string
+	HTTPClient *http.Client
+}
+
+func (r *XRPCHandleResolver) ResolveHandle(ctx context.Context, handle string) (string, error) {
+	client := r.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	u := strings.TrimRight(r.Host, "/") + "/xrpc/com.atproto.identity.resolveHandle?handle=" + url.QueryEscape(handle)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("resolveHandle via %s: %w", r.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("resolveHandle via %s: %s", r.Host, resp.Status)
+	}
+	var out struct {
+		Did string `json:"did"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxWellKnownSize)).Decode(&out); err != nil {
+		return "", fmt.Errorf("resolveHandle via %s: %w", r.Host, err)
+	}
+	if !strings.HasPrefix(out.Did, "did:") {
+		return "", fmt.Errorf("resolveHandle via %s: response is not a DID", r.Host)
+	}
+	return out.Did, nil
+}
+
+// HandleResolverChain tries each resolver in order and returns the first
+// DID found.
+type HandleResolverChain []HandleResolver
+
+func (c HandleResolverChain) ResolveHandle(ctx context.Context, handle string) (string, error) {
+	var errs []error
+	for _, r := range c {
+		did, err := r.ResolveHandle(ctx, handle)
+		if err == nil {
+			return did, nil
+		}
+		errs = append(errs, err)
+	}
+	return "", fmt.Errorf("resolving handle %s: %w", handle, errors.Join(errs...))
+}
+
+// NormalizeHandle lowercases a handle and strips a leading "@" or "at://".
+func NormalizeHandle(handle string) (string, error) {
+	h := strings.ToLower(strings.TrimSpace(handle))
+	h = strings.TrimPrefix(h, "at://")
+	h = strings.TrimPrefix(h, "@")
+	if h == "" || !strings.Contains(h, ".") || strings.ContainsAny(h, "/:?#@ ") {
+		return "", fmt.Errorf("invalid handle %q", handle)
+	}
+	return h, nil
+}
@@ -0,0 +1,129 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// fakeTXT returns a LookupTXT func answering from a fixed set of records.
+func fakeTXT(records map[string][]string) func(context.Context, string) ([]string, error) {
+	return func(ctx context.Context, name string) ([]string, error) {
+		if recs, ok := records[name]; ok {
+			return recs, nil
+		}
+		return nil, errors.New("no such host")
+	}
+}
+
+// redirectClient sends every request to srv, whatever host it was made for.
+func redirectClient(srv *httptest.Server) *http.Client {
+	target, _ := url.Parse(srv.URL)
+	client := srv.Client()
+	transport := client.Transport
+	client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r = r.Clone(r.Context())
+		r.URL.Scheme = target.Scheme
+		r.URL.Host = target.Host
+		return transport.RoundTrip(r)
+	})
+	return client
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestDNSHandleResolver(t *testing.T) {
+	r := &DNSHandleResolver{LookupTXT: fakeTXT(map[string][]string{
+		"_atproto.alice.example.com": {"v=spf1 -all", "did=did:plc:abc123"},
+		"_atproto.bob.example.com":   {"did=did:plc:one", "did=did:plc:two"},
+	})}
+
+	did, err := r.ResolveHandle(context.Background(), "alice.example.com")
+	if err != nil || did != "did:plc:abc123" {
+		t.Errorf("expected did:plc:abc123, got %q (%v)", did, err)
+	}
+	if _, err := r.ResolveHandle(context.Background(), "bob.example.com"); err == nil {
+		t.Error("expected error for conflicting TXT records, got nil")
+	}
+	if _, err := r.ResolveHandle(context.Background(), "carol.example.com"); err == nil {
+		t.Error("expected error for missing TXT record, got nil")
+	}
+}
+
+func TestWellKnownHandleResolver(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/atproto-did" || r.Host != "alice.example.com" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("did:plc:abc123\n"))
+	}))
+	defer srv.Close()
+
+	r := &WellKnownHandleResolver{HTTPClient: redirectClient(srv)}
+	did, err := r.ResolveHandle(context.Background(), "alice.example.com")
+	if err != nil || did != "did:plc:abc123" {
+		t.Errorf("expected did:plc:abc123, got %q (%v)", did, err)
+	}
+	if _, err := r.ResolveHandle(context.Background(), "bob.example.com"); err == nil {
+		t.Error("expected error for unknown handle, got nil")
+	}
+}
+
+func TestResolver_Lookup(t *testing.T) {
+	plc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(testDocument(r.URL.Path[1:]))
+	}))
+	defer plc.Close()
+	xrpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/xrpc/com.atproto.identity.resolveHandle" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"did": "did:plc:fallback"})
+	}))
+	defer xrpc.Close()
+
+	dns := &DNSHandleResolver{LookupTXT: fakeTXT(map[string][]string{
+		"_atproto.alice.example.com": {"did=did:plc:abc123"},
+		"_atproto.mallory.example":   {"did=did:plc:abc123"},
+	})}
+	resolver := NewResolver(plc.URL, "", nil)
+	resolver.Handle = HandleResolverChain{dns, &XRPCHandleResolver{Host: xrpc.URL}}
+
+	tests := []struct {
+		input    string
+		did      string
+		verified bool
+	}{
+		{"@Alice.Example.com", "did:plc:abc123", true},
+		// The DNS record points at a DID that does not claim the handle.
+		{"mallory.example", "did:plc:abc123", false},
+		{"carol.example.com", "did:plc:fallback", false},
+	}
+	for _, tt := range tests {
+		id, err := resolver.Lookup(context.Background(), tt.input)
+		if err != nil {
+			t.Errorf("%s: Lookup failed: %v", tt.input, err)
+			continue
+		}
+		if id.DID != tt.did || id.HandleVerified != tt.verified {
+			t.Errorf("%s: expected %s (verified %v), got %s (verified %v)", tt.input, tt.did, tt.verified, id.DID, id.HandleVerified)
+		}
+	}
+
+	id, err := resolver.Lookup(context.Background(), "did:plc:abc123")
+	if err != nil || id.Handle != "alice.example.com" {
+		t.Errorf("expected handle alice.example.com for DID, got %+v (%v)", id, err)
+	}
+
+	if _, err := resolver.Lookup(context.Background(), "not a handle"); err == nil {
+		t.Error("expected error for invalid handle, got nil")
+	}
+}
@@ -0,0 +1,293 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultPLCDirectory is the public did:plc directory.
+const DefaultPLCDirectory = "https://plc.directory"
+
+// maxDocumentSize bounds the size of a DID document response.
+const maxDocumentSize = 1 << 20
+
+// DIDResolver resolves a DID to its DID document.
+type DIDResolver interface {
+	ResolveDID(ctx context.Context, did string) (*DIDDocument, error)
+}
+
+// PLCResolver resolves did:plc identifiers through a PLC directory.
+type PLCResolver struct {
+	DirectoryURL string
+	HTTPClient   *http.Client
+}
+
+func (r *PLCResolver) ResolveDID(ctx context.Context, did string) (*DIDDocument, error) {
+	if !strings.HasPrefix(did, "did:plc:") {
+		return nil, fmt.Errorf("not a did:plc identifier: %s", did)
+	}
+	directory := r.DirectoryURL
+	if directory == "" {
+		directory = DefaultPLCDirectory
+	}
+	return fetchDocument(ctx, r.HTTPClient, strings.TrimRight(directory, "/")+"/"+did, did)
+}
+
+// WebResolver resolves did:web identifiers from the domain's
+// /.well-known/did.json (or /<path>/did.json for DIDs with a path).
+type WebResolver struct {
+	HTTPClient *http.Client
+}
+
+func (r *WebResolver) ResolveDID(ctx context.Context, did string) (*DIDDocument, error) {
+	docURL, err := webDocumentURL(did)
+	if err != nil {
+		return nil, err
+	}
+	return fetchDocument(ctx, r.HTTPClient, docURL, did)
+}
+
+// webDocumentURL maps a did:web identifier to the URL of its document.
+func webDocumentURL(did string) (string, error) {
+	id, ok := strings.CutPrefix(did, "did:web:")
+	if !ok || id == "" {
+		return "", fmt.Errorf("not a did:web identifier: %s", did)
+	}
+
+	parts := strings.Split(id, ":")
+	host, err := url.PathUnescape(parts[0]) // a port is encoded as %3A
+	if err != nil || host == "" || strings.ContainsAny(host, "/?#@") {
+		return "", fmt.Errorf("invalid did:web host in %s", did)
+	}
+
+	if len(parts) == 1 {
+		return "https://" + host + "/.well-known/did.json", nil
+	}
+	path := make([]string, len(parts)-1)
+	for i, p := range parts[1:] {
+		seg, err := url.PathUnescape(p)
+		if err != nil || seg == "" {
+			return "", fmt.Errorf("invalid did:web path in %s", did)
+		}
+		path[i] = url.PathEscape(seg)
+	}
+	return "https://" + host + "/" + strings.Join(path, "/") + "/did.json", nil
+}
+
+// fetchDocument downloads and decodes a DID document, checking that it
+// describes the requested DID.
+func fetchDocument(ctx context.Context, client *http.Client, docURL, did string) (*DIDDocument, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", docURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/did+ld+json, application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch DID doc for %s: %s", did, resp.Status)
+	}
+
+	var doc DIDDocument
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDocumentSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decoding DID doc for %s: %w", did, err)
+	}
+	if doc.ID != did {
+		return nil, fmt.Errorf("DID doc for %s describes %q", did, doc.ID)
+	}
+
+	return &doc, nil
+}
+
+// Resolver resolves handles and DIDs. DID resolution dispatches to a
+// resolver per DID method and caches the results.
+type Resolver struct {
+	Handle HandleResolver
+	PLC    DIDResolver
+	Web    DIDResolver
+	Cache  Cache
+	// Logf, if set, reports stale cache fallbacks and PDS migrations.
+	Logf func(format string, args ...any)
+}
+
+// NewResolver returns a resolver for did:plc (using the given directory, or
+// the public one if empty) and did:web, with an in-memory cache. Handles are
+// resolved via DNS and HTTPS; if handleFallback is set, that server's
+// resolveHandle endpoint is tried last. All HTTP requests go through
+// httpClient, or http.DefaultClient if it is nil.
+func NewResolver(plcDirectory, handleFallback string, httpClient *http.Client) *Resolver {
+	handles := HandleResolverChain{&DNSHandleResolver{}, &WellKnownHandleResolver{HTTPClient: httpClient}}
+	if handleFallback != "" {
+		handles = append(handles, &XRPCHandleResolver{Host: handleFallback, HTTPClient: httpClient})
+	}
+	return &Resolver{
+		Handle: handles,
+		PLC:    &PLCResolver{DirectoryURL: plcDirectory, HTTPClient: httpClient},
+		Web:    &WebResolver{HTTPClient: httpClient},
+		Cache:  NewMemoryCache(time.Hour),
+	}
+}
+
+// Identity is a resolved account.
+type Identity struct {
+	DID    string
+	Handle string
+	// HandleVerified is true if the DID document claims Handle, i.e. the
+	// handle and DID point at each other.
+	HandleVerified bool
+	Doc            *DIDDocument
+}
+
+// Lookup resolves a handle or DID to an Identity. For handles, it checks
+// that the DID document's alsoKnownAs lists the handle; a mismatch is not an
+// error but leaves HandleVerified false.
+func (r *Resolver) Lookup(ctx context.Context, handleOrDID string) (*Identity, error) {
+	if strings.HasPrefix(handleOrDID, "did:") {
+		doc, err := r.ResolveDID(ctx, handleOrDID)
+		if err != nil {
+			return nil, err
+		}
+		id := &Identity{DID: handleOrDID, Doc: doc}
+		if handles := doc.Handles(); len(handles) > 0 {
+			id.Handle = handles[0]
+		}
+		return id, nil
+	}
+
+	handle, err := NormalizeHandle(handleOrDID)
+	if err != nil {
+		return nil, err
+	}
+	if r.Handle == nil {
+		return nil, fmt.Errorf("no handle resolver configured")
+	}
+	did, err := r.resolveHandle(ctx, handle)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := r.ResolveDID(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	id := &Identity{DID: did, Handle: handle, Doc: doc}
+	for _, h := range doc.Handles() {
+		if strings.EqualFold(h, handle) {
+			id.HandleVerified = true
+			break
+		}
+	}
+	return id, nil
+}
+
+// resolveHandle resolves handle through the cache, falling back to a stale
+// cache entry if resolution fails.
+func (r *Resolver) resolveHandle(ctx context.Context, handle string) (string, error) {
+	var cached string
+	var stale, ok bool
+	if r.Cache != nil {
+		cached, stale, ok = r.Cache.GetHandle(handle)
+		if ok && !stale {
+			return cached, nil
+		}
+	}
+
+	did, err := r.Handle.ResolveHandle(ctx, handle)
+	if err != nil {
+		if ok {
+			r.logf("Warning: %v; using cached DID %s", err, cached)
+			return cached, nil
+		}
+		return "", err
+	}
+	if r.Cache != nil {
+		r.Cache.PutHandle(handle, did)
+	}
+	return did, nil
+}
+
+// ResolveDID returns the DID document of did. Cached documents are used
+// until they expire; after that a failed resolution falls back to the stale
+// copy. A changed PDS endpoint is reported as an account migration.
+func (r *Resolver) ResolveDID(ctx context.Context, did string) (*DIDDocument, error) {
+	return r.resolveDID(ctx, did, false)
+}
+
+// RefreshDID resolves did again even if its document is cached, e.g. when
+// the cached PDS no longer serves the repo. It doesn't fall back to the
+// cached copy.
+func (r *Resolver) RefreshDID(ctx context.Context, did string) (*DIDDocument, error) {
+	return r.resolveDID(ctx, did, true)
+}
+
+func (r *Resolver) resolveDID(ctx context.Context, did string, refresh bool) (*DIDDocument, error) {
+	var cached *DIDDocument
+	var stale, ok bool
+	if r.Cache != nil {
+		cached, stale, ok = r.Cache.GetDocument(did)
+		if ok && !stale && !refresh {
+			return cached, nil
+		}
+	}
+
+	var method DIDResolver
+	switch {
+	case strings.HasPrefix(did, "did:plc:"):
+		method = r.PLC
+	case strings.HasPrefix(did, "did:web:"):
+		method = r.Web
+	}
+	if method == nil {
+		return nil, fmt.Errorf("unsupported DID method: %s", did)
+	}
+
+	doc, err := method.ResolveDID(ctx, did)
+	if err != nil {
+		if ok && !refresh {
+			r.logf("Warning: %v; using cached DID document for %s", err, did)
+			return cached, nil
+		}
+		return nil, err
+	}
+	if ok {
+		oldPDS, _ := cached.PDSEndpoint()
+		newPDS, _ := doc.PDSEndpoint()
+		if oldPDS != newPDS {
+			r.logf("Account migration: %s moved from PDS %s to %s", did, oldPDS, newPDS)
+		}
+	}
+	if r.Cache != nil {
+		r.Cache.PutDocument(did, doc)
+	}
+	return doc, nil
+}
+
+// ResolvePDS returns the PDS endpoint for did.
+func (r *Resolver) ResolvePDS(ctx context.Context, did string) (string, error) {
+	doc, err := r.ResolveDID(ctx, did)
+	if err != nil {
+		return "", err
+	}
+	return doc.PDSEndpoint()
+}
+
+func (r *Resolver) logf(format string, args ...any) {
+	if r.Logf != nil {
+		r.Logf(format, args...)
+	}
+}
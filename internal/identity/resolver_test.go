@@ -0,0 +1,157 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func testDocument(did string) DIDDocument {
+	return DIDDocument{
+		ID:          did,
+		AlsoKnownAs: []string{"at://alice.example.com"},
+		VerificationMethod: []VerificationMethod{{
+			ID:                 did + "#atproto",
+			Type:               "Multikey",
+			Controller:         did,
+			PublicKeyMultibase: "zQ3shXjHeiBuRCKmM36cuYnm7YEMzhGnCmCyW92sRJ9pribSF",
+		}},
+		Service: []Service{{
+			ID:              "#atproto_pds",
+			Type:            "AtprotoPersonalDataServer",
+			ServiceEndpoint: "https://pds.example.com",
+		}},
+	}
+}
+
+func TestResolver_PLC(t *testing.T) {
+	const did = "did:plc:abc123"
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != "/"+did {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(testDocument(did))
+	}))
+	defer srv.Close()
+
+	resolver := NewResolver(srv.URL, "", nil)
+
+	for i := 0; i < 2; i++ {
+		doc, err := resolver.ResolveDID(context.Background(), did)
+		if err != nil {
+			t.Fatalf("ResolveDID failed: %v", err)
+		}
+		pds, err := doc.PDSEndpoint()
+		if err != nil || pds != "https://pds.example.com" {
+			t.Errorf("expected PDS https://pds.example.com, got %q (%v)", pds, err)
+		}
+		if handles := doc.Handles(); len(handles) != 1 || handles[0] != "alice.example.com" {
+			t.Errorf("expected handle alice.example.com, got %v", handles)
+		}
+		if _, err := doc.SigningKey(); err != nil {
+			t.Errorf("SigningKey failed: %v", err)
+		}
+	}
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 request thanks to the cache, got %d", n)
+	}
+}
+
+func TestResolver_Web(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.ReplaceAll(r.Host, ":", "%3A")
+		if r.URL.Path != "/.well-known/did.json" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(testDocument("did:web:" + host))
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	did := "did:web:" + strings.ReplaceAll(u.Host, ":", "%3A")
+
+	resolver := NewResolver("", "", srv.Client())
+
+	doc, err := resolver.ResolveDID(context.Background(), did)
+	if err != nil {
+		t.Fatalf("ResolveDID failed: %v", err)
+	}
+	if doc.ID != did {
+		t.Errorf("expected document for %s, got %s", did, doc.ID)
+	}
+}
+
+func TestResolver_DocumentMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(testDocument("did:plc:someoneelse"))
+	}))
+	defer srv.Close()
+
+	_, err := NewResolver(srv.URL, "", nil).ResolveDID(context.Background(), "did:plc:abc123")
+	if err == nil {
+		t.Fatal("expected an error for a document describing another DID")
+	}
+}
+
+func TestWebDocumentURL(t *testing.T) {
+	tests := []struct {
+		did      string
+		expected string
+		wantErr  bool
+	}{
+		{"did:web:example.com", "https://example.com/.well-known/did.json", false},
+		{"did:web:localhost%3A8443", "https://localhost:8443/.well-known/did.json", false},
+		{"did:web:example.com:user:alice", "https://example.com/user/alice/did.json", false},
+		{"did:web:", "", true},
+		{"did:plc:abc", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := webDocumentURL(tt.did)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("webDocumentURL(%q) error = %v, wantErr %v", tt.did, err, tt.wantErr)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("webDocumentURL(%q) = %q, expected %q", tt.did, got, tt.expected)
+		}
+	}
+}
+
+func TestResolver_RefreshDID(t *testing.T) {
+	const did = "did:plc:abc123"
+	moved := testDocument(did)
+	moved.Service[0].ServiceEndpoint = "https://new-pds.example.com"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(moved)
+	}))
+	defer srv.Close()
+
+	resolver := NewResolver(srv.URL, "", nil)
+	cached := testDocument(did)
+	resolver.Cache.PutDocument(did, &cached)
+
+	if pds, err := resolver.ResolvePDS(context.Background(), did); err != nil || pds != "https://pds.example.com" {
+		t.Errorf("expected the cached PDS, got %q (%v)", pds, err)
+	}
+	doc, err := resolver.RefreshDID(context.Background(), did)
+	if err != nil {
+		t.Fatalf("RefreshDID failed: %v", err)
+	}
+	if pds, _ := doc.PDSEndpoint(); pds != "https://new-pds.example.com" {
+		t.Errorf("expected the new PDS, got %q", pds)
+	}
+	if pds, err := resolver.ResolvePDS(context.Background(), did); err != nil || pds != "https://new-pds.example.com" {
+		t.Errorf("expected the refreshed document to be cached, got %q (%v)", pds, err)
+	}
+}
@@ -1,86 +1,414 @@
 package media
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
 )
 
 type Downloader struct {
-	ImagesDir       string
-	ImagePathPrefix string
-	PDSHost         string
+	// Storage receives the downloaded blobs and provides their URLs.
+	Storage Storage
+	// WorkDir holds temporary files while blobs are verified and processed;
+	// the system's temp dir if empty.
+	WorkDir string
+	PDSHost string
+	// BlobsDir optionally holds blobs exported from the repo, named by CID.
+	// Blobs found there are used instead of downloading them from PDSHost.
+	BlobsDir string
+	// HostFor, if set, returns the current PDS of a DID and takes
+	// precedence over PDSHost, so downloads follow account migrations.
+	HostFor func(ctx context.Context, did string) (string, error)
+	// HTTPClient is used for downloads; http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// QuarantineDir receives blobs that failed verification. It should lie
+	// outside the published site; if empty, such blobs are deleted.
+	QuarantineDir string
+	// Index records the file each blob was stored as.
+	Index *Index
+	// Processor post-processes images; if nil they are stored as they are.
+	Processor *Processor
+	// MaxSize is the largest blob in bytes that is downloaded; 0 for no
+	// limit.
+	MaxSize int64
+
+	// flights deduplicates concurrent downloads; shared with ForBundle
+	// copies.
+	flights *flightGroup
 }
 
-func NewDownloader(imagesDir, imagePathPrefix, pdsHost string) *Downloader {
+// ErrTooLarge is returned for blobs larger than Downloader.MaxSize.
+var ErrTooLarge = errors.New("blob too large")
+
+// maxVerifyAttempts is how often a blob is fetched before a verification
+// failure is reported.
+const maxVerifyAttempts = 3
+
+// NewDownloader returns a downloader that stores blobs in imagesDir and
+// links them below imagePathPrefix.
+func NewDownloader(imagesDir, imagePathPrefix, pdsHost string, httpClient *http.Client) *Downloader {
 	return &Downloader{
-		ImagesDir:       imagesDir,
-		ImagePathPrefix: imagePathPrefix,
-		PDSHost:         pdsHost,
+		Storage:    &LocalStorage{Dir: imagesDir, URLPrefix: imagePathPrefix},
+		PDSHost:    pdsHost,
+		HTTPClient: httpClient,
+		Index:      NewIndex(),
+		Processor:  &Processor{},
+		flights:    newFlightGroup(),
 	}
 }
 
-func (d *Downloader) DownloadBlob(ctx context.Context, did string, cid string) (string, error) {
-	// https://bsky.social/xrpc/com.atproto.sync.getBlob?did=did:plc:xxx&cid=bafyxxx
-	url := fmt.Sprintf("%s/xrpc/com.atproto.sync.getBlob?did=%s&cid=%s", d.PDSHost, did, cid)
+// ForBundle returns a downloader that stores blobs in dir, a Hugo page
+// bundle, and returns paths relative to it. It shares the index with d.
+func (d *Downloader) ForBundle(dir string) *Downloader {
+	bd := *d
+	bd.Storage = &LocalStorage{Dir: dir}
+	bd.Index = d.Index.Scope(dir)
+	return &bd
+}
+
+// Stored describes a stored blob.
+type Stored struct {
+	// Path is the URL or path posts reference the file by.
+	Path string
+	// Width and Height are the pixel dimensions, or 0 if unknown.
+	Width, Height int
+	// Variants are resized copies, narrowest first.
+	Variants []StoredVariant
+}
+
+// StoredVariant is a resized copy of a stored image.
+type StoredVariant struct {
+	Path          string
+	Width, Height int
+}
 
-	if err := os.MkdirAll(d.ImagesDir, 0755); err != nil {
-		return "", err
+// DownloadBlob stores a blob in Storage and describes the result.
+// Content is downloaded to a temporary file and only stored once it matches
+// the blob's CID, size and MIME type. Mismatching content, including
+// previously stored files, is moved to QuarantineDir and downloaded again.
+// Images are then run through Processor, if set.
+//
+// DownloadBlob is safe for concurrent use. Concurrent calls for the same
+// blob and destination share a single download.
+func (d *Downloader) DownloadBlob(ctx context.Context, did string, blob atproto.Blob) (*Stored, error) {
+	if d.flights == nil {
+		return d.downloadBlob(ctx, did, blob)
 	}
+	return d.flights.do(d.Index.key(blob.Ref.Link), func() (*Stored, error) {
+		return d.downloadBlob(ctx, did, blob)
+	})
+}
 
-	// Check if file already exists (try common extensions)
-	for _, ext := range []string{".jpg", ".png", ".webp", ".gif", ".bin"} {
-		fileName := cid + ext
-		filePath := filepath.Join(d.ImagesDir, fileName)
-		if _, err := os.Stat(filePath); err == nil {
-			return filepath.Join(d.ImagePathPrefix, fileName), nil
+func (d *Downloader) downloadBlob(ctx context.Context, did string, blob atproto.Blob) (*Stored, error) {
+	if entry, ok := d.cached(ctx, blob); ok {
+		return d.stored(entry), nil
+	}
+	if d.MaxSize > 0 && int64(blob.Size) > d.MaxSize {
+		return nil, fmt.Errorf("%w: %s has %d bytes, the limit is %d", ErrTooLarge, blob.Ref.Link, blob.Size, d.MaxSize)
+	}
+	if d.WorkDir != "" {
+		if err := os.MkdirAll(d.WorkDir, 0755); err != nil {
+			return nil, err
 		}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	var lastErr error
+	useLocal := d.BlobsDir != ""
+	for attempt := 0; attempt < maxVerifyAttempts; attempt++ {
+		entry, err := d.fetch(ctx, did, blob, useLocal)
+		if err == nil {
+			return d.stored(entry), nil
+		}
+		if !errors.Is(err, ErrIntegrity) {
+			return nil, err
+		}
+		// A bad local copy won't get better; try the PDS next.
+		lastErr = err
+		useLocal = false
+	}
+	return nil, lastErr
+}
+
+func (d *Downloader) stored(e Entry) *Stored {
+	s := &Stored{
+		Path:   d.Storage.URL(e.File),
+		Width:  e.Width,
+		Height: e.Height,
+	}
+	for _, v := range e.Variants {
+		s.Variants = append(s.Variants, StoredVariant{
+			Path:   d.Storage.URL(v.File),
+			Width:  v.Width,
+			Height: v.Height,
+		})
+	}
+	return s
+}
+
+// fetch downloads blob to a temporary file in WorkDir, verifies and
+// processes it and puts it into Storage.
+func (d *Downloader) fetch(ctx context.Context, did string, blob atproto.Blob, useLocal bool) (Entry, error) {
+	check, err := newBlobCheck(blob)
 	if err != nil {
-		return "", err
+		return Entry{}, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	body, err := d.openBlob(ctx, did, blob.Ref.Link, useLocal)
 	if err != nil {
-		return "", err
+		return Entry{}, err
 	}
-	defer resp.Body.Close()
+	defer body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to download blob: %s", resp.Status)
-	}
-
-	// Determine extension from Content-Type header
-	ext := ".bin"
-	contentType := resp.Header.Get("Content-Type")
-	switch contentType {
-	case "image/jpeg":
-		ext = ".jpg"
-	case "image/png":
-		ext = ".png"
-	case "image/webp":
-		ext = ".webp"
-	case "image/gif":
-		ext = ".gif"
-	}
-	fileName := cid + ext
-	filePath := filepath.Join(d.ImagesDir, fileName)
-
-	out, err := os.Create(filePath)
+	tmp, err := os.CreateTemp(d.WorkDir, "."+blob.Ref.Link+"-*.tmp")
+	if err != nil {
+		return Entry{}, err
+	}
+	defer os.Remove(tmp.Name())
+	// The record's size may be missing or wrong, so enforce the limit on
+	// the content as well.
+	var src io.Reader = body
+	if d.MaxSize > 0 {
+		src = io.LimitReader(body, d.MaxSize+1)
+	}
+	n, err := io.Copy(io.MultiWriter(tmp, check), src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	if d.MaxSize > 0 && n > d.MaxSize {
+		return Entry{}, fmt.Errorf("%w: %s exceeds the limit of %d bytes", ErrTooLarge, blob.Ref.Link, d.MaxSize)
+	}
+
+	if err := check.verify(); err != nil {
+		d.quarantine(tmp.Name())
+		return Entry{}, err
+	}
+
+	// Prefer the type from the record, then the sniffed one. Unknown types
+	// are not published as opaque files.
+	mediaType := blob.Mime
+	ext := ExtensionFor(mediaType)
+	if ext == "" {
+		mediaType = check.contentType()
+		ext = ExtensionFor(mediaType)
+	}
+	if ext == "" {
+		return Entry{}, fmt.Errorf("blob %s: unsupported media type %q", blob.Ref.Link, check.contentType())
+	}
+	entry := Entry{File: blob.Ref.Link + ext}
+	if ext == ".svg" {
+		if err := checkSVGFile(tmp.Name()); err != nil {
+			return Entry{}, fmt.Errorf("blob %s: %w", blob.Ref.Link, err)
+		}
+	}
+
+	if d.Processor != nil {
+		if err := d.process(ctx, tmp.Name(), blob.Ref.Link, mediaType, &entry); err != nil {
+			return Entry{}, fmt.Errorf("processing blob %s: %w", blob.Ref.Link, err)
+		}
+	}
+
+	if err := d.putFile(ctx, entry.File, tmp.Name(), mediaType); err != nil {
+		return Entry{}, fmt.Errorf("storing blob %s: %w", blob.Ref.Link, err)
+	}
+	if err := d.Index.Add(blob.Ref.Link, entry); err != nil {
+		return Entry{}, fmt.Errorf("updating media index: %w", err)
+	}
+	return entry, nil
+}
+
+// putFile puts the file at path into Storage as name.
+func (d *Downloader) putFile(ctx context.Context, name, path, mediaType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return d.Storage.Put(ctx, name, f, info.Size(), mediaType)
+}
+
+// process runs the file at path through Processor, replacing its content if
+// needed and storing variants. Files Processor doesn't handle, such as
+// videos, are never read into memory.
+func (d *Downloader) process(ctx context.Context, path, cid, mediaType string, entry *Entry) error {
+	entry.Processing = d.Processor.fingerprint()
+	if !processable(mediaType) {
+		return nil
+	}
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer out.Close()
+	res, err := d.Processor.process(data, mediaType)
+	if err != nil {
+		return err
+	}
+
+	entry.Width, entry.Height = res.width, res.height
+	if res.data != nil {
+		if err := os.WriteFile(path, res.data, 0644); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(res.data)
+		entry.SHA256 = hex.EncodeToString(sum[:])
+	}
+	for _, v := range res.variants {
+		name := fmt.Sprintf("%s-%dw%s", cid, v.width, v.ext)
+		if err := d.Storage.Put(ctx, name, bytes.NewReader(v.data), int64(len(v.data)), mime.TypeByExtension(v.ext)); err != nil {
+			return err
+		}
+		entry.Variants = append(entry.Variants, Variant{File: name, Width: v.width, Height: v.height})
+	}
+	return nil
+}
 
-	_, err = io.Copy(out, resp.Body)
+// cached returns the entry of a blob that is already stored and intact.
+// Without a Processor, files not in the index yet, e.g. from older versions,
+// are found by the extension of the record's MIME type and adopted.
+func (d *Downloader) cached(ctx context.Context, blob atproto.Blob) (Entry, bool) {
+	entry, ok := d.Index.Lookup(blob.Ref.Link)
+	if !ok {
+		ext := ExtensionFor(blob.Mime)
+		if ext == "" || d.Processor != nil {
+			return Entry{}, false
+		}
+		entry = Entry{File: blob.Ref.Link + ext}
+	}
+	if ok && entry.Processing != d.Processor.fingerprint() {
+		// Processed with other settings; start over from the original.
+		return Entry{}, false
+	}
+
+	switch err := d.checkStored(ctx, entry, blob); {
+	case err == nil:
+		if !ok {
+			d.Index.Add(blob.Ref.Link, entry)
+		}
+		return entry, true
+	case errors.Is(err, ErrIntegrity), errors.Is(err, ErrUnsafeSVG):
+		d.quarantineStored(ctx, entry.File)
+	}
+	if ok {
+		d.Index.Remove(blob.Ref.Link)
+	}
+	return Entry{}, false
+}
+
+// checkStored checks that all files of entry are stored. Content is verified
+// too if Storage can read it back.
+func (d *Downloader) checkStored(ctx context.Context, entry Entry, blob atproto.Blob) error {
+	if opener, ok := d.Storage.(Opener); ok {
+		r, err := opener.Open(ctx, entry.File)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		// SVG images stored by older versions weren't checked for scripts.
+		var svg bytes.Buffer
+		var content io.Reader = r
+		if filepath.Ext(entry.File) == ".svg" {
+			content = io.TeeReader(r, &svg)
+		}
+		if entry.SHA256 != "" {
+			err = verifySHA256(content, entry.SHA256, entry.File)
+		} else {
+			err = verifyContent(content, blob)
+		}
+		if err == nil && svg.Len() > 0 {
+			err = checkSVG(&svg)
+		}
+		if err != nil {
+			return err
+		}
+	} else if err := d.checkExists(ctx, entry.File); err != nil {
+		return err
+	}
+
+	for _, v := range entry.Variants {
+		if err := d.checkExists(ctx, v.File); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Downloader) checkExists(ctx context.Context, name string) error {
+	exists, err := d.Storage.Exists(ctx, name)
+	if err == nil && !exists {
+		err = fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	return err
+}
+
+// openBlob returns the blob contents, preferring a local copy in BlobsDir
+// over a download from the PDS if useLocal is set.
+func (d *Downloader) openBlob(ctx context.Context, did string, cid string, useLocal bool) (io.ReadCloser, error) {
+	if useLocal {
+		f, err := os.Open(filepath.Join(d.BlobsDir, cid))
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	url, err := d.RemoteURL(ctx, did, cid)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := d.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return filepath.Join(d.ImagePathPrefix, fileName), nil
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download blob: %s", resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// RemoteURL returns the getBlob URL of a blob on the PDS of did.
+func (d *Downloader) RemoteURL(ctx context.Context, did string, cid string) (string, error) {
+	host := d.PDSHost
+	if d.HostFor != nil {
+		var err error
+		if host, err = d.HostFor(ctx, did); err != nil {
+			return "", fmt.Errorf("resolving PDS for blob %s: %w", cid, err)
+		}
+	}
+	if host == "" {
+		return "", fmt.Errorf("blob %s is not available offline", cid)
+	}
+
+	// https://bsky.social/xrpc/com.atproto.sync.getBlob?did=did:plc:xxx&cid=bafyxxx
+	return fmt.Sprintf("%s/xrpc/com.atproto.sync.getBlob?did=%s&cid=%s", host, did, cid), nil
 }
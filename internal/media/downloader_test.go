@@ -0,0 +1,518 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+)
+
+var testPNG = encodePNG(40, 30)
+
+// encodePNG returns a w×h PNG image.
+func encodePNG(w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func testBlob(t *testing.T, data []byte, mime string) atproto.Blob {
+	t.Helper()
+	mh, err := multihash.Sum(data, multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return atproto.Blob{
+		Ref:  atproto.BlobRef{Link: cid.NewCidV1(cid.Raw, mh).String()},
+		Mime: mime,
+		Size: len(data),
+	}
+}
+
+// blobServer serves responses[i] for the i-th request, repeating the last.
+func blobServer(responses ...[]byte) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(requests.Add(1)) - 1
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(responses[min(i, len(responses)-1)])
+	}))
+	return srv, &requests
+}
+
+func newTestDownloader(t *testing.T, host string) *Downloader {
+	dir := t.TempDir()
+	d := NewDownloader(filepath.Join(dir, "images"), "/images", host, nil)
+	d.WorkDir = filepath.Join(dir, "work")
+	d.QuarantineDir = filepath.Join(dir, "quarantine")
+	return d
+}
+
+// imagesDir returns the directory d stores blobs in.
+func imagesDir(d *Downloader) string {
+	return d.Storage.(*LocalStorage).Dir
+}
+
+func TestDownloadBlob(t *testing.T) {
+	blob := testBlob(t, testPNG, "image/png")
+	srv, requests := blobServer(testPNG)
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	for i := 0; i < 2; i++ {
+		stored, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob)
+		if err != nil {
+			t.Fatalf("DownloadBlob failed: %v", err)
+		}
+		if expected := "/images/" + blob.Ref.Link + ".png"; stored.Path != expected {
+			t.Errorf("expected %s, got %s", expected, stored.Path)
+		}
+		if stored.Width != 40 || stored.Height != 30 {
+			t.Errorf("expected 40x30, got %dx%d", stored.Width, stored.Height)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 download thanks to the verified cache, got %d", n)
+	}
+
+	entries, _ := os.ReadDir(imagesDir(d))
+	if len(entries) != 1 {
+		t.Errorf("expected only the image in the images dir, got %d entries", len(entries))
+	}
+}
+
+func TestDownloadBlob_RetriesCorruptContent(t *testing.T) {
+	blob := testBlob(t, testPNG, "image/png")
+	srv, requests := blobServer(testPNG[:50], testPNG)
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	if _, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob); err != nil {
+		t.Fatalf("DownloadBlob failed: %v", err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected 2 downloads, got %d", n)
+	}
+	quarantined, _ := os.ReadDir(d.QuarantineDir)
+	if len(quarantined) != 1 {
+		t.Errorf("expected the truncated download in quarantine, got %d files", len(quarantined))
+	}
+}
+
+func TestDownloadBlob_ReplacesTruncatedFile(t *testing.T) {
+	blob := testBlob(t, testPNG, "image/png")
+	srv, requests := blobServer(testPNG)
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	os.MkdirAll(imagesDir(d), 0755)
+	stale := filepath.Join(imagesDir(d), blob.Ref.Link+".png")
+	if err := os.WriteFile(stale, testPNG[:10], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob); err != nil {
+		t.Fatalf("DownloadBlob failed: %v", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected the truncated file to be downloaded again, got %d requests", n)
+	}
+	data, _ := os.ReadFile(stale)
+	if len(data) != len(testPNG) {
+		t.Errorf("expected %d bytes, got %d", len(testPNG), len(data))
+	}
+}
+
+func TestDownloadBlob_Mismatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		blob  atproto.Blob
+		serve []byte
+	}{
+		{"wrong content", testBlob(t, testPNG, "image/png"), []byte("\x89PNG\r\n\x1a\nsomething else")},
+		{"wrong size", func() atproto.Blob { b := testBlob(t, testPNG, "image/png"); b.Size++; return b }(), testPNG},
+		{"wrong mime", testBlob(t, testPNG, "image/jpeg"), testPNG},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := blobServer(tt.serve)
+			defer srv.Close()
+
+			d := newTestDownloader(t, srv.URL)
+			_, err := d.DownloadBlob(context.Background(), "did:plc:abc123", tt.blob)
+			if !errors.Is(err, ErrIntegrity) {
+				t.Fatalf("expected ErrIntegrity, got %v", err)
+			}
+			if n := requests.Load(); n != maxVerifyAttempts {
+				t.Errorf("expected %d attempts, got %d", maxVerifyAttempts, n)
+			}
+			if entries, _ := os.ReadDir(imagesDir(d)); len(entries) != 0 {
+				t.Errorf("expected no files left in the images dir, got %d", len(entries))
+			}
+		})
+	}
+}
+
+func TestDownloadBlob_Index(t *testing.T) {
+	avif := bytes.Join([][]byte{box("ftyp", []byte("avif\x00\x00\x00\x00avifmif1")), box("meta", []byte{0, 0, 0, 0}), box("mdat", make([]byte, 100))}, nil)
+	blob := testBlob(t, avif, "image/avif")
+	srv, requests := blobServer(avif)
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	indexPath := filepath.Join(t.TempDir(), "media.json")
+	var err error
+	if d.Index, err = LoadIndex(indexPath); err != nil {
+		t.Fatalf("LoadIndex failed: %v", err)
+	}
+
+	stored, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob)
+	if err != nil {
+		t.Fatalf("DownloadBlob failed: %v", err)
+	}
+	if expected := "/images/" + blob.Ref.Link + ".avif"; stored.Path != expected {
+		t.Errorf("expected %s, got %s", expected, stored.Path)
+	}
+
+	// A new downloader finds the file through the persisted index, even if
+	// the record no longer names the type.
+	d2 := NewDownloader(imagesDir(d), "/images", srv.URL, nil)
+	if d2.Index, err = LoadIndex(indexPath); err != nil {
+		t.Fatalf("LoadIndex failed: %v", err)
+	}
+	blob.Mime = ""
+	if stored2, err := d2.DownloadBlob(context.Background(), "did:plc:abc123", blob); err != nil || stored2.Path != stored.Path {
+		t.Errorf("expected cached %s, got %v (%v)", stored.Path, stored2, err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 download, got %d", n)
+	}
+}
+
+func TestDownloadBlob_UnsupportedType(t *testing.T) {
+	data := []byte("just some text")
+	blob := testBlob(t, data, "")
+	srv, _ := blobServer(data)
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	if _, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob); err == nil {
+		t.Fatal("expected error for unsupported media type, got nil")
+	}
+	if entries, _ := os.ReadDir(imagesDir(d)); len(entries) != 0 {
+		t.Errorf("expected no files in the images dir, got %d", len(entries))
+	}
+}
+
+func TestDownloadBlob_UnsafeSVG(t *testing.T) {
+	safe := []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><circle r="4"/></svg>`)
+	unsafe := []byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(document.cookie)"><circle r="4"/></svg>`)
+
+	srv, _ := blobServer(safe)
+	defer srv.Close()
+	d := newTestDownloader(t, srv.URL)
+	blob := testBlob(t, safe, "image/svg+xml")
+	if _, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob); err != nil {
+		t.Fatalf("DownloadBlob failed for a plain SVG: %v", err)
+	}
+
+	// Refused whether the record declares SVG or the content is sniffed.
+	for _, mime := range []string{"image/svg+xml", ""} {
+		srv, _ := blobServer(unsafe)
+		defer srv.Close()
+		d := newTestDownloader(t, srv.URL)
+		_, err := d.DownloadBlob(context.Background(), "did:plc:abc123", testBlob(t, unsafe, mime))
+		if !errors.Is(err, ErrUnsafeSVG) {
+			t.Errorf("mime %q: expected ErrUnsafeSVG, got %v", mime, err)
+		}
+		if entries, _ := os.ReadDir(imagesDir(d)); len(entries) != 0 {
+			t.Errorf("mime %q: expected no files in the images dir, got %d", mime, len(entries))
+		}
+	}
+
+	// A copy stored before SVGs were checked is removed.
+	blob = testBlob(t, unsafe, "image/svg+xml")
+	srv, _ = blobServer(unsafe)
+	defer srv.Close()
+	d = newTestDownloader(t, srv.URL)
+	stale := filepath.Join(imagesDir(d), blob.Ref.Link+".svg")
+	os.MkdirAll(imagesDir(d), 0755)
+	if err := os.WriteFile(stale, unsafe, 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.Index.Add(blob.Ref.Link, Entry{File: blob.Ref.Link + ".svg", Processing: d.Processor.fingerprint()})
+	if _, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob); !errors.Is(err, ErrUnsafeSVG) {
+		t.Errorf("expected ErrUnsafeSVG for the stored copy, got %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("expected the stored copy to be removed, got %v", err)
+	}
+}
+
+func TestDownloadBlob_Audio(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+	}{
+		{"m4a brand", "\x00\x00\x00\x1cftypM4A \x00\x00\x00\x00M4A mp42isom"},
+		{"generic brand", "\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append([]byte(tt.head), make([]byte, 64)...)
+			blob := testBlob(t, data, "audio/mp4")
+			srv, requests := blobServer(data)
+			defer srv.Close()
+
+			d := newTestDownloader(t, srv.URL)
+			stored, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob)
+			if err != nil {
+				t.Fatalf("DownloadBlob failed: %v", err)
+			}
+			if expected := "/images/" + blob.Ref.Link + ".m4a"; stored.Path != expected {
+				t.Errorf("expected %s, got %s", expected, stored.Path)
+			}
+			if n := requests.Load(); n != 1 {
+				t.Errorf("expected 1 download, got %d", n)
+			}
+			if quarantined, _ := os.ReadDir(d.QuarantineDir); len(quarantined) != 0 {
+				t.Errorf("expected nothing in quarantine, got %d files", len(quarantined))
+			}
+		})
+	}
+}
+
+func TestDownloadBlob_Variants(t *testing.T) {
+	data := encodePNG(400, 200)
+	blob := testBlob(t, data, "image/png")
+	srv, requests := blobServer(data)
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	d.Processor = &Processor{Widths: []int{200, 100, 800}}
+	stored, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob)
+	if err != nil {
+		t.Fatalf("DownloadBlob failed: %v", err)
+	}
+
+	expected := []StoredVariant{
+		{Path: "/images/" + blob.Ref.Link + "-100w.png", Width: 100, Height: 50},
+		{Path: "/images/" + blob.Ref.Link + "-200w.png", Width: 200, Height: 100},
+	}
+	if len(stored.Variants) != len(expected) {
+		t.Fatalf("expected %d variants, got %v", len(expected), stored.Variants)
+	}
+	for i, v := range stored.Variants {
+		if v != expected[i] {
+			t.Errorf("expected variant %v, got %v", expected[i], v)
+		}
+		cfg, err := png.DecodeConfig(bytesOf(t, filepath.Join(imagesDir(d), filepath.Base(v.Path))))
+		if err != nil || cfg.Width != v.Width {
+			t.Errorf("expected a %dpx wide PNG at %s, got %d (%v)", v.Width, v.Path, cfg.Width, err)
+		}
+	}
+
+	// A missing variant is rendered again.
+	os.Remove(filepath.Join(imagesDir(d), blob.Ref.Link+"-100w.png"))
+	if _, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob); err != nil {
+		t.Fatalf("DownloadBlob failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(imagesDir(d), blob.Ref.Link+"-100w.png")); err != nil {
+		t.Errorf("expected the variant to be restored: %v", err)
+	}
+
+	// So are all of them when the settings change.
+	d.Processor.Widths = []int{300}
+	stored, err = d.DownloadBlob(context.Background(), "did:plc:abc123", blob)
+	if err != nil {
+		t.Fatalf("DownloadBlob failed: %v", err)
+	}
+	if len(stored.Variants) != 1 || stored.Variants[0].Width != 300 {
+		t.Errorf("expected a 300px variant, got %v", stored.Variants)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("expected 3 downloads, got %d", n)
+	}
+}
+
+func TestDownloadBlob_StripsMetadata(t *testing.T) {
+	data := withPNGChunk(testPNG, "tEXt", []byte("Comment\x00secret"))
+	blob := testBlob(t, data, "image/png")
+	srv, requests := blobServer(data)
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	stored, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob)
+	if err != nil {
+		t.Fatalf("DownloadBlob failed: %v", err)
+	}
+	file := filepath.Join(imagesDir(d), filepath.Base(stored.Path))
+	if got, _ := os.ReadFile(file); !bytes.Equal(got, testPNG) {
+		t.Errorf("expected the tEXt chunk to be removed")
+	}
+
+	// The stripped file no longer matches the CID, but is recognized through
+	// its recorded digest.
+	if _, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob); err != nil {
+		t.Fatalf("DownloadBlob failed: %v", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 download, got %d", n)
+	}
+
+	// A file modified afterwards is replaced.
+	os.WriteFile(file, data, 0644)
+	if _, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob); err != nil {
+		t.Fatalf("DownloadBlob failed: %v", err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected the modified file to be downloaded again, got %d requests", n)
+	}
+}
+
+func bytesOf(t *testing.T, path string) *bytes.Reader {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(data)
+}
+
+func TestDownloadBlob_Bundle(t *testing.T) {
+	blob := testBlob(t, testPNG, "image/png")
+	srv, requests := blobServer(testPNG)
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	indexPath := filepath.Join(t.TempDir(), "media.json")
+	var err error
+	if d.Index, err = LoadIndex(indexPath); err != nil {
+		t.Fatalf("LoadIndex failed: %v", err)
+	}
+
+	// The same blob in two bundles is stored in each of them.
+	root := t.TempDir()
+	for _, post := range []string{"first", "second"} {
+		bundle := filepath.Join(root, post)
+		stored, err := d.ForBundle(bundle).DownloadBlob(context.Background(), "did:plc:abc123", blob)
+		if err != nil {
+			t.Fatalf("DownloadBlob failed: %v", err)
+		}
+		if expected := blob.Ref.Link + ".png"; stored.Path != expected {
+			t.Errorf("expected relative path %s, got %s", expected, stored.Path)
+		}
+		if _, err := os.Stat(filepath.Join(bundle, stored.Path)); err != nil {
+			t.Errorf("expected the image in the bundle: %v", err)
+		}
+	}
+
+	// Both copies are indexed, so neither is downloaded again.
+	idx, err := LoadIndex(indexPath)
+	if err != nil {
+		t.Fatalf("LoadIndex failed: %v", err)
+	}
+	d.Index = idx
+	for _, post := range []string{"first", "second"} {
+		if _, err := d.ForBundle(filepath.Join(root, post)).DownloadBlob(context.Background(), "did:plc:abc123", blob); err != nil {
+			t.Fatalf("DownloadBlob failed: %v", err)
+		}
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected 2 downloads, got %d", n)
+	}
+	if _, ok := idx.Lookup(blob.Ref.Link); ok {
+		t.Error("expected bundle entries to be kept apart from images_dir entries")
+	}
+}
+
+func TestDownloadBlob_TooLarge(t *testing.T) {
+	srv, requests := blobServer(testPNG)
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		blob     atproto.Blob
+		requests int32
+	}{
+		{"declared size", testBlob(t, testPNG, "image/png"), 0},
+		{"unknown size", func() atproto.Blob { b := testBlob(t, testPNG, "image/png"); b.Size = 0; return b }(), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests.Store(0)
+			d := newTestDownloader(t, srv.URL)
+			d.MaxSize = int64(len(testPNG) - 1)
+			_, err := d.DownloadBlob(context.Background(), "did:plc:abc123", tt.blob)
+			if !errors.Is(err, ErrTooLarge) {
+				t.Fatalf("expected ErrTooLarge, got %v", err)
+			}
+			if n := requests.Load(); n != tt.requests {
+				t.Errorf("expected %d requests, got %d", tt.requests, n)
+			}
+			if entries, _ := os.ReadDir(imagesDir(d)); len(entries) != 0 {
+				t.Errorf("expected no files in the images dir, got %d", len(entries))
+			}
+		})
+	}
+}
+
+func TestDownloadBlob_Concurrent(t *testing.T) {
+	blob := testBlob(t, testPNG, "image/png")
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.Write(testPNG)
+	}))
+	defer srv.Close()
+	d := newTestDownloader(t, srv.URL)
+
+	var wg sync.WaitGroup
+	paths := make([]string, 8)
+	for i := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stored, err := d.DownloadBlob(context.Background(), "did:plc:abc123", blob)
+			if err != nil {
+				t.Errorf("DownloadBlob failed: %v", err)
+				return
+			}
+			paths[i] = stored.Path
+		}()
+	}
+	wg.Wait()
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected concurrent calls to share 1 download, got %d", n)
+	}
+	for _, p := range paths {
+		if p != paths[0] {
+			t.Errorf("expected the same path for every call, got %v", paths)
+			break
+		}
+	}
+}
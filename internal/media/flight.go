@@ -0,0 +1,41 @@
+package media
+
+import "sync"
+
+// flightGroup lets concurrent downloads of the same blob share one fetch.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done   chan struct{}
+	stored *Stored
+	err    error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flight)}
+}
+
+// do runs fn for key unless a call for key is already running, in which
+// case it waits for that call and returns its result.
+func (g *flightGroup) do(key string, fn func() (*Stored, error)) (*Stored, error) {
+	g.mu.Lock()
+	if f, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-f.done
+		return f.stored, f.err
+	}
+	f := &flight{done: make(chan struct{})}
+	g.calls[key] = f
+	g.mu.Unlock()
+
+	f.stored, f.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(f.done)
+	return f.stored, f.err
+}
@@ -0,0 +1,92 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"path"
+	"path/filepath"
+	"slices"
+)
+
+// CollectGarbage removes the files recorded in the index that are no longer
+// used, and forgets them. A stored blob is kept, with all its variants, as
+// long as referenced reports any of its file names as used. Files of
+// replaced entries are removed once nothing references them. Files of the
+// default scope are removed from storage, scoped ones from their directory.
+// Nothing else is touched, so files this tool did not create are safe.
+//
+// With dryRun, nothing is changed. It returns the paths, or URLs for remote
+// storage, of the files that were, or would be, removed.
+func (i *Index) CollectGarbage(ctx context.Context, storage Storage, referenced func(name string) bool, dryRun bool) ([]string, error) {
+	s := i.store()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed []string
+	var errs []error
+	remove := func(p string) {
+		st, name := storage, path.Base(p)
+		if dir := path.Dir(p); dir != "." {
+			st = &LocalStorage{Dir: filepath.FromSlash(dir)}
+		}
+		exists, err := st.Exists(ctx, name)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if !exists {
+			return
+		}
+		if ls, ok := st.(*LocalStorage); ok {
+			removed = append(removed, filepath.Join(ls.Dir, name))
+		} else {
+			removed = append(removed, st.URL(name))
+		}
+		if dryRun {
+			return
+		}
+		if err := st.Delete(ctx, name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	keys := make([]string, 0, len(s.entries))
+	for k := range s.entries {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		files := s.entries[k].Files()
+		if slices.ContainsFunc(files, referenced) {
+			continue
+		}
+		for _, f := range files {
+			remove(path.Join(path.Dir(k), f))
+		}
+		if !dryRun {
+			delete(s.entries, k)
+		}
+	}
+
+	retired := make([]string, 0, len(s.retired))
+	for p := range s.retired {
+		retired = append(retired, p)
+	}
+	slices.Sort(retired)
+	for _, p := range retired {
+		if referenced(path.Base(p)) {
+			continue
+		}
+		remove(p)
+		if !dryRun {
+			delete(s.retired, p)
+		}
+	}
+
+	if !dryRun {
+		if err := s.save(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return removed, errors.Join(errs...)
+}
@@ -0,0 +1,95 @@
+package media
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	dir := t.TempDir()
+	imagesDir := filepath.Join(dir, "images")
+	bundleDir := filepath.Join(dir, "posts", "hello")
+	for _, f := range []string{
+		filepath.Join(imagesDir, "used.png"),
+		filepath.Join(imagesDir, "used-100w.png"),
+		filepath.Join(imagesDir, "unused.png"),
+		filepath.Join(imagesDir, "unused-100w.png"),
+		filepath.Join(imagesDir, "old-200w.png"),
+		filepath.Join(imagesDir, "foreign.png"),
+		filepath.Join(bundleDir, "bundled.png"),
+	} {
+		os.MkdirAll(filepath.Dir(f), 0755)
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	indexPath := filepath.Join(dir, "media.json")
+	idx, err := LoadIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Add("used", Entry{File: "used.png", Variants: []Variant{{File: "old-200w.png"}}})
+	// Replacing the entry retires the old variant.
+	idx.Add("used", Entry{File: "used.png", Variants: []Variant{{File: "used-100w.png"}}})
+	idx.Add("unused", Entry{File: "unused.png", Variants: []Variant{{File: "unused-100w.png"}}})
+	idx.Scope(bundleDir).Add("bundled", Entry{File: "bundled.png"})
+
+	// Only the main file is referenced; its variant must be kept too.
+	referenced := func(name string) bool { return name == "used.png" }
+
+	removed, err := idx.CollectGarbage(context.Background(), &LocalStorage{Dir: imagesDir}, referenced, true)
+	if err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	expected := []string{
+		filepath.Join(bundleDir, "bundled.png"),
+		filepath.Join(imagesDir, "unused.png"),
+		filepath.Join(imagesDir, "unused-100w.png"),
+		filepath.Join(imagesDir, "old-200w.png"),
+	}
+	if len(removed) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, removed)
+	}
+	for i := range expected {
+		if removed[i] != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], removed[i])
+		}
+	}
+	for _, f := range expected {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("expected dry run to keep %s: %v", f, err)
+		}
+	}
+
+	if _, err := idx.CollectGarbage(context.Background(), &LocalStorage{Dir: imagesDir}, referenced, false); err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	for _, f := range expected {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", f)
+		}
+	}
+	for _, f := range []string{"used.png", "used-100w.png", "foreign.png"} {
+		if _, err := os.Stat(filepath.Join(imagesDir, f)); err != nil {
+			t.Errorf("expected %s to be kept: %v", f, err)
+		}
+	}
+
+	// The removals are persisted.
+	idx, err = LoadIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := idx.Lookup("unused"); ok {
+		t.Error("expected the unused entry to be forgotten")
+	}
+	if _, ok := idx.Lookup("used"); !ok {
+		t.Error("expected the used entry to be kept")
+	}
+	if removed, _ := idx.CollectGarbage(context.Background(), &LocalStorage{Dir: imagesDir}, referenced, true); len(removed) != 0 {
+		t.Errorf("expected nothing left to collect, got %v", removed)
+	}
+}
@@ -0,0 +1,197 @@
+package media
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Index maps blob CIDs to the files they are stored as, so cached blobs are
+// found without guessing extensions. It also records which files this tool
+// created.
+type Index struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]Entry
+	// retired holds files of replaced or removed entries, keyed like
+	// entries, until they are garbage collected.
+	retired map[string]bool
+	// root and scope are set on views returned by Scope.
+	root  *Index
+	scope string
+}
+
+// Entry describes how a blob is stored.
+type Entry struct {
+	File string `json:"file"`
+	// SHA256 is the hex digest of File if processing changed it, so it no
+	// longer matches the blob CID.
+	SHA256 string `json:"sha256,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	// Processing identifies the Processor settings the file was made with.
+	Processing string    `json:"processing,omitempty"`
+	Variants   []Variant `json:"variants,omitempty"`
+}
+
+// Variant is a resized copy of an image.
+type Variant struct {
+	File   string `json:"file"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Files returns all files of the entry.
+func (e Entry) Files() []string {
+	files := []string{e.File}
+	for _, v := range e.Variants {
+		files = append(files, v.File)
+	}
+	return files
+}
+
+// indexFile is the on-disk format of an Index.
+type indexFile struct {
+	Blobs   map[string]Entry `json:"blobs"`
+	Retired []string         `json:"retired,omitempty"`
+}
+
+// NewIndex returns an empty index that is not persisted.
+func NewIndex() *Index {
+	return &Index{entries: make(map[string]Entry), retired: make(map[string]bool)}
+}
+
+// LoadIndex loads the index at path. A missing file is an empty index.
+// Changes are written back to path.
+func LoadIndex(path string) (*Index, error) {
+	idx := NewIndex()
+	idx.path = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored indexFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if stored.Blobs != nil {
+		idx.entries = stored.Blobs
+	}
+	for _, key := range stored.Retired {
+		idx.retired[key] = true
+	}
+	return idx, nil
+}
+
+// Scope returns a view of the index for blobs stored in dir rather than the
+// default directory, e.g. a page bundle. Its entries are kept in the same
+// file, keyed by <dir>/<cid>.
+func (i *Index) Scope(dir string) *Index {
+	return &Index{root: i.store(), scope: i.key(filepath.ToSlash(filepath.Clean(dir)))}
+}
+
+// store returns the index that holds the entries.
+func (i *Index) store() *Index {
+	if i.root != nil {
+		return i.root
+	}
+	return i
+}
+
+func (i *Index) key(cid string) string {
+	if i.scope == "" {
+		return cid
+	}
+	return i.scope + "/" + cid
+}
+
+// Lookup returns the entry stored for cid.
+func (i *Index) Lookup(cid string) (Entry, bool) {
+	s := i.store()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[i.key(cid)]
+	return e, ok
+}
+
+// Add records how cid is stored.
+func (i *Index) Add(cid string, e Entry) error {
+	s := i.store()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := i.key(cid)
+	s.retire(k, s.entries[k], e)
+	s.entries[k] = e
+	return s.save()
+}
+
+// Remove forgets cid.
+func (i *Index) Remove(cid string) error {
+	s := i.store()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := i.key(cid)
+	s.retire(k, s.entries[k], Entry{})
+	delete(s.entries, k)
+	return s.save()
+}
+
+// retire records the files of old that are not part of its replacement. The
+// caller must hold mu.
+func (i *Index) retire(key string, old, replacement Entry) {
+	dir := path.Dir(key)
+	for _, f := range old.Files() {
+		if f != "" {
+			i.retired[path.Join(dir, f)] = true
+		}
+	}
+	for _, f := range replacement.Files() {
+		delete(i.retired, path.Join(dir, f))
+	}
+}
+
+// save writes the index atomically. The caller must hold mu.
+func (i *Index) save() error {
+	if i.path == "" {
+		return nil
+	}
+	stored := indexFile{Blobs: i.entries}
+	for key := range i.retired {
+		stored.Retired = append(stored.Retired, key)
+	}
+	slices.Sort(stored.Retired)
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(i.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(i.path), ".media-*.json")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), i.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,480 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"strings"
+)
+
+// errMalformed is returned for image files whose container can't be parsed.
+var errMalformed = errors.New("malformed image")
+
+// stripJPEG removes EXIF, XMP, IPTC, MPF and comment segments from a JPEG
+// without re-encoding it, as well as anything after the end of the image,
+// such as the secondary images and gain maps of MPF files, which carry EXIF
+// data of their own. It also returns the EXIF orientation (1 if absent),
+// which is lost with the EXIF data and has to be applied to the pixels
+// instead.
+func stripJPEG(data []byte) ([]byte, int, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, fmt.Errorf("%w: missing JPEG SOI marker", errMalformed)
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	orientation := 1
+
+	pos := 2
+	for {
+		if pos >= len(data) || data[pos] != 0xFF {
+			return nil, 0, fmt.Errorf("%w: bad JPEG segment at offset %d", errMalformed, pos)
+		}
+		// Markers may be preceded by any number of fill bytes.
+		for pos < len(data) && data[pos] == 0xFF {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, 0, fmt.Errorf("%w: truncated JPEG", errMalformed)
+		}
+		marker := data[pos]
+		pos++
+
+		switch {
+		case marker == 0xD9: // EOI
+			out.Write([]byte{0xFF, marker})
+			return out.Bytes(), orientation, nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // no payload
+			out.Write([]byte{0xFF, marker})
+			continue
+		}
+
+		if pos+2 > len(data) {
+			return nil, 0, fmt.Errorf("%w: truncated JPEG", errMalformed)
+		}
+		length := int(binary.BigEndian.Uint16(data[pos:]))
+		end := pos + length
+		if length < 2 || end > len(data) {
+			return nil, 0, fmt.Errorf("%w: bad JPEG segment length", errMalformed)
+		}
+		payload := data[pos+2 : end]
+
+		switch marker {
+		case 0xDA: // SOS: entropy-coded data follows up to the next marker
+			scan := entropyEnd(data, end)
+			out.Write([]byte{0xFF, marker})
+			out.Write(data[pos:scan])
+			if scan == len(data) {
+				// Truncated, but decoders cope with that.
+				return out.Bytes(), orientation, nil
+			}
+			pos = scan
+			continue
+		case 0xE1: // APP1: EXIF or XMP
+			if bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				if o := exifOrientation(payload[6:]); o != 0 {
+					orientation = o
+				}
+			}
+		case 0xE2: // APP2: ICC profile or MPF index
+			if !bytes.HasPrefix(payload, []byte("MPF\x00")) {
+				out.Write([]byte{0xFF, marker})
+				out.Write(data[pos:end])
+			}
+		case 0xED, 0xFE: // APP13 (IPTC), COM
+		default:
+			out.Write([]byte{0xFF, marker})
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+}
+
+// entropyEnd returns the offset of the first marker at or after pos in
+// entropy-coded JPEG data, or len(data). Stuffed zero bytes and restart
+// markers are part of the data.
+func entropyEnd(data []byte, pos int) int {
+	for ; pos+1 < len(data); pos++ {
+		if data[pos] != 0xFF {
+			continue
+		}
+		if next := data[pos+1]; next != 0x00 && next != 0xFF && (next < 0xD0 || next > 0xD7) {
+			return pos
+		}
+	}
+	return len(data)
+}
+
+// exifOrientation reads the orientation tag from a TIFF-structured EXIF
+// block, or returns 0.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// pngMetadataChunks are removed by stripPNG.
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// stripPNG removes EXIF and text chunks from a PNG.
+func stripPNG(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, fmt.Errorf("%w: missing PNG signature", errMalformed)
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.WriteString(signature)
+
+	for pos := len(signature); pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, fmt.Errorf("%w: truncated PNG chunk", errMalformed)
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, fmt.Errorf("%w: bad PNG chunk length", errMalformed)
+		}
+		if !pngMetadataChunks[string(data[pos+4:pos+8])] {
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+	return out.Bytes(), nil
+}
+
+// stripWebP removes EXIF and XMP chunks from a WebP and clears their flags
+// in the VP8X header.
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("%w: missing WebP header", errMalformed)
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+
+	for pos := 12; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, fmt.Errorf("%w: truncated WebP chunk", errMalformed)
+		}
+		fourcc := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size + size%2
+		if size < 0 || end > len(data) {
+			return nil, fmt.Errorf("%w: bad WebP chunk size", errMalformed)
+		}
+		switch fourcc {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := bytes.Clone(data[pos:end])
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP flags
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+
+	stripped := out.Bytes()
+	binary.LittleEndian.PutUint32(stripped[4:], uint32(len(stripped)-8))
+	return stripped, nil
+}
+
+// stripHEIF removes the EXIF and XMP items of a HEIF image (HEIC, AVIF).
+// Item data is addressed by file offsets, so instead of cutting it out, it
+// is overwritten with zeros in a copy of data, which readers skip as
+// invalid metadata.
+func stripHEIF(data []byte) ([]byte, error) {
+	top, err := isoBoxes(data, 0, len(data))
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := top["meta"]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing HEIF meta box", errMalformed)
+	}
+	// meta is a full box: version and flags precede its children.
+	boxes, err := isoBoxes(data, meta.body+4, meta.end)
+	if err != nil {
+		return nil, err
+	}
+	iinf, ok := boxes["iinf"]
+	if !ok {
+		return data, nil
+	}
+	items, err := heifMetadataItems(data, iinf)
+	if err != nil || len(items) == 0 {
+		return data, err
+	}
+	iloc, ok := boxes["iloc"]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing HEIF iloc box", errMalformed)
+	}
+
+	out := bytes.Clone(data)
+	r := &boxReader{data: data, pos: iloc.body, end: iloc.end}
+	version := r.uint(1)
+	r.uint(3) // flags
+	sizes := r.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0x0F)
+	sizes = r.uint(1)
+	baseSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0x0F)
+	}
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+	count := r.uint(idSize)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		id := r.uint(idSize)
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = r.uint(2) & 0x0F
+		}
+		r.uint(2) // data reference index
+		base := r.uint(baseSize)
+		extents := r.uint(2)
+		for e := uint64(0); e < extents && r.err == nil; e++ {
+			r.uint(indexSize)
+			offset, length := r.uint(offsetSize), r.uint(lengthSize)
+			if !items[id] {
+				continue
+			}
+			var origin uint64
+			switch method {
+			case 0: // file offset
+			case 1: // offset into the idat box
+				idat, ok := boxes["idat"]
+				if !ok {
+					return nil, fmt.Errorf("%w: missing HEIF idat box", errMalformed)
+				}
+				origin = uint64(idat.body)
+			default:
+				return nil, fmt.Errorf("%w: unsupported HEIF item construction method %d", errMalformed, method)
+			}
+			start := origin + base + offset
+			if length == 0 || start > uint64(len(out)) || length > uint64(len(out))-start {
+				return nil, fmt.Errorf("%w: bad HEIF item extent", errMalformed)
+			}
+			clear(out[start : start+length])
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return out, nil
+}
+
+// heifMetadataItems returns the IDs of the EXIF and XMP items listed in an
+// iinf box.
+func heifMetadataItems(data []byte, iinf isoBox) (map[uint64]bool, error) {
+	r := &boxReader{data: data, pos: iinf.body, end: iinf.end}
+	if r.uint(1) == 0 {
+		r.uint(3)
+		r.uint(2) // entry count
+	} else {
+		r.uint(3)
+		r.uint(4)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	entries, err := isoBoxList(data, r.pos, iinf.end)
+	if err != nil {
+		return nil, err
+	}
+	items := map[uint64]bool{}
+	for _, infe := range entries {
+		r := &boxReader{data: data, pos: infe.body, end: infe.end}
+		version := r.uint(1)
+		r.uint(3)
+		if infe.typ != "infe" || version < 2 {
+			continue
+		}
+		idSize := 2
+		if version == 3 {
+			idSize = 4
+		}
+		id := r.uint(idSize)
+		r.uint(2) // protection index
+		switch r.fourCC() {
+		case "Exif":
+			items[id] = true
+		case "mime":
+			r.string() // item name
+			if strings.HasPrefix(r.string(), "application/rdf+xml") {
+				items[id] = true
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	return items, nil
+}
+
+// isoBox is an ISO base media file format box.
+type isoBox struct {
+	typ       string
+	body, end int
+}
+
+// isoBoxList parses the boxes in data[pos:end].
+func isoBoxList(data []byte, pos, end int) ([]isoBox, error) {
+	var boxes []isoBox
+	for pos < end {
+		if pos+8 > end {
+			return nil, fmt.Errorf("%w: truncated box", errMalformed)
+		}
+		size := uint64(binary.BigEndian.Uint32(data[pos:]))
+		box := isoBox{typ: string(data[pos+4 : pos+8]), body: pos + 8}
+		switch size {
+		case 0: // to the end
+			size = uint64(end - pos)
+		case 1: // 64-bit size
+			if pos+16 > end {
+				return nil, fmt.Errorf("%w: truncated box", errMalformed)
+			}
+			size = binary.BigEndian.Uint64(data[pos+8:])
+			box.body = pos + 16
+		}
+		if size < uint64(box.body-pos) || size > uint64(end-pos) {
+			return nil, fmt.Errorf("%w: bad %q box size", errMalformed, box.typ)
+		}
+		box.end = pos + int(size)
+		boxes = append(boxes, box)
+		pos = box.end
+	}
+	return boxes, nil
+}
+
+// isoBoxes is like isoBoxList, keyed by the first box of each type.
+func isoBoxes(data []byte, pos, end int) (map[string]isoBox, error) {
+	list, err := isoBoxList(data, pos, end)
+	if err != nil {
+		return nil, err
+	}
+	boxes := map[string]isoBox{}
+	for _, b := range list {
+		if _, ok := boxes[b.typ]; !ok {
+			boxes[b.typ] = b
+		}
+	}
+	return boxes, nil
+}
+
+// boxReader reads big-endian fields from a box body. After the first read
+// past the end, err is set and reads return zero values.
+type boxReader struct {
+	data     []byte
+	pos, end int
+	err      error
+}
+
+// uint reads an n-byte unsigned integer; n is at most 8.
+func (r *boxReader) uint(n int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if n > 8 || r.pos+n > r.end {
+		r.err = fmt.Errorf("%w: truncated box", errMalformed)
+		return 0
+	}
+	var v uint64
+	for _, b := range r.data[r.pos : r.pos+n] {
+		v = v<<8 | uint64(b)
+	}
+	r.pos += n
+	return v
+}
+
+func (r *boxReader) fourCC() string {
+	return string(binary.BigEndian.AppendUint32(nil, uint32(r.uint(4))))
+}
+
+// string reads a null-terminated string.
+func (r *boxReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.data[r.pos:r.end], 0)
+	if i < 0 {
+		r.err = fmt.Errorf("%w: unterminated string", errMalformed)
+		return ""
+	}
+	s := string(r.data[r.pos : r.pos+i])
+	r.pos += i + 1
+	return s
+}
+
+// applyOrientation transforms img according to an EXIF orientation value.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotated 90° counter-clockwise
+				sx, sy = w-1-y, x
+			}
+			dst.SetNRGBA(x, y, src.NRGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,102 @@
+package media
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+)
+
+// extensions maps the media types we publish to file extensions.
+var extensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+	"image/gif":       ".gif",
+	"image/avif":      ".avif",
+	"image/heic":      ".heic",
+	"image/heif":      ".heif",
+	"image/svg+xml":   ".svg",
+	"image/bmp":       ".bmp",
+	"image/tiff":      ".tif",
+	"video/mp4":       ".mp4",
+	"video/quicktime": ".mov",
+	"video/webm":      ".webm",
+	"audio/mpeg":      ".mp3",
+	"audio/mp4":       ".m4a",
+	"audio/ogg":       ".ogg",
+	"audio/wave":      ".wav",
+	"application/pdf": ".pdf",
+}
+
+// ExtensionFor returns the file extension for a media type, or "" if the
+// type is not supported.
+func ExtensionFor(mediaType string) string {
+	t, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return ""
+	}
+	switch t {
+	case "image/jpg", "image/pjpeg":
+		t = "image/jpeg"
+	case "audio/wav", "audio/x-wav":
+		t = "audio/wave"
+	}
+	return extensions[t]
+}
+
+// ftypBrands maps ISO base media file brands to media types that
+// http.DetectContentType does not know.
+var ftypBrands = map[string]string{
+	"avif": "image/avif",
+	"avis": "image/avif",
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"heim": "image/heic",
+	"heis": "image/heic",
+	"mif1": "image/heif",
+	"msf1": "image/heif",
+	"qt  ": "video/quicktime",
+	"M4A ": "audio/mp4",
+	"M4B ": "audio/mp4",
+	"M4P ": "audio/mp4",
+}
+
+// SniffType returns the media type of content starting with head. It extends
+// http.DetectContentType with AVIF, HEIC/HEIF, QuickTime and SVG.
+func SniffType(head []byte) string {
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		if t, ok := ftypBrands[string(head[8:12])]; ok {
+			return t
+		}
+	}
+
+	t := http.DetectContentType(head)
+	if base, _, _ := mime.ParseMediaType(t); base == "text/xml" || base == "text/plain" {
+		if bytes.Contains(bytes.ToLower(head), []byte("<svg")) {
+			return "image/svg+xml"
+		}
+	}
+	return t
+}
+
+// sameMediaType reports whether two media types describe the same format.
+// HEIC is a HEIF profile and files are often branded either way. Likewise
+// audio-only MP4 files are often branded like videos, so the MP4 container
+// matches both audio/mp4 and video/mp4.
+func sameMediaType(a, b string) bool {
+	ea, eb := containerExtension(a), containerExtension(b)
+	return ea != "" && ea == eb
+}
+
+// containerExtension returns the extension of mediaType, the same for
+// formats sharing a container.
+func containerExtension(mediaType string) string {
+	switch ext := ExtensionFor(mediaType); ext {
+	case ".heif":
+		return ".heic"
+	case ".m4a":
+		return ".mp4"
+	default:
+		return ext
+	}
+}
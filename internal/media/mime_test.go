@@ -0,0 +1,68 @@
+package media
+
+import "testing"
+
+func TestSniffType(t *testing.T) {
+	tests := []struct {
+		name     string
+		head     string
+		expected string
+	}{
+		{"avif", "\x00\x00\x00\x1cftypavif\x00\x00\x00\x00", "image/avif"},
+		{"heic", "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00", "image/heic"},
+		{"mp4", "\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom", "video/mp4"},
+		{"m4a", "\x00\x00\x00\x1cftypM4A \x00\x00\x00\x00M4A mp42isom", "audio/mp4"},
+		{"pdf", "%PDF-1.7\n", "application/pdf"},
+		{"svg", "<?xml version=\"1.0\"?>\n<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>", "image/svg+xml"},
+		{"bare svg", "<svg viewBox=\"0 0 10 10\"></svg>", "image/svg+xml"},
+		{"png", "\x89PNG\r\n\x1a\n", "image/png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SniffType([]byte(tt.head)); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestExtensionFor(t *testing.T) {
+	tests := map[string]string{
+		"image/jpeg":               ".jpg",
+		"image/jpg":                ".jpg",
+		"image/avif":               ".avif",
+		"image/heic":               ".heic",
+		"image/svg+xml":            ".svg",
+		"video/mp4":                ".mp4",
+		"application/pdf":          ".pdf",
+		"text/html; charset=utf8":  "",
+		"application/octet-stream": "",
+		"":                         "",
+	}
+	for mediaType, expected := range tests {
+		if got := ExtensionFor(mediaType); got != expected {
+			t.Errorf("%q: expected %q, got %q", mediaType, expected, got)
+		}
+	}
+}
+
+func TestSameMediaType(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"image/heic", "image/heif", true},
+		{"video/mp4", "audio/mp4", true},
+		{"audio/mp4", "audio/mp4", true},
+		{"video/mp4", "video/quicktime", false},
+		{"audio/mpeg", "audio/mp4", false},
+		{"application/x-unknown", "application/x-unknown", false},
+	}
+
+	for _, tt := range tests {
+		if got := sameMediaType(tt.a, tt.b); got != tt.expected {
+			t.Errorf("sameMediaType(%s, %s): expected %v, got %v", tt.a, tt.b, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime"
+	"slices"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// DefaultQuality is the JPEG quality used when Processor.Quality is unset.
+const DefaultQuality = 85
+
+// Processor post-processes downloaded images: it strips metadata such as
+// EXIF/GPS, records pixel dimensions and renders resized variants. Formats
+// Go can't decode are only stripped (AVIF, HEIC) or stored as they are
+// (SVG, BMP); TIFF images are refused unless metadata is kept. SVG images
+// that could run scripts are refused by the Downloader before this.
+type Processor struct {
+	// Widths are the variant widths to render. Widths not smaller than the
+	// image are skipped.
+	Widths []int
+	// Quality is the JPEG quality of re-encoded images.
+	Quality int
+	// KeepMetadata disables metadata stripping.
+	KeepMetadata bool
+}
+
+// processed is the result of processing an image.
+type processed struct {
+	// data replaces the stored content; nil if it is unchanged.
+	data          []byte
+	width, height int
+	variants      []encodedVariant
+}
+
+type encodedVariant struct {
+	width, height int
+	ext           string
+	data          []byte
+}
+
+// fingerprint identifies the settings an image was processed with, so
+// changed settings cause it to be processed again.
+func (p *Processor) fingerprint() string {
+	if p == nil {
+		return ""
+	}
+	widths := make([]string, len(p.Widths))
+	for i, w := range p.Widths {
+		widths[i] = fmt.Sprint(w)
+	}
+	return fmt.Sprintf("widths=%s quality=%d keep_metadata=%v", strings.Join(widths, ","), p.quality(), p.KeepMetadata)
+}
+
+func (p *Processor) quality() int {
+	if p.Quality <= 0 {
+		return DefaultQuality
+	}
+	return p.Quality
+}
+
+// ErrMetadata is returned for images whose metadata can't be stripped.
+var ErrMetadata = errors.New("cannot strip image metadata")
+
+// processable reports whether process does anything with files of the
+// given media type; other files are stored as they are.
+func processable(mediaType string) bool {
+	switch base, _, _ := mime.ParseMediaType(mediaType); base {
+	case "image/jpeg", "image/png", "image/webp", "image/gif",
+		"image/heic", "image/heif", "image/avif", "image/tiff":
+		return true
+	}
+	return false
+}
+
+// process processes data of the given media type.
+func (p *Processor) process(data []byte, mediaType string) (*processed, error) {
+	base, _, _ := mime.ParseMediaType(mediaType)
+	res := &processed{}
+
+	// EXIF orientation is needed even when metadata is kept, as resized
+	// variants carry no EXIF data.
+	orientation := 1
+	stored := data
+	var err error
+	switch base {
+	case "image/jpeg":
+		var stripped []byte
+		if stripped, orientation, err = stripJPEG(data); err != nil {
+			return nil, err
+		}
+		if !p.KeepMetadata {
+			stored = stripped
+		}
+	case "image/png":
+		if !p.KeepMetadata {
+			if stored, err = stripPNG(data); err != nil {
+				return nil, err
+			}
+		}
+	case "image/webp":
+		if !p.KeepMetadata {
+			if stored, err = stripWebP(data); err != nil {
+				return nil, err
+			}
+		}
+	case "image/gif":
+	case "image/heic", "image/heif", "image/avif":
+		if !p.KeepMetadata {
+			if stored, err = stripHEIF(data); err != nil {
+				return nil, err
+			}
+		}
+		if !bytes.Equal(stored, data) {
+			res.data = stored
+		}
+		return res, nil
+	case "image/tiff":
+		if !p.KeepMetadata {
+			return nil, fmt.Errorf("%w from TIFF images; convert the image or set images.keep_metadata", ErrMetadata)
+		}
+		return res, nil
+	default:
+		return res, nil
+	}
+
+	decode := map[string]func(*bytes.Reader) (image.Image, error){
+		"image/jpeg": func(r *bytes.Reader) (image.Image, error) { return jpeg.Decode(r) },
+		"image/png":  func(r *bytes.Reader) (image.Image, error) { return png.Decode(r) },
+		"image/gif":  func(r *bytes.Reader) (image.Image, error) { return gif.Decode(r) },
+		"image/webp": func(r *bytes.Reader) (image.Image, error) { return webp.Decode(r) },
+	}[base]
+
+	needPixels := (orientation != 1 && !p.KeepMetadata) || (len(p.Widths) > 0 && base != "image/gif")
+	if !needPixels {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("reading image size: %w", err)
+		}
+		res.width, res.height = cfg.Width, cfg.Height
+		if orientation >= 5 {
+			res.width, res.height = cfg.Height, cfg.Width
+		}
+		if !bytes.Equal(stored, data) {
+			res.data = stored
+		}
+		return res, nil
+	}
+
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+	img = applyOrientation(img, orientation)
+	res.width, res.height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	if orientation != 1 && !p.KeepMetadata {
+		// The orientation went with the EXIF data; bake it into the pixels.
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: p.quality()}); err != nil {
+			return nil, err
+		}
+		stored = buf.Bytes()
+	}
+	if !bytes.Equal(stored, data) {
+		res.data = stored
+	}
+
+	if base == "image/gif" {
+		// Resizing would drop the animation.
+		return res, nil
+	}
+	widths := slices.Clone(p.Widths)
+	slices.Sort(widths)
+	for _, w := range slices.Compact(widths) {
+		if w <= 0 || w >= res.width {
+			continue
+		}
+		v, err := p.resize(img, w, base)
+		if err != nil {
+			return nil, err
+		}
+		res.variants = append(res.variants, v)
+	}
+	return res, nil
+}
+
+// resize renders img at width w. JPEG and opaque WebP images become JPEGs,
+// everything else PNGs.
+func (p *Processor) resize(img image.Image, w int, mediaType string) (encodedVariant, error) {
+	b := img.Bounds()
+	h := max(1, (b.Dy()*w+b.Dx()/2)/b.Dx())
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, b, xdraw.Src, nil)
+
+	v := encodedVariant{width: w, height: h}
+	var buf bytes.Buffer
+	opaque := mediaType == "image/jpeg"
+	if o, ok := img.(interface{ Opaque() bool }); ok && mediaType == "image/webp" {
+		opaque = o.Opaque()
+	}
+	if opaque {
+		v.ext = ".jpg"
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: p.quality()}); err != nil {
+			return v, err
+		}
+	} else {
+		v.ext = ".png"
+		if err := png.Encode(&buf, dst); err != nil {
+			return v, err
+		}
+	}
+	v.data = buf.Bytes()
+	return v, nil
+}
@@ -0,0 +1,217 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// withPNGChunk inserts a chunk right after the IHDR chunk of a PNG.
+func withPNGChunk(data []byte, typ string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, payload...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	ihdrEnd := 8 + 12 + 13
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+// encodeJPEG returns a w×h JPEG with an EXIF segment carrying orientation.
+func encodeJPEG(t *testing.T, w, h, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// A little-endian TIFF header with a single IFD entry.
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3) // SHORT
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(payload)+2))
+	app1 = append(app1, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func TestProcess_JPEGOrientation(t *testing.T) {
+	data := encodeJPEG(t, 40, 20, 6)
+
+	res, err := (&Processor{}).process(data, "image/jpeg")
+	if err != nil {
+		t.Fatalf("process failed: %v", err)
+	}
+	if res.width != 20 || res.height != 40 {
+		t.Errorf("expected the image to be rotated to 20x40, got %dx%d", res.width, res.height)
+	}
+	if res.data == nil {
+		t.Fatal("expected the image to be rewritten")
+	}
+	if bytes.Contains(res.data, []byte("Exif\x00\x00")) {
+		t.Error("expected the EXIF segment to be removed")
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(res.data))
+	if err != nil || cfg.Width != 20 || cfg.Height != 40 {
+		t.Errorf("expected a 20x40 JPEG, got %dx%d (%v)", cfg.Width, cfg.Height, err)
+	}
+
+	res, err = (&Processor{KeepMetadata: true}).process(data, "image/jpeg")
+	if err != nil {
+		t.Fatalf("process failed: %v", err)
+	}
+	if res.data != nil {
+		t.Error("expected the image to be kept as it is")
+	}
+	if res.width != 20 || res.height != 40 {
+		t.Errorf("expected displayed size 20x40, got %dx%d", res.width, res.height)
+	}
+}
+
+func TestProcess_StripsWithoutReencoding(t *testing.T) {
+	data := encodeJPEG(t, 40, 20, 1)
+
+	res, err := (&Processor{}).process(data, "image/jpeg")
+	if err != nil {
+		t.Fatalf("process failed: %v", err)
+	}
+	stripped, _, _ := stripJPEG(data)
+	if !bytes.Equal(res.data, stripped) || len(stripped) >= len(data) {
+		t.Error("expected only the EXIF segment to be removed")
+	}
+}
+
+func TestProcess_JPEGTrailingData(t *testing.T) {
+	data := encodeJPEG(t, 40, 20, 1)
+	// An MPF index, and a secondary image with its own EXIF after EOI.
+	mpf := append([]byte{0xFF, 0xE2, 0x00, 0x0A}, "MPF\x00II*\x00"...)
+	data = append(append(append([]byte{}, data[:2]...), mpf...), data[2:]...)
+	secondary := encodeJPEG(t, 8, 8, 1)
+	data = append(data, append(secondary[:2], []byte("\xFF\xE1\x00\x08Exif\x00\x00GPS")...)...)
+
+	res, err := (&Processor{}).process(data, "image/jpeg")
+	if err != nil {
+		t.Fatalf("process failed: %v", err)
+	}
+	if bytes.Contains(res.data, []byte("Exif\x00\x00")) || bytes.Contains(res.data, []byte("MPF\x00")) {
+		t.Error("expected EXIF and MPF data to be removed")
+	}
+	if !bytes.HasSuffix(res.data, []byte{0xFF, 0xD9}) {
+		t.Error("expected the data after the end of the image to be dropped")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(res.data)); err != nil {
+		t.Errorf("expected a valid JPEG: %v", err)
+	}
+}
+
+// box returns an ISO base media file format box.
+func box(typ string, body ...[]byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(bytes.Join(body, nil))))
+	b = append(b, typ...)
+	return append(b, bytes.Join(body, nil)...)
+}
+
+// encodeHEIC returns a HEIC skeleton whose item 1 is an EXIF block in mdat
+// and item 2 an image.
+func encodeHEIC(exif []byte) []byte {
+	ftyp := box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	infe := func(id uint16, typ string) []byte {
+		return box("infe", []byte{2, 0, 0, 0}, binary.BigEndian.AppendUint16(nil, id), []byte{0, 0}, []byte(typ), []byte{0})
+	}
+	iinf := box("iinf", []byte{0, 0, 0, 0, 0, 2}, infe(1, "Exif"), infe(2, "hvc1"))
+	iloc := func(offset uint32) []byte {
+		body := []byte{0, 0, 0, 0, 0x44, 0x00, 0, 2}
+		for i, length := range []int{len(exif), 4} {
+			body = binary.BigEndian.AppendUint16(body, uint16(i+1))
+			body = append(body, 0, 0, 0, 1)
+			body = binary.BigEndian.AppendUint32(body, offset+uint32(i*len(exif)))
+			body = binary.BigEndian.AppendUint32(body, uint32(length))
+		}
+		return box("iloc", body)
+	}
+	meta := func(offset uint32) []byte { return box("meta", []byte{0, 0, 0, 0}, iinf, iloc(offset)) }
+	offset := uint32(len(ftyp) + len(meta(0)) + 8)
+	return bytes.Join([][]byte{ftyp, meta(offset), box("mdat", exif, []byte("HEVC"))}, nil)
+}
+
+func TestProcess_HEIC(t *testing.T) {
+	exif := []byte("\x00\x00\x00\x00MM\x00*GPS 52.52N 13.40E")
+	data := encodeHEIC(exif)
+
+	res, err := (&Processor{}).process(data, "image/heic")
+	if err != nil {
+		t.Fatalf("process failed: %v", err)
+	}
+	if res.data == nil || len(res.data) != len(data) {
+		t.Fatal("expected the image to be rewritten in place")
+	}
+	if bytes.Contains(res.data, []byte("GPS")) {
+		t.Error("expected the EXIF item to be cleared")
+	}
+	if !bytes.Contains(res.data, []byte("HEVC")) {
+		t.Error("expected the image item to be kept")
+	}
+
+	if res, err = (&Processor{KeepMetadata: true}).process(data, "image/heic"); err != nil || res.data != nil {
+		t.Errorf("expected the image to be kept as it is, got %v", err)
+	}
+}
+
+func TestProcess_TIFF(t *testing.T) {
+	if _, err := (&Processor{}).process([]byte("II*\x00"), "image/tiff"); !errors.Is(err, ErrMetadata) {
+		t.Errorf("expected ErrMetadata, got %v", err)
+	}
+	if _, err := (&Processor{KeepMetadata: true}).process([]byte("II*\x00"), "image/tiff"); err != nil {
+		t.Errorf("expected TIFFs to be kept with keep_metadata, got %v", err)
+	}
+}
+
+func TestProcess_Unsupported(t *testing.T) {
+	res, err := (&Processor{Widths: []int{100}}).process([]byte("<svg/>"), "image/svg+xml")
+	if err != nil {
+		t.Fatalf("process failed: %v", err)
+	}
+	if res.data != nil || res.width != 0 || len(res.variants) != 0 {
+		t.Errorf("expected SVGs to be left alone, got %+v", res)
+	}
+}
+
+func TestProcessable(t *testing.T) {
+	for mediaType, expected := range map[string]bool{
+		"image/jpeg":    true,
+		"image/png":     true,
+		"image/webp":    true,
+		"image/gif":     true,
+		"image/svg+xml": false,
+		"image/avif":    true,
+		"image/heic":    true,
+		"image/tiff":    true,
+		"video/mp4":     false,
+		"audio/mpeg":    false,
+	} {
+		if got := processable(mediaType); got != expected {
+			t.Errorf("processable(%s): expected %v, got %v", mediaType, expected, got)
+		}
+	}
+}
+
+func TestProcess_Malformed(t *testing.T) {
+	if _, err := (&Processor{}).process([]byte("\x89PNG\r\n\x1a\nnope"), "image/png"); err == nil {
+		t.Error("expected error for a malformed PNG, got nil")
+	}
+}
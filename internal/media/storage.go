@@ -0,0 +1,82 @@
+package media
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Storage is where downloaded blobs are published. Names are flat file
+// names like "<cid>.jpg".
+type Storage interface {
+	// Put stores size bytes from r under name, replacing existing content.
+	// Readers must never see partial content.
+	Put(ctx context.Context, name string, r io.Reader, size int64, mediaType string) error
+	// Exists reports whether name is stored.
+	Exists(ctx context.Context, name string) (bool, error)
+	// URL returns the URL posts reference name by.
+	URL(name string) string
+	// Delete removes name. Deleting a missing name is not an error.
+	Delete(ctx context.Context, name string) error
+}
+
+// Opener is implemented by storages that can read content back cheaply, so
+// stored files are verified before they are reused.
+type Opener interface {
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// LocalStorage stores blobs in a directory, e.g. Hugo's static dir or a
+// page bundle.
+type LocalStorage struct {
+	Dir string
+	// URLPrefix is prepended to names in URLs; if empty, URLs are relative.
+	URLPrefix string
+}
+
+func (s *LocalStorage) Put(ctx context.Context, name string, r io.Reader, size int64, mediaType string) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.Dir, "."+name+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(s.Dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (s *LocalStorage) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := os.Stat(filepath.Join(s.Dir, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *LocalStorage) URL(name string) string {
+	return path.Join(s.URLPrefix, name)
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, name string) error {
+	err := os.Remove(filepath.Join(s.Dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.Dir, name))
+}
@@ -0,0 +1,79 @@
+package media
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// ErrUnsafeSVG is returned for SVG images that could run scripts. Stored
+// files are served from the site's origin, so they are not published.
+var ErrUnsafeSVG = errors.New("SVG image may run scripts")
+
+// activeElements are SVG elements that run scripts or embed HTML.
+var activeElements = []string{"script", "foreignobject", "iframe", "embed", "object", "handler", "listener"}
+
+// checkSVG returns an error wrapping ErrUnsafeSVG if the SVG document read
+// from r has scripts, event handler attributes, javascript: URLs or entity
+// declarations, or can't be parsed to tell.
+func checkSVG(r io.Reader) error {
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: cannot parse it: %v", ErrUnsafeSVG, err)
+		}
+
+		switch t := tok.(type) {
+		case xml.Directive:
+			if strings.Contains(string(t), "ENTITY") {
+				return fmt.Errorf("%w: it declares entities", ErrUnsafeSVG)
+			}
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			for _, active := range activeElements {
+				if name == active {
+					return fmt.Errorf("%w: it has a <%s> element", ErrUnsafeSVG, t.Name.Local)
+				}
+			}
+			for _, attr := range t.Attr {
+				if strings.HasPrefix(strings.ToLower(attr.Name.Local), "on") {
+					return fmt.Errorf("%w: <%s> has an %s attribute", ErrUnsafeSVG, t.Name.Local, attr.Name.Local)
+				}
+				if scriptURL(attr.Value) {
+					return fmt.Errorf("%w: <%s> links to a script", ErrUnsafeSVG, t.Name.Local)
+				}
+			}
+		}
+	}
+}
+
+// checkSVGFile runs checkSVG on the file at path.
+func checkSVGFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return checkSVG(f)
+}
+
+// scriptURL reports whether an attribute value holds a javascript: URL,
+// possibly hidden by whitespace or case, anywhere in it, e.g. in the values
+// list of an <animate> element.
+func scriptURL(value string) bool {
+	squashed := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, value)
+	return strings.Contains(squashed, "javascript:") || strings.Contains(squashed, "data:text/html")
+}
@@ -0,0 +1,34 @@
+package media
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckSVG(t *testing.T) {
+	tests := []struct {
+		name string
+		svg  string
+		safe bool
+	}{
+		{"plain", `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><a href="https://example.com"><circle r="4" opacity="0.5"/></a></svg>`, true},
+		{"script", `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`, false},
+		{"onload", `<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"></svg>`, false},
+		{"javascript href", `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><a xlink:href=" JavaScript:alert(1)"><rect/></a></svg>`, false},
+		{"encoded javascript", `<svg xmlns="http://www.w3.org/2000/svg"><a href="&#106;ava&#x09;script:alert(1)"><rect/></a></svg>`, false},
+		{"animated href", `<svg xmlns="http://www.w3.org/2000/svg"><a><set attributeName="href" to="javascript:alert(1)"/></a></svg>`, false},
+		{"foreignObject", `<svg xmlns="http://www.w3.org/2000/svg"><foreignObject><iframe xmlns="http://www.w3.org/1999/xhtml" src="https://example.com"/></foreignObject></svg>`, false},
+		{"entities", `<!DOCTYPE svg [<!ENTITY x "y">]><svg xmlns="http://www.w3.org/2000/svg">&x;</svg>`, false},
+		{"malformed", `<svg xmlns="http://www.w3.org/2000/svg"><script>`, false},
+	}
+	for _, tt := range tests {
+		err := checkSVG(strings.NewReader(tt.svg))
+		if tt.safe && err != nil {
+			t.Errorf("%s: expected no error, got %v", tt.name, err)
+		}
+		if !tt.safe && !errors.Is(err, ErrUnsafeSVG) {
+			t.Errorf("%s: expected ErrUnsafeSVG, got %v", tt.name, err)
+		}
+	}
+}
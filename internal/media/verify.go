@@ -0,0 +1,171 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"mariuskimmina.com/leaflet-hugo-sync/internal/atproto"
+)
+
+// ErrIntegrity is returned when blob content does not match its record.
+var ErrIntegrity = errors.New("blob integrity check failed")
+
+// blobCheck verifies content against a blob reference while it is written.
+type blobCheck struct {
+	blob   atproto.Blob
+	digest []byte
+	hash   hash.Hash
+	size   int64
+	head   []byte
+}
+
+func newBlobCheck(blob atproto.Blob) (*blobCheck, error) {
+	c, err := cid.Decode(blob.Ref.Link)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blob CID %q: %w", blob.Ref.Link, err)
+	}
+	mh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("invalid blob CID %q: %w", blob.Ref.Link, err)
+	}
+	if mh.Code != multihash.SHA2_256 {
+		return nil, fmt.Errorf("blob %s: unsupported hash %s", blob.Ref.Link, multihash.Codes[mh.Code])
+	}
+	return &blobCheck{blob: blob, digest: mh.Digest, hash: sha256.New()}, nil
+}
+
+func (c *blobCheck) Write(p []byte) (int, error) {
+	if len(c.head) < 512 {
+		c.head = append(c.head, p[:min(len(p), 512-len(c.head))]...)
+	}
+	c.size += int64(len(p))
+	return c.hash.Write(p)
+}
+
+// contentType returns the sniffed type of the content written so far.
+func (c *blobCheck) contentType() string {
+	return SniffType(c.head)
+}
+
+// verify checks the written content against the CID, size and MIME type of
+// the blob.
+func (c *blobCheck) verify() error {
+	if !bytes.Equal(c.hash.Sum(nil), c.digest) {
+		return fmt.Errorf("%w: %s: content does not match CID", ErrIntegrity, c.blob.Ref.Link)
+	}
+	if c.blob.Size > 0 && c.size != int64(c.blob.Size) {
+		return fmt.Errorf("%w: %s: got %d bytes, record says %d", ErrIntegrity, c.blob.Ref.Link, c.size, c.blob.Size)
+	}
+	if c.blob.Mime != "" {
+		// Only binary signatures are conclusive; a text file may be anything.
+		sniffed, _, _ := mime.ParseMediaType(c.contentType())
+		declared, _, _ := mime.ParseMediaType(c.blob.Mime)
+		conclusive := (strings.HasPrefix(sniffed, "image/") || strings.HasPrefix(sniffed, "video/") ||
+			strings.HasPrefix(sniffed, "audio/") || sniffed == "application/pdf") && sniffed != "image/svg+xml"
+		if conclusive && ExtensionFor(declared) != "" && !sameMediaType(sniffed, declared) {
+			return fmt.Errorf("%w: %s: content is %s, record says %s", ErrIntegrity, c.blob.Ref.Link, sniffed, declared)
+		}
+	}
+	return nil
+}
+
+// verifyContent checks content read from r against blob.
+func verifyContent(r io.Reader, blob atproto.Blob) error {
+	check, err := newBlobCheck(blob)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(check, r); err != nil {
+		return err
+	}
+	return check.verify()
+}
+
+// quarantine moves a temporary file that failed verification out of the
+// way, so it can be inspected later.
+func (d *Downloader) quarantine(path string) error {
+	if d.QuarantineDir == "" {
+		return os.Remove(path)
+	}
+	if err := os.MkdirAll(d.QuarantineDir, 0755); err != nil {
+		return err
+	}
+	dst := quarantineName(d.QuarantineDir, filepath.Base(path))
+	if err := os.Rename(path, dst); err == nil {
+		return nil
+	}
+
+	// WorkDir may be on another file system.
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := copyToFile(dst, f); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// quarantineStored moves a stored file that failed verification out of
+// Storage, so it is neither published nor mistaken for a cached download.
+func (d *Downloader) quarantineStored(ctx context.Context, name string) error {
+	if opener, ok := d.Storage.(Opener); ok && d.QuarantineDir != "" {
+		if err := os.MkdirAll(d.QuarantineDir, 0755); err != nil {
+			return err
+		}
+		r, err := opener.Open(ctx, name)
+		if err != nil {
+			return err
+		}
+		err = copyToFile(quarantineName(d.QuarantineDir, name), r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return d.Storage.Delete(ctx, name)
+}
+
+// quarantineName returns a unique path in dir for a quarantined file.
+func quarantineName(dir, name string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s.%s", name, time.Now().UTC().Format("20060102T150405.000000000")))
+}
+
+func copyToFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// verifySHA256 checks that content read from r has the given hex digest.
+func verifySHA256(r io.Reader, digest, name string) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != digest {
+		return fmt.Errorf("%w: %s: content changed since it was stored", ErrIntegrity, name)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Package scaffold writes a starter config and the Hugo shortcodes used by
+// the generated posts into a Hugo site.
+package scaffold
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ConfigFile is the name of the starter config written into the site root.
+const ConfigFile = ".leaflet-sync.yaml"
+
+//go:embed leaflet-sync.yaml
+var starterConfig string
+
+//go:embed shortcodes
+var shortcodes embed.FS
+
+// handlePlaceholder is replaced with the handle in the starter config.
+const handlePlaceholder = "{{HANDLE}}"
+
+// Files returns the scaffold files keyed by their path relative to the site
+// root, using slash-separated paths.
+func Files(handle string) (map[string][]byte, error) {
+	if handle == "" {
+		handle = "username.bsky.social"
+	}
+	files := map[string][]byte{
+		ConfigFile: []byte(strings.ReplaceAll(starterConfig, handlePlaceholder, handle)),
+	}
+
+	err := fs.WalkDir(shortcodes, "shortcodes", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := shortcodes.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[path.Join("layouts", p)] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// Write writes the scaffold files into siteDir and returns the paths it
+// wrote and skipped. Existing files are skipped unless force is set.
+func Write(siteDir, handle string, force bool) (written, skipped []string, err error) {
+	files, err := Files(handle)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paths := make([]string, 0, len(files))
+	for rel := range files {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	for _, rel := range paths {
+		data := files[rel]
+		dst := filepath.Join(siteDir, filepath.FromSlash(rel))
+		if _, err := os.Stat(dst); err == nil && !force {
+			skipped = append(skipped, dst)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return written, skipped, err
+		}
+		if err := os.WriteFile(dst, data, 0644); err != nil {
+			return written, skipped, fmt.Errorf("writing %s: %w", dst, err)
+		}
+		written = append(written, dst)
+	}
+
+	return written, skipped, nil
+}
@@ -0,0 +1,50 @@
+package scaffold
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	siteDir := t.TempDir()
+
+	written, skipped, err := Write(siteDir, "me.bsky.social", false)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if len(written) != 2 || len(skipped) != 0 {
+		t.Fatalf("expected 2 written and 0 skipped files, got %v and %v", written, skipped)
+	}
+
+	cfg, err := os.ReadFile(filepath.Join(siteDir, ConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(cfg), `handle: "me.bsky.social"`) {
+		t.Errorf("expected handle in starter config, got %q", string(cfg))
+	}
+	if _, err := os.Stat(filepath.Join(siteDir, "layouts", "shortcodes", "bsky.html")); err != nil {
+		t.Errorf("expected bsky shortcode to be written: %v", err)
+	}
+
+	// A second run must not clobber the user's edits
+	if err := os.WriteFile(filepath.Join(siteDir, ConfigFile), []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	written, skipped, err = Write(siteDir, "me.bsky.social", false)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if len(written) != 0 || len(skipped) != 2 {
+		t.Errorf("expected 0 written and 2 skipped files, got %v and %v", written, skipped)
+	}
+	cfg, err = os.ReadFile(filepath.Join(siteDir, ConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cfg) != "edited" {
+		t.Errorf("expected existing config to be kept, got %q", string(cfg))
+	}
+}
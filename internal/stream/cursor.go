@@ -0,0 +1,61 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// cursorFile is the on-disk format of a saved cursor. Cursors of different
+// endpoints aren't comparable, so the endpoint is saved along with it.
+type cursorFile struct {
+	Endpoint string `json:"endpoint"`
+	Cursor   int64  `json:"cursor"`
+}
+
+// LoadCursor returns the cursor saved at path for endpoint. It returns 0 if
+// there is none, or if it belongs to a different endpoint.
+func LoadCursor(path, endpoint string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var saved cursorFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	if saved.Endpoint != endpoint {
+		return 0, nil
+	}
+	return saved.Cursor, nil
+}
+
+// SaveCursor atomically saves cursor for endpoint at path.
+func SaveCursor(path, endpoint string, cursor int64) error {
+	data, err := json.Marshal(cursorFile{Endpoint: endpoint, Cursor: cursor})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cursor-*.json")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,23 @@
+package stream
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "watch-cursor.json")
+	if c, err := LoadCursor(path, DefaultJetstreamURL); err != nil || c != 0 {
+		t.Errorf("expected no cursor, got %d (%v)", c, err)
+	}
+	if err := SaveCursor(path, DefaultJetstreamURL, 1725911162329308); err != nil {
+		t.Fatalf("SaveCursor failed: %v", err)
+	}
+	if c, err := LoadCursor(path, DefaultJetstreamURL); err != nil || c != 1725911162329308 {
+		t.Errorf("expected the saved cursor, got %d (%v)", c, err)
+	}
+	// Cursors of other endpoints don't apply.
+	if c, err := LoadCursor(path, "wss://pds.example.com/xrpc/com.atproto.sync.subscribeRepos"); err != nil || c != 0 {
+		t.Errorf("expected no cursor for another endpoint, got %d (%v)", c, err)
+	}
+}
@@ -0,0 +1,166 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/gorilla/websocket"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// Firehose subscribes to com.atproto.sync.subscribeRepos of a PDS or relay
+// and picks out the commits of one repo. Cursors are sequence numbers of the
+// server.
+type Firehose struct {
+	// URL is the subscribeRepos endpoint, see FirehoseURL.
+	URL        string
+	DID        string
+	Collection string
+	// Dialer connects to URL; websocket.DefaultDialer if nil.
+	Dialer *websocket.Dialer
+	Header http.Header
+}
+
+// FirehoseURL returns the subscribeRepos endpoint of the server at host,
+// e.g. https://pds.example.com.
+func FirehoseURL(host string) (string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/xrpc/com.atproto.sync.subscribeRepos"
+	return u.String(), nil
+}
+
+// Frame header ops of subscribeRepos messages.
+const (
+	opMessage = 1
+	opError   = -1
+)
+
+func (f *Firehose) Subscribe(ctx context.Context, cursor int64, fn func(Event) error) error {
+	u, err := url.Parse(f.URL)
+	if err != nil {
+		return fmt.Errorf("invalid firehose URL: %w", err)
+	}
+	if cursor > 0 {
+		q := u.Query()
+		q.Set("cursor", strconv.FormatInt(cursor, 10))
+		u.RawQuery = q.Encode()
+	}
+
+	return readMessages(ctx, f.Dialer, u.String(), f.Header, func(data []byte) error {
+		r := bytes.NewReader(data)
+		op, msgType, err := readFrameHeader(r)
+		if err != nil {
+			return fmt.Errorf("decoding frame header: %w", err)
+		}
+		if op == opError {
+			fields, _ := readStringMap(r)
+			return fmt.Errorf("firehose error %s: %s", fields["error"], fields["message"])
+		}
+		if op != opMessage || msgType != "#commit" {
+			return nil
+		}
+
+		var commit comatproto.SyncSubscribeRepos_Commit
+		if err := commit.UnmarshalCBOR(r); err != nil {
+			return fmt.Errorf("decoding commit: %w", err)
+		}
+		if commit.Repo != f.DID {
+			return fn(Event{Cursor: commit.Seq, DID: commit.Repo})
+		}
+		delivered := false
+		for _, o := range commit.Ops {
+			collection, rkey, ok := strings.Cut(o.Path, "/")
+			if !ok || collection != f.Collection {
+				continue
+			}
+			delivered = true
+			evt := Event{Cursor: commit.Seq, DID: commit.Repo, Collection: collection, RKey: rkey, Action: knownAction(o.Action)}
+			if err := fn(evt); err != nil {
+				return err
+			}
+		}
+		if !delivered {
+			return fn(Event{Cursor: commit.Seq, DID: commit.Repo})
+		}
+		return nil
+	})
+}
+
+// readFrameHeader decodes the {op, t} map that precedes every message.
+func readFrameHeader(r *bytes.Reader) (op int64, msgType string, err error) {
+	cr := cbg.NewCborReader(r)
+	maj, n, err := cr.ReadHeader()
+	if err != nil {
+		return 0, "", err
+	}
+	if maj != cbg.MajMap {
+		return 0, "", fmt.Errorf("expected a map, got major type %d", maj)
+	}
+	for i := uint64(0); i < n; i++ {
+		key, err := cbg.ReadString(cr)
+		if err != nil {
+			return 0, "", err
+		}
+		switch key {
+		case "op":
+			maj, v, err := cr.ReadHeader()
+			if err != nil {
+				return 0, "", err
+			}
+			switch maj {
+			case cbg.MajUnsignedInt:
+				op = int64(v)
+			case cbg.MajNegativeInt:
+				op = -1 - int64(v)
+			default:
+				return 0, "", fmt.Errorf("expected an integer op, got major type %d", maj)
+			}
+		case "t":
+			if msgType, err = cbg.ReadString(cr); err != nil {
+				return 0, "", err
+			}
+		default:
+			return 0, "", fmt.Errorf("unexpected header field %q", key)
+		}
+	}
+	return op, msgType, nil
+}
+
+// readStringMap decodes a map of strings, such as the body of an error
+// frame.
+func readStringMap(r *bytes.Reader) (map[string]string, error) {
+	cr := cbg.NewCborReader(r)
+	maj, n, err := cr.ReadHeader()
+	if err != nil {
+		return nil, err
+	}
+	if maj != cbg.MajMap {
+		return nil, fmt.Errorf("expected a map, got major type %d", maj)
+	}
+	fields := make(map[string]string, n)
+	for i := uint64(0); i < n; i++ {
+		key, err := cbg.ReadString(cr)
+		if err != nil {
+			return nil, err
+		}
+		if fields[key], err = cbg.ReadString(cr); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
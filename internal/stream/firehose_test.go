@@ -0,0 +1,121 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/gorilla/websocket"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// frame encodes a subscribeRepos message: the {op, t} header followed by
+// body, if any.
+func frame(t *testing.T, op int64, msgType string, body cbg.CBORMarshaler) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	cw := cbg.NewCborWriter(&buf)
+	fields := uint64(1)
+	if msgType != "" {
+		fields = 2
+	}
+	cw.WriteMajorTypeHeader(cbg.MajMap, fields)
+	writeCBORString(cw, "op")
+	if op < 0 {
+		cw.WriteMajorTypeHeader(cbg.MajNegativeInt, uint64(-1-op))
+	} else {
+		cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(op))
+	}
+	if msgType != "" {
+		writeCBORString(cw, "t")
+		writeCBORString(cw, msgType)
+	}
+	if body != nil {
+		if err := body.MarshalCBOR(&buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func writeCBORString(cw *cbg.CborWriter, s string) {
+	cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(s)))
+	cw.Write([]byte(s))
+}
+
+// errorFrame is the body of an error frame.
+type errorFrame struct {
+	name, message string
+}
+
+func (e errorFrame) MarshalCBOR(w io.Writer) error {
+	cw := cbg.NewCborWriter(w)
+	cw.WriteMajorTypeHeader(cbg.MajMap, 2)
+	writeCBORString(cw, "error")
+	writeCBORString(cw, e.name)
+	writeCBORString(cw, "message")
+	writeCBORString(cw, e.message)
+	return nil
+}
+
+func testCommit(t *testing.T, seq int64, repo string, ops ...*comatproto.SyncSubscribeRepos_RepoOp) *comatproto.SyncSubscribeRepos_Commit {
+	t.Helper()
+	hash, err := multihash.Sum([]byte("commit"), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &comatproto.SyncSubscribeRepos_Commit{
+		Seq:    seq,
+		Repo:   repo,
+		Rev:    "3lrev",
+		Commit: lexutil.LexLink(cid.NewCidV1(cid.DagCBOR, hash)),
+		Ops:    ops,
+		Blobs:  []lexutil.LexLink{},
+		Time:   "2024-01-01T00:00:00Z",
+	}
+}
+
+func TestFirehose(t *testing.T) {
+	srv, urls := wsServer(t, websocket.BinaryMessage,
+		frame(t, opMessage, "#commit", testCommit(t, 7, "did:plc:someoneelse",
+			&comatproto.SyncSubscribeRepos_RepoOp{Action: "create", Path: "pub.leaflet.document/3xxx"})),
+		frame(t, opMessage, "#identity", &comatproto.SyncSubscribeRepos_Identity{Did: testDID, Seq: 8}),
+		frame(t, opMessage, "#commit", testCommit(t, 9, testDID,
+			&comatproto.SyncSubscribeRepos_RepoOp{Action: "update", Path: "pub.leaflet.document/3aaa"},
+			&comatproto.SyncSubscribeRepos_RepoOp{Action: "create", Path: "app.bsky.feed.post/3bbb"},
+			&comatproto.SyncSubscribeRepos_RepoOp{Action: "delete", Path: "pub.leaflet.document/3ccc"})),
+	)
+	defer srv.Close()
+
+	src := &Firehose{URL: wsURL(srv, "/xrpc/com.atproto.sync.subscribeRepos"), DID: testDID, Collection: "pub.leaflet.document"}
+	events := collect(t, src, 5, 3)
+
+	if u := <-urls; u != "/xrpc/com.atproto.sync.subscribeRepos?cursor=5" {
+		t.Errorf("unexpected request %s", u)
+	}
+	if e := events[0]; e.Action != "" || e.Cursor != 7 {
+		t.Errorf("expected commits of other repos to only advance the cursor, got %+v", e)
+	}
+	if e := events[1]; e.Action != ActionUpdate || e.URI() != "at://did:plc:abc123/pub.leaflet.document/3aaa" || e.Cursor != 9 {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if e := events[2]; e.Action != ActionDelete || e.RKey != "3ccc" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestFirehose_ErrorFrame(t *testing.T) {
+	srv, _ := wsServer(t, websocket.BinaryMessage, frame(t, opError, "", errorFrame{"FutureCursor", "Cursor in the future"}))
+	defer srv.Close()
+
+	src := &Firehose{URL: wsURL(srv, "/xrpc/com.atproto.sync.subscribeRepos"), DID: testDID, Collection: "pub.leaflet.document"}
+	err := src.Subscribe(t.Context(), 1<<40, func(Event) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "FutureCursor") {
+		t.Errorf("expected the error frame to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultJetstreamURL is a public Jetstream instance run by Bluesky.
+const DefaultJetstreamURL = "wss://jetstream2.us-east.bsky.network/subscribe"
+
+// Jetstream subscribes to a Jetstream instance, which filters the network's
+// events by repo and collection and sends them as JSON. Cursors are Unix
+// timestamps in microseconds.
+type Jetstream struct {
+	// URL is the subscribe endpoint, e.g. DefaultJetstreamURL.
+	URL        string
+	DID        string
+	Collection string
+	// Dialer connects to URL; websocket.DefaultDialer if nil.
+	Dialer *websocket.Dialer
+	Header http.Header
+}
+
+// jetstreamEvent is a message from Jetstream.
+type jetstreamEvent struct {
+	DID    string `json:"did"`
+	TimeUS int64  `json:"time_us"`
+	Kind   string `json:"kind"`
+	Commit *struct {
+		Operation  string `json:"operation"`
+		Collection string `json:"collection"`
+		RKey       string `json:"rkey"`
+	} `json:"commit"`
+}
+
+// Now returns the cursor of the current time, to start a subscription
+// without replaying the past.
+func (j *Jetstream) Now() int64 {
+	return time.Now().UnixMicro()
+}
+
+func (j *Jetstream) Subscribe(ctx context.Context, cursor int64, fn func(Event) error) error {
+	u, err := url.Parse(j.URL)
+	if err != nil {
+		return fmt.Errorf("invalid Jetstream URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("wantedDids", j.DID)
+	q.Set("wantedCollections", j.Collection)
+	if cursor > 0 {
+		q.Set("cursor", strconv.FormatInt(cursor, 10))
+	}
+	u.RawQuery = q.Encode()
+
+	return readMessages(ctx, j.Dialer, u.String(), j.Header, func(data []byte) error {
+		var msg jetstreamEvent
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return fmt.Errorf("decoding Jetstream event: %w", err)
+		}
+		evt := Event{Cursor: msg.TimeUS, DID: msg.DID}
+		if msg.Kind == "commit" && msg.Commit != nil && msg.DID == j.DID && msg.Commit.Collection == j.Collection {
+			evt.Collection = msg.Commit.Collection
+			evt.RKey = msg.Commit.RKey
+			evt.Action = knownAction(msg.Commit.Operation)
+		}
+		return fn(evt)
+	})
+}
@@ -0,0 +1,113 @@
+// Package stream subscribes to live record changes of a repo, either via a
+// Jetstream instance or via com.atproto.sync.subscribeRepos.
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Actions of an Event.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Event is a change to a record.
+type Event struct {
+	// Cursor resumes the stream after this event.
+	Cursor     int64
+	DID        string
+	Collection string
+	RKey       string
+	// Action is one of ActionCreate, ActionUpdate and ActionDelete. It is
+	// empty for events that only advance the cursor, such as changes in
+	// other repos.
+	Action string
+}
+
+// URI returns the AT-URI of the changed record.
+func (e Event) URI() string {
+	return fmt.Sprintf("at://%s/%s/%s", e.DID, e.Collection, e.RKey)
+}
+
+// knownAction returns a if it is one of the actions above, or "" so that
+// unknown operations only advance the cursor.
+func knownAction(a string) string {
+	switch a {
+	case ActionCreate, ActionUpdate, ActionDelete:
+		return a
+	}
+	return ""
+}
+
+// Source delivers changes to the records of one collection in one repo.
+type Source interface {
+	// Subscribe calls fn for every event after cursor, or for live events
+	// only if cursor is 0. It returns when ctx is done, the connection
+	// fails or fn returns an error.
+	Subscribe(ctx context.Context, cursor int64, fn func(Event) error) error
+}
+
+// pingInterval is how often the connection is checked. A connection that
+// doesn't answer within two intervals is considered dead.
+const pingInterval = 30 * time.Second
+
+// readMessages connects to url and calls fn for every message until ctx is
+// done, the connection fails or fn returns an error.
+func readMessages(ctx context.Context, dialer *websocket.Dialer, url string, header http.Header, fn func(data []byte) error) error {
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	conn, resp, err := dialer.DialContext(ctx, url, header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("connecting to %s: %w (%s)", url, err, resp.Status)
+		}
+		return fmt.Errorf("connecting to %s: %w", url, err)
+	}
+	defer conn.Close()
+
+	// Unblock ReadMessage when ctx is done, and keep the connection alive.
+	done := make(chan struct{})
+	defer close(done)
+	conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	})
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				conn.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingInterval))
+			}
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("reading from %s: %w", url, err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+}
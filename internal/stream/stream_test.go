@@ -0,0 +1,141 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+const testDID = "did:plc:abc123"
+
+// wsServer serves a WebSocket endpoint that records the request URL and
+// sends messages, then waits until the client goes away.
+func wsServer(t *testing.T, messageType int, messages ...[]byte) (*httptest.Server, chan string) {
+	t.Helper()
+	urls := make(chan string, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		urls <- r.URL.String()
+		for _, m := range messages {
+			if err := conn.WriteMessage(messageType, m); err != nil {
+				return
+			}
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	return srv, urls
+}
+
+func wsURL(srv *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + path
+}
+
+// collect subscribes until n events arrived.
+func collect(t *testing.T, src Source, cursor int64, n int) []Event {
+	t.Helper()
+	var events []Event
+	done := errors.New("done")
+	err := src.Subscribe(context.Background(), cursor, func(e Event) error {
+		events = append(events, e)
+		if len(events) == n {
+			return done
+		}
+		return nil
+	})
+	if !errors.Is(err, done) {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	return events
+}
+
+func TestJetstream(t *testing.T) {
+	srv, urls := wsServer(t, websocket.TextMessage,
+		[]byte(`{"did":"did:plc:abc123","time_us":100,"kind":"commit","commit":{"rev":"1","operation":"create","collection":"pub.leaflet.document","rkey":"3aaa","record":{"title":"Hello"},"cid":"bafy"}}`),
+		[]byte(`{"did":"did:plc:abc123","time_us":101,"kind":"identity","identity":{"did":"did:plc:abc123","handle":"alice.test"}}`),
+		[]byte(`{"did":"did:plc:abc123","time_us":102,"kind":"commit","commit":{"rev":"2","operation":"delete","collection":"pub.leaflet.document","rkey":"3aaa"}}`),
+	)
+	defer srv.Close()
+
+	src := &Jetstream{URL: wsURL(srv, "/subscribe"), DID: testDID, Collection: "pub.leaflet.document"}
+	events := collect(t, src, 42, 3)
+
+	u := <-urls
+	for _, param := range []string{"wantedDids=did%3Aplc%3Aabc123", "wantedCollections=pub.leaflet.document", "cursor=42"} {
+		if !strings.Contains(u, param) {
+			t.Errorf("expected %s in %s", param, u)
+		}
+	}
+	if e := events[0]; e.Action != ActionCreate || e.URI() != "at://did:plc:abc123/pub.leaflet.document/3aaa" || e.Cursor != 100 {
+		t.Errorf("unexpected first event %+v", e)
+	}
+	if e := events[1]; e.Action != "" || e.Cursor != 101 {
+		t.Errorf("expected the identity event to only advance the cursor, got %+v", e)
+	}
+	if e := events[2]; e.Action != ActionDelete || e.Cursor != 102 {
+		t.Errorf("unexpected last event %+v", e)
+	}
+}
+
+func TestSubscribe_Canceled(t *testing.T) {
+	srv, _ := wsServer(t, websocket.TextMessage)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		src := &Jetstream{URL: wsURL(srv, "/subscribe"), DID: testDID, Collection: "pub.leaflet.document"}
+		errc <- src.Subscribe(ctx, 0, func(Event) error { return nil })
+	}()
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestSubscribe_ConnectionLost(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	src := &Jetstream{URL: wsURL(srv, "/subscribe"), DID: testDID, Collection: "pub.leaflet.document"}
+	if err := src.Subscribe(context.Background(), 0, func(Event) error { return nil }); err == nil {
+		t.Error("expected error for a closed connection, got nil")
+	}
+}
+
+func TestFirehoseURL(t *testing.T) {
+	got, err := FirehoseURL("https://pds.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "wss://pds.example.com/xrpc/com.atproto.sync.subscribeRepos"; got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestKnownAction(t *testing.T) {
+	if a := knownAction("update"); a != ActionUpdate {
+		t.Errorf("expected update, got %q", a)
+	}
+	if a := knownAction("resync"); a != "" {
+		t.Errorf("expected unknown actions to be dropped, got %q", a)
+	}
+}
@@ -0,0 +1,253 @@
+// Package templatefuncs provides the function library available to the
+// frontmatter and content templates.
+package templatefuncs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+)
+
+// dateLayouts are tried in order when parsing a date string.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// FuncMap returns the functions available to templates.
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"now":         time.Now,
+		"parseDate":   ParseDate,
+		"date":        FormatDate,
+		"dateIn":      FormatDateIn,
+		"slugify":     Slugify,
+		"yamlQuote":   YAMLQuote,
+		"toJSON":      ToJSON,
+		"join":        Join,
+		"truncate":    Truncate,
+		"default":     Default,
+		"plainify":    Plainify,
+		"markdownify": Markdownify,
+		"lower":       strings.ToLower,
+		"upper":       strings.ToUpper,
+		"trim":        strings.TrimSpace,
+		"replace":     Replace,
+	}
+}
+
+// ParseDate parses an ATProto timestamp (or a plain date) into a time.Time.
+// time.Time values are returned unchanged.
+func ParseDate(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, fmt.Errorf("parseDate: nil time")
+		}
+		return *t, nil
+	case string:
+		s := strings.TrimSpace(t)
+		for _, layout := range dateLayouts {
+			if parsed, err := time.Parse(layout, s); err == nil {
+				return parsed, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("parseDate: unrecognised date %q", t)
+	default:
+		return time.Time{}, fmt.Errorf("parseDate: unsupported type %T", v)
+	}
+}
+
+// FormatDate formats a date using a Go reference layout, e.g.
+// {{ .CreatedAt | date "2006-01-02" }}.
+func FormatDate(layout string, v interface{}) (string, error) {
+	t, err := ParseDate(v)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(layout), nil
+}
+
+// FormatDateIn converts a date to an IANA timezone before formatting it, e.g.
+// {{ .CreatedAt | dateIn "Europe/Berlin" "2006-01-02T15:04:05-07:00" }}.
+func FormatDateIn(tz string, layout string, v interface{}) (string, error) {
+	t, err := ParseDate(v)
+	if err != nil {
+		return "", err
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return "", fmt.Errorf("dateIn: %w", err)
+	}
+	return t.In(loc).Format(layout), nil
+}
+
+// Slugify lowercases s and replaces every run of characters that are not
+// letters or digits with a single hyphen.
+func Slugify(s string) string {
+	var sb strings.Builder
+	pendingDash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if pendingDash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			pendingDash = false
+			sb.WriteRune(r)
+			continue
+		}
+		pendingDash = true
+	}
+	return sb.String()
+}
+
+// YAMLQuote returns s as a double-quoted YAML scalar.
+func YAMLQuote(v interface{}) (string, error) {
+	// A JSON string is a valid YAML double-quoted scalar.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fmt.Sprint(v)); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// ToJSON encodes v as compact JSON, which is also valid YAML flow syntax.
+func ToJSON(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// Join concatenates the elements of a slice, e.g. {{ .Tags | join ", " }}.
+func Join(sep string, v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	if ss, ok := v.([]string); ok {
+		return strings.Join(ss, sep), nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: unsupported type %T", v)
+	}
+	parts := make([]string, rv.Len())
+	for i := range parts {
+		parts[i] = fmt.Sprint(rv.Index(i).Interface())
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// Truncate shortens s to at most n runes, cutting at a word boundary where
+// possible and ending with an ellipsis, which counts towards n, when anything
+// was removed.
+func Truncate(n int, s string) string {
+	if n <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	cut := string(runes[:n-1])
+	// Back off to the last word boundary unless the cut already is one.
+	if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > 0 && !unicode.IsSpace(runes[n-1]) {
+		cut = cut[:i]
+	}
+	return strings.TrimRightFunc(cut, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + "…"
+}
+
+// Default returns def if v is empty (nil, zero, or an empty string, slice or
+// map), e.g. {{ .Description | default "No description" }}.
+func Default(def interface{}, v interface{}) interface{} {
+	if v == nil {
+		return def
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if rv.Len() == 0 {
+			return def
+		}
+	default:
+		if rv.IsZero() {
+			return def
+		}
+	}
+	return v
+}
+
+// Replace replaces all occurrences of old with new, e.g.
+// {{ .Title | replace "_" " " }}.
+func Replace(old, replacement, s string) string {
+	return strings.ReplaceAll(s, old, replacement)
+}
+
+var (
+	reShortcode = regexp.MustCompile(`\{\{[<%].*?[>%]\}\}`)
+	reImage     = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	reLink      = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	reHTMLTag   = regexp.MustCompile(`<[^>]+>`)
+	reFence     = regexp.MustCompile("(?m)^```.*$")
+	reHeading   = regexp.MustCompile(`(?m)^\s{0,3}#{1,6}\s+`)
+	reListMark  = regexp.MustCompile(`(?m)^\s*(?:[-*+]|\d+\.)\s+`)
+	reQuoteMark = regexp.MustCompile(`(?m)^\s*>\s?`)
+	reEmphasis  = regexp.MustCompile("[*_~`]+")
+	reSpaces    = regexp.MustCompile(`\s+`)
+)
+
+// Plainify strips Markdown, HTML and Hugo shortcodes from s and collapses
+// whitespace, which is useful for deriving descriptions from post content.
+func Plainify(s string) string {
+	s = reShortcode.ReplaceAllString(s, "")
+	s = reImage.ReplaceAllString(s, "$1")
+	s = reLink.ReplaceAllString(s, "$1")
+	s = reHTMLTag.ReplaceAllString(s, "")
+	s = reFence.ReplaceAllString(s, "")
+	s = reHeading.ReplaceAllString(s, "")
+	s = reListMark.ReplaceAllString(s, "")
+	s = reQuoteMark.ReplaceAllString(s, "")
+	s = reEmphasis.ReplaceAllString(s, "")
+	return strings.TrimSpace(reSpaces.ReplaceAllString(s, " "))
+}
+
+// markdown renders Markdown like Hugo does by default. Raw HTML is omitted.
+var markdown = goldmark.New()
+
+// Markdownify renders Markdown in s as HTML, e.g. for a description with
+// emphasis in a theme that prints it unescaped. Like Hugo's markdownify, a
+// single paragraph is returned without its <p> tags.
+func Markdownify(s string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(s), &buf); err != nil {
+		return "", fmt.Errorf("markdownify: %w", err)
+	}
+	html := strings.TrimSpace(buf.String())
+	if inner, ok := strings.CutPrefix(html, "<p>"); ok {
+		if inner, ok = strings.CutSuffix(inner, "</p>"); ok && !strings.Contains(inner, "<p>") {
+			return inner, nil
+		}
+	}
+	return html, nil
+}
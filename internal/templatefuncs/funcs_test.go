@@ -0,0 +1,91 @@
+package templatefuncs
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+)
+
+func TestFuncMap_InTemplate(t *testing.T) {
+	tmpl := `title: {{ .Title | yamlQuote }}
+date: {{ .CreatedAt | date "2006-01-02" }}
+local: {{ .CreatedAt | dateIn "Europe/Berlin" "15:04" }}
+slug: {{ .Title | slugify }}
+tags: {{ .Tags | toJSON }}
+keywords: {{ .Tags | join ", " }}
+description: {{ .Description | default "none" }}`
+
+	data := map[string]interface{}{
+		"Title":       `Hello "World": Part 2`,
+		"CreatedAt":   "2024-01-15T23:30:00.000Z",
+		"Tags":        []string{"go", "hugo"},
+		"Description": "",
+	}
+
+	parsed, err := template.New("test").Funcs(FuncMap()).Parse(tmpl)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := parsed.Execute(&buf, data); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	expected := `title: "Hello \"World\": Part 2"
+date: 2024-01-15
+local: 00:30
+slug: hello-world-part-2
+tags: ["go","hugo"]
+keywords: go, hugo
+description: none`
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		n        int
+		in       string
+		expected string
+	}{
+		{20, "short", "short"},
+		{21, "Hello wonderful world", "Hello wonderful world"},
+		{12, "Hello wonderful world", "Hello…"},
+		{16, "Hello wonderful world", "Hello wonderful…"},
+		{5, "Überlänge", "Über…"},
+		{1, "Überlänge", "…"},
+	}
+
+	for _, tt := range tests {
+		if got := Truncate(tt.n, tt.in); got != tt.expected {
+			t.Errorf("Truncate(%d, %q) = %q, expected %q", tt.n, tt.in, got, tt.expected)
+		}
+	}
+}
+
+func TestMarkdownify(t *testing.T) {
+	tests := map[string]string{
+		"Some **bold** text":            "Some <strong>bold</strong> text",
+		"A [link](https://example.com)": `A <a href="https://example.com">link</a>`,
+		"One\n\nTwo":                    "<p>One</p>\n<p>Two</p>",
+		"Raw <script>x</script>":        "Raw <!-- raw HTML omitted -->x<!-- raw HTML omitted -->",
+	}
+	for in, expected := range tests {
+		got, err := Markdownify(in)
+		if err != nil {
+			t.Fatalf("Markdownify(%q) failed: %v", in, err)
+		}
+		if got != expected {
+			t.Errorf("Markdownify(%q) = %q, expected %q", in, got, expected)
+		}
+	}
+}
+
+func TestPlainify(t *testing.T) {
+	in := "# Heading\n\nSome **bold** text with a [link](https://example.com).\n\n![alt](/img.png)\n\n{{< bsky did=\"x\" >}}"
+	expected := "Heading Some bold text with a link. alt"
+	if got := Plainify(in); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}